@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"github.com/placeholder14032/download-manager/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
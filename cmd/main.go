@@ -1,17 +1,90 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/placeholder14032/download-manager/internal/api"
 	"github.com/placeholder14032/download-manager/internal/controller"
+	"github.com/placeholder14032/download-manager/internal/daemon"
 	"github.com/placeholder14032/download-manager/internal/manager"
 	"github.com/placeholder14032/download-manager/internal/util"
 	"github.com/placeholder14032/download-manager/ui"
 )
 
 func main() {
+	listen := flag.String("listen", "", "address to serve the http api on, e.g. 127.0.0.1:7878. the api is off if empty")
+	token := flag.String("token", os.Getenv("DM_API_TOKEN"), "bearer token the http api asks for (defaults to $DM_API_TOKEN)")
+	headless := flag.Bool("headless", false, "don't start the ui, only serve the http api")
+	runDaemon := flag.Bool("daemon", false, "run as a daemon on the unix socket instead of starting the ui")
+	attach := flag.Bool("attach", false, "start only the ui and use the manager of the running daemon")
+	socket := flag.String("socket", daemon.DefaultSocketPath(), "socket of the daemon")
+	flag.Parse()
+	if *headless && *listen == "" {
+		fmt.Fprintln(os.Stderr, "-headless needs -listen, otherwise there is no way to talk to the manager")
+		os.Exit(2)
+	}
+
+	if *runDaemon {
+		if err := daemon.Run(daemon.Options{Socket: *socket, Listen: *listen, Token: *token}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *attach {
+		client, err := daemon.Dial(*socket)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "can't reach the daemon:", err)
+			os.Exit(1)
+		}
+		defer client.Close()
+		controller.SetSender(client)
+		events, unsubscribe, err := client.Subscribe()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "no live updates from the daemon:", err)
+		} else {
+			defer unsubscribe()
+		}
+		ui.Main(events) // the downloads keep going when the ui is closed
+		return
+	}
+
 	var reqs = make(chan util.Request)
 	var resps = make(chan util.Response)
-	controller.SetChannels(reqs, resps)
+	sender := controller.NewChannelSender(reqs, resps)
+	controller.SetSender(sender)
 	var manager = manager.Manager{}
 	go manager.Start(reqs, resps)
-	ui.Main()
+
+	if *listen != "" {
+		server := api.NewServer(sender, &manager, *token)
+		if *headless {
+			fmt.Fprintln(os.Stderr, "serving the api on", *listen)
+			go func() {
+				if err := http.ListenAndServe(*listen, server); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}()
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+			<-sigs
+			manager.Shutdown() // saves everything
+			return
+		}
+		go func() {
+			if err := http.ListenAndServe(*listen, server); err != nil {
+				fmt.Fprintln(os.Stderr, "http api stopped:", err)
+			}
+		}()
+	}
+	events, unsubscribe := manager.Subscribe()
+	defer unsubscribe()
+	ui.Main(events)
+	manager.Shutdown() // closing the ui stops the downloads. they go on next time
 }
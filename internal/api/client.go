@@ -0,0 +1,151 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// Client is the other end of Server. it implements controller.Sender so
+// anything written against the controller works with a manager running
+// somewhere else just the same
+type Client struct {
+	base  string // e.g. http://127.0.0.1:7878
+	token string
+	http  *http.Client
+}
+
+func NewClient(base, token string) *Client {
+	return &Client{
+		base:  strings.TrimSuffix(base, "/"),
+		token: token,
+		http:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func fail(kind util.FailureKind, format string, a ...any) util.Response {
+	return util.Response{Type: util.FAIL, Body: util.FailureMessage{Message: fmt.Sprintf(format, a...), Kind: kind}}
+}
+
+func (c *Client) SendReq(r util.Request) util.Response {
+	switch r.Type {
+	case util.AddDownload:
+		return c.do("POST", "/api/downloads", r.Body, &util.DownloadBody{})
+	case util.StartDownload, util.PauseDownload, util.ResumeDownload, util.CancelDownload, util.RetryDownload:
+		body, ok := r.Body.(util.BodyModDownload)
+		if !ok {
+			return fail(util.BadRequest, "bad body for %s", r.Type)
+		}
+		for action, t := range downloadActions {
+			if t == r.Type {
+				return c.do("POST", fmt.Sprintf("/api/downloads/%d/%s", body.ID, action), nil, nil)
+			}
+		}
+	case util.DeleteDownload:
+		body, ok := r.Body.(util.BodyModDownload)
+		if !ok {
+			return fail(util.BadRequest, "bad body for %s", r.Type)
+		}
+		return c.do("DELETE", fmt.Sprintf("/api/downloads/%d", body.ID), nil, nil)
+	case util.ReorderDownload:
+		body, ok := r.Body.(util.BodyReorderDownload)
+		if !ok {
+			return fail(util.BadRequest, "bad body for %s", r.Type)
+		}
+		return c.do("POST", fmt.Sprintf("/api/downloads/%d/move", body.ID), body, nil)
+	case util.SetPriority:
+		body, ok := r.Body.(util.BodySetPriority)
+		if !ok {
+			return fail(util.BadRequest, "bad body for %s", r.Type)
+		}
+		return c.do("PUT", fmt.Sprintf("/api/downloads/%d/priority", body.ID), body, nil)
+	case util.MoveDownload:
+		body, ok := r.Body.(util.BodyMoveDownload)
+		if !ok {
+			return fail(util.BadRequest, "bad body for %s", r.Type)
+		}
+		return c.do("POST", fmt.Sprintf("/api/downloads/%d/queue", body.ID), body, nil)
+	case util.SetBandwidth:
+		return c.do("PUT", "/api/bandwidth", r.Body, nil)
+	case util.SetBandwidthSchedule:
+		return c.do("PUT", "/api/bandwidth/schedule", r.Body, nil)
+	case util.AddQueue:
+		return c.do("POST", "/api/queues", r.Body, &util.QueueBody{})
+	case util.EditQueue, util.DeleteQueue:
+		body, ok := r.Body.(util.QueueBody)
+		if !ok {
+			return fail(util.BadRequest, "bad body for %s", r.Type)
+		}
+		path := fmt.Sprintf("/api/queues/%d", body.ID)
+		if r.Type == util.DeleteQueue {
+			return c.do("DELETE", path, nil, nil)
+		}
+		return c.do("PUT", path, body, nil)
+	case util.GetQueues:
+		return c.do("GET", "/api/queues", nil, &util.StaticQueueList{})
+	case util.GetDownloads:
+		return c.do("GET", "/api/downloads", nil, &util.StaticDownloadList{})
+	}
+	return fail(util.BadRequest, "the http api doesn't support %s", r.Type)
+}
+
+// sends the request and decodes the answer into out (a pointer to one of
+// the util bodies). the response body is out dereferenced so it looks
+// exactly like what the manager would have answered
+func (c *Client) do(method, path string, in any, out any) util.Response {
+	var reader io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fail(util.BadRequest, "can't encode the request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return fail(util.BadRequest, "%v", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fail(util.BadRequest, "can't reach the manager: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var msg util.FailureMessage
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil || msg.Message == "" {
+			return fail(kindForStatus(resp.StatusCode), "server returned %s", resp.Status)
+		}
+		return util.Response{Type: util.FAIL, Body: msg}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return util.Response{Type: util.OK}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fail(util.BadRequest, "bad answer from the server: %v", err)
+	}
+	var body any
+	switch v := out.(type) {
+	case *util.DownloadBody:
+		body = *v
+	case *util.QueueBody:
+		body = *v
+	case *util.StaticQueueList:
+		body = *v
+	case *util.StaticDownloadList:
+		body = *v
+	}
+	return util.Response{Type: util.OK, Body: body}
+}
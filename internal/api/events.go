@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// how often an idle stream gets a comment so proxies don't close it
+const SSE_KEEPALIVE = 15 * time.Second
+
+// whatever publishes the download events. the manager does
+type EventSource interface {
+	Subscribe() (<-chan util.Event, func())
+}
+
+// streams events as server-sent events. every event is sent as
+//
+//	event: <type>
+//	data: <util.Event as json>
+//
+// an optional ?id=<download id> only sends the events of that download
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		writeError(w, http.StatusNotFound, "event streaming is not available")
+		return
+	}
+	var only int64 = -1
+	if q := r.URL.Query().Get("id"); q != "" {
+		id, err := strconv.ParseInt(q, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("bad download id %q", q))
+			return
+		}
+		only = id
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	events, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(SSE_KEEPALIVE)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-events:
+			if !ok {
+				return // the manager went away
+			}
+			if only != -1 && e.DownloadID != only {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
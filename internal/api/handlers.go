@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// the actions that can be done on a single download with POST /api/downloads/{id}/{action}
+var downloadActions = map[string]util.RequestType{
+	"start":  util.StartDownload,
+	"pause":  util.PauseDownload,
+	"resume": util.ResumeDownload,
+	"cancel": util.CancelDownload,
+	"retry":  util.RetryDownload,
+}
+
+func (s *Server) listDownloads(w http.ResponseWriter, r *http.Request) {
+	resp := s.sender.SendReq(util.Request{Type: util.GetDownloads})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+func (s *Server) addDownload(w http.ResponseWriter, r *http.Request) {
+	var body util.BodyAddDownload
+	if !readBody(w, r, &body) {
+		return
+	}
+	resp := s.sender.SendReq(util.Request{Type: util.AddDownload, Body: body})
+	writeResponse(w, resp, http.StatusCreated)
+}
+
+func (s *Server) modDownload(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	t, ok := downloadActions[r.PathValue("action")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown action: "+r.PathValue("action"))
+		return
+	}
+	resp := s.sender.SendReq(util.Request{Type: t, Body: util.BodyModDownload{ID: id}})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+func (s *Server) deleteDownload(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	resp := s.sender.SendReq(util.Request{Type: util.DeleteDownload, Body: util.BodyModDownload{ID: id}})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+// body is {"Move": "up"|"down"|"top"|"bottom"}
+func (s *Server) reorderDownload(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var body util.BodyReorderDownload
+	if !readBody(w, r, &body) {
+		return
+	}
+	body.ID = id
+	resp := s.sender.SendReq(util.Request{Type: util.ReorderDownload, Body: body})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+// body is {"Priority": 5}
+func (s *Server) setPriority(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var body util.BodySetPriority
+	if !readBody(w, r, &body) {
+		return
+	}
+	body.ID = id
+	resp := s.sender.SendReq(util.Request{Type: util.SetPriority, Body: body})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+// body is {"QueueID": 2, "MoveFiles": true}
+func (s *Server) moveDownload(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var body util.BodyMoveDownload
+	if !readBody(w, r, &body) {
+		return
+	}
+	body.ID = id
+	resp := s.sender.SendReq(util.Request{Type: util.MoveDownload, Body: body})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+// body is {"Scope": "global"|"queue"|"download", "ID": 2, "Limit": 1048576}
+func (s *Server) setBandwidth(w http.ResponseWriter, r *http.Request) {
+	var body util.BodySetBandwidth
+	if !readBody(w, r, &body) {
+		return
+	}
+	resp := s.sender.SendReq(util.Request{Type: util.SetBandwidth, Body: body})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+// body is {"Scope": "global"|"queue", "ID": 2, "Rules": [{"Window": "mon-fri 09:00-17:00", "Limit": 524288}]}
+func (s *Server) setBandwidthSchedule(w http.ResponseWriter, r *http.Request) {
+	var body util.BodySetBandwidthSchedule
+	if !readBody(w, r, &body) {
+		return
+	}
+	resp := s.sender.SendReq(util.Request{Type: util.SetBandwidthSchedule, Body: body})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+func (s *Server) listQueues(w http.ResponseWriter, r *http.Request) {
+	resp := s.sender.SendReq(util.Request{Type: util.GetQueues})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+func (s *Server) addQueue(w http.ResponseWriter, r *http.Request) {
+	var body util.QueueBody
+	if !readBody(w, r, &body) {
+		return
+	}
+	body.ID = -1
+	resp := s.sender.SendReq(util.Request{Type: util.AddQueue, Body: body})
+	writeResponse(w, resp, http.StatusCreated)
+}
+
+func (s *Server) editQueue(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var body util.QueueBody
+	if !readBody(w, r, &body) {
+		return
+	}
+	body.ID = id // the one in the path wins
+	resp := s.sender.SendReq(util.Request{Type: util.EditQueue, Body: body})
+	writeResponse(w, resp, http.StatusOK)
+}
+
+func (s *Server) deleteQueue(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	resp := s.sender.SendReq(util.Request{Type: util.DeleteQueue, Body: util.QueueBody{ID: id}})
+	writeResponse(w, resp, http.StatusOK)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// errors always look like a util.FailureMessage
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, util.FailureMessage{Message: msg, Kind: kindForStatus(status)})
+}
+
+func statusForKind(kind util.FailureKind) int {
+	switch kind {
+	case util.NotFound:
+		return http.StatusNotFound
+	case util.Conflict:
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func kindForStatus(status int) util.FailureKind {
+	switch status {
+	case http.StatusNotFound:
+		return util.NotFound
+	case http.StatusConflict:
+		return util.Conflict
+	default:
+		return util.BadRequest
+	}
+}
+
+// turns the managers answer into an http response. okStatus is used when
+// it worked. OK answers without a body become 204 No Content
+func writeResponse(w http.ResponseWriter, resp util.Response, okStatus int) {
+	if resp.Type == util.FAIL {
+		body, ok := resp.Body.(util.FailureMessage)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, "manager failed without saying why")
+			return
+		}
+		writeJSON(w, statusForKind(body.Kind), body)
+		return
+	}
+	if resp.Body == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, okStatus, resp.Body)
+}
+
+// decodes the json body into v. writes the error response itself and
+// returns false if that didn't work
+func readBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bad json body: %v", err))
+		return false
+	}
+	return true
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bad id: %q", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
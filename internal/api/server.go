@@ -0,0 +1,71 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/placeholder14032/download-manager/internal/controller"
+)
+
+// Server exposes every request type of the manager over http/json.
+// it doesn't know anything about the manager itself, it just turns http
+// requests into util.Requests and hands them to the sender
+type Server struct {
+	sender controller.Sender
+	events EventSource // nil means no event stream
+	token  string      // empty means no authentication
+	mux    *http.ServeMux
+}
+
+// events and token are optional. when the token is set every request
+// needs an `Authorization: Bearer <token>` header
+func NewServer(sender controller.Sender, events EventSource, token string) *Server {
+	s := &Server{
+		sender: sender,
+		events: events,
+		token:  token,
+		mux:    http.NewServeMux(),
+	}
+	s.routes()
+	return s
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /api/downloads", s.listDownloads)
+	s.mux.HandleFunc("POST /api/downloads", s.addDownload)
+	s.mux.HandleFunc("POST /api/downloads/{id}/{action}", s.modDownload)
+	s.mux.HandleFunc("DELETE /api/downloads/{id}", s.deleteDownload)
+	s.mux.HandleFunc("POST /api/downloads/{id}/move", s.reorderDownload)
+	s.mux.HandleFunc("PUT /api/downloads/{id}/priority", s.setPriority)
+	s.mux.HandleFunc("POST /api/downloads/{id}/queue", s.moveDownload)
+	s.mux.HandleFunc("PUT /api/bandwidth", s.setBandwidth)
+	s.mux.HandleFunc("PUT /api/bandwidth/schedule", s.setBandwidthSchedule)
+
+	s.mux.HandleFunc("GET /api/queues", s.listQueues)
+	s.mux.HandleFunc("POST /api/queues", s.addQueue)
+	s.mux.HandleFunc("PUT /api/queues/{id}", s.editQueue)
+	s.mux.HandleFunc("DELETE /api/queues/{id}", s.deleteQueue)
+
+	s.mux.HandleFunc("GET /api/events", s.streamEvents)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="download-manager"`)
+		writeError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) == 1
+}
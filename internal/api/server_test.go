@@ -0,0 +1,297 @@
+package api_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/api"
+	"github.com/placeholder14032/download-manager/internal/controller"
+	"github.com/placeholder14032/download-manager/internal/manager"
+	"github.com/placeholder14032/download-manager/internal/testorigin"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+const TOKEN = "s3cret"
+
+// a real manager behind the api, and a queue in a temp dir
+type apiEnv struct {
+	t      *testing.T
+	server *httptest.Server
+	dir    string
+	qid    int64
+}
+
+func newAPIEnv(t *testing.T) *apiEnv {
+	t.Helper()
+	reqs := make(chan util.Request)
+	resps := make(chan util.Response)
+	m := &manager.Manager{Ephemeral: true}
+	go m.Start(reqs, resps)
+	t.Cleanup(m.Shutdown)
+	server := httptest.NewServer(api.NewServer(controller.NewChannelSender(reqs, resps), m, TOKEN))
+	t.Cleanup(server.Close)
+
+	e := &apiEnv{t: t, server: server, dir: t.TempDir()}
+	var q util.QueueBody
+	e.expect("POST", "/api/queues", util.QueueBody{Directory: e.dir, MaxSimul: 1}, http.StatusCreated, &q)
+	e.qid = q.ID
+	return e
+}
+
+// sends body as json (a string is sent as it is) with the token
+func (e *apiEnv) do(method, path string, body any, token string) (*http.Response, []byte) {
+	e.t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		bts, err := json.Marshal(b)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		reader = strings.NewReader(string(bts))
+	}
+	req, err := http.NewRequest(method, e.server.URL+path, reader)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	bts, _ := io.ReadAll(resp.Body)
+	return resp, bts
+}
+
+// checks the status and decodes the answer into out if it isn't nil
+func (e *apiEnv) expect(method, path string, body any, status int, out any) {
+	e.t.Helper()
+	resp, bts := e.do(method, path, body, TOKEN)
+	if resp.StatusCode != status {
+		e.t.Fatalf("%s %s: got %d (%s), want %d", method, path, resp.StatusCode, bts, status)
+	}
+	if out != nil {
+		if err := json.Unmarshal(bts, out); err != nil {
+			e.t.Fatalf("%s %s: %v in %s", method, path, err, bts)
+		}
+	}
+}
+
+// adds a download that doesn't start by itself
+func (e *apiEnv) addDownload(url string) int64 {
+	e.t.Helper()
+	var dl util.DownloadBody
+	e.expect("POST", "/api/downloads", util.BodyAddDownload{URL: url, QueueID: e.qid}, http.StatusCreated, &dl)
+	return dl.ID
+}
+
+func TestAPIAuth(t *testing.T) {
+	e := newAPIEnv(t)
+	for _, token := range []string{"", "wrong", TOKEN + "x"} {
+		resp, bts := e.do("GET", "/api/downloads", nil, token)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("token %q: got %d", token, resp.StatusCode)
+		}
+		if !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("token %q: WWW-Authenticate is %q", token, resp.Header.Get("WWW-Authenticate"))
+		}
+		var failure util.FailureMessage
+		if err := json.Unmarshal(bts, &failure); err != nil || failure.Message == "" {
+			t.Errorf("token %q: the body isn't a failure message: %s", token, bts)
+		}
+	}
+	// the token has to come as a bearer token, not any other way
+	req, _ := http.NewRequest("GET", e.server.URL+"/api/downloads", nil)
+	req.Header.Set("Authorization", "Basic "+TOKEN)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("basic auth: got %d", resp.StatusCode)
+	}
+}
+
+func TestAPIDownloads(t *testing.T) {
+	e := newAPIEnv(t)
+	id := e.addDownload("http://127.0.0.1:1/first.bin")
+	other := e.addDownload("http://127.0.0.1:1/second.bin")
+
+	var list util.StaticDownloadList
+	e.expect("GET", "/api/downloads", nil, http.StatusOK, &list)
+	dls := list.Downloads
+	if len(dls) != 2 || dls[0].ID != id || dls[1].ID != other {
+		t.Fatalf("got %+v", dls)
+	}
+
+	e.expect("PUT", fmt.Sprintf("/api/downloads/%d/priority", other), util.BodySetPriority{Priority: 5}, http.StatusNoContent, nil)
+	e.expect("POST", fmt.Sprintf("/api/downloads/%d/move", id), `{"Move": "bottom"}`, http.StatusNoContent, nil)
+	e.expect("PUT", "/api/bandwidth", `{"Scope": "download", "ID": `+fmt.Sprint(id)+`, "Limit": 1024}`, http.StatusNoContent, nil)
+	e.expect("PUT", "/api/bandwidth/schedule", `{"Scope": "global", "Rules": [{"Window": "mon-fri 09:00-17:00", "Limit": 524288}]}`, http.StatusNoContent, nil)
+
+	var q util.QueueBody
+	e.expect("POST", "/api/queues", util.QueueBody{Directory: t.TempDir(), MaxSimul: 1}, http.StatusCreated, &q)
+	e.expect("POST", fmt.Sprintf("/api/downloads/%d/queue", id), util.BodyMoveDownload{QueueID: q.ID}, http.StatusNoContent, nil)
+	e.expect("GET", "/api/downloads", nil, http.StatusOK, &list)
+	for _, dl := range list.Downloads {
+		if dl.ID == id && dl.QueueID != q.ID {
+			t.Errorf("download %d is still in queue %d", id, dl.QueueID)
+		}
+	}
+
+	e.expect("DELETE", fmt.Sprintf("/api/downloads/%d", other), nil, http.StatusNoContent, nil)
+	e.expect("GET", "/api/downloads", nil, http.StatusOK, &list)
+	if len(list.Downloads) != 1 {
+		t.Errorf("%d downloads after the delete", len(list.Downloads))
+	}
+}
+
+func TestAPIQueues(t *testing.T) {
+	e := newAPIEnv(t)
+	var list util.StaticQueueList
+	e.expect("GET", "/api/queues", nil, http.StatusOK, &list)
+	qs := list.Queues
+	if len(qs) != 1 || qs[0].ID != e.qid {
+		t.Fatalf("got %+v", qs)
+	}
+
+	edited := qs[0]
+	edited.Name = "renamed"
+	edited.ID = 12345 // the one in the path wins
+	e.expect("PUT", fmt.Sprintf("/api/queues/%d", e.qid), edited, http.StatusNoContent, nil)
+	e.expect("GET", "/api/queues", nil, http.StatusOK, &list)
+	if list.Queues[0].Name != "renamed" {
+		t.Errorf("the name is %q", list.Queues[0].Name)
+	}
+
+	e.expect("DELETE", fmt.Sprintf("/api/queues/%d", e.qid), nil, http.StatusNoContent, nil)
+	list = util.StaticQueueList{}
+	e.expect("GET", "/api/queues", nil, http.StatusOK, &list)
+	if len(list.Queues) != 0 {
+		t.Errorf("%d queues after the delete", len(list.Queues))
+	}
+}
+
+// what the manager says goes wrong becomes the status, with the failure in the body
+func TestAPIErrors(t *testing.T) {
+	e := newAPIEnv(t)
+	id := e.addDownload("http://127.0.0.1:1/file.bin")
+	tests := []struct {
+		method, path string
+		body         any
+		status       int
+	}{
+		{"POST", "/api/downloads", `{"URL": `, http.StatusBadRequest},
+		{"POST", "/api/downloads", util.BodyAddDownload{URL: "http://127.0.0.1:1/x", QueueID: 999}, http.StatusNotFound},
+		{"POST", "/api/downloads/abc/start", nil, http.StatusBadRequest},
+		{"POST", fmt.Sprintf("/api/downloads/%d/explode", id), nil, http.StatusNotFound},
+		{"POST", "/api/downloads/999/start", nil, http.StatusNotFound},
+		{"POST", fmt.Sprintf("/api/downloads/%d/pause", id), nil, http.StatusConflict},
+		{"POST", fmt.Sprintf("/api/downloads/%d/resume", id), nil, http.StatusConflict},
+		{"POST", fmt.Sprintf("/api/downloads/%d/cancel", id), nil, http.StatusConflict},
+		{"POST", fmt.Sprintf("/api/downloads/%d/retry", id), nil, http.StatusConflict},
+		{"POST", fmt.Sprintf("/api/downloads/%d/move", id), `{"Move": "sideways"}`, http.StatusBadRequest},
+		{"DELETE", "/api/downloads/999", nil, http.StatusNotFound},
+		{"PUT", "/api/bandwidth", `{"Scope": "everything"}`, http.StatusBadRequest},
+		{"POST", "/api/queues", util.QueueBody{Directory: "/does/not/exist"}, http.StatusBadRequest},
+		{"PUT", "/api/queues/999", util.QueueBody{Directory: e.dir}, http.StatusNotFound},
+		{"DELETE", "/api/queues/999", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, bts := e.do(tt.method, tt.path, tt.body, TOKEN)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: got %d (%s), want %d", tt.method, tt.path, resp.StatusCode, bts, tt.status)
+			continue
+		}
+		var failure util.FailureMessage
+		if err := json.Unmarshal(bts, &failure); err != nil || failure.Message == "" {
+			t.Errorf("%s %s: the body isn't a failure message: %s", tt.method, tt.path, bts)
+		}
+	}
+}
+
+// the events of a download come over the stream while it runs
+func TestAPIEvents(t *testing.T) {
+	e := newAPIEnv(t)
+	origin := testorigin.New()
+	defer origin.Close()
+	url := origin.Add("/streamed.bin", &testorigin.File{Data: make([]byte, 64<<10)})
+	id := e.addDownload(url)
+	e.addDownload(url + "?other") // its events mustn't show up
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/events?id=%d", e.server.URL, id), nil)
+	req.Header.Set("Authorization", "Bearer "+TOKEN)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %d, %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	e.expect("POST", fmt.Sprintf("/api/downloads/%d/start", id), nil, http.StatusNoContent, nil)
+
+	type line struct {
+		text string
+		err  error
+	}
+	lines := make(chan line)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- line{text: scanner.Text()}
+		}
+		lines <- line{err: fmt.Errorf("the stream ended: %v", scanner.Err())}
+	}()
+	timeout := time.After(30 * time.Second)
+	name := ""
+	for {
+		select {
+		case l := <-lines:
+			if l.err != nil {
+				t.Fatal(l.err)
+			}
+			if n, ok := strings.CutPrefix(l.text, "event: "); ok {
+				name = n
+				continue
+			}
+			data, ok := strings.CutPrefix(l.text, "data: ")
+			if !ok {
+				continue
+			}
+			var ev util.Event
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatalf("bad event %q: %v", data, err)
+			}
+			if ev.DownloadID != id {
+				t.Fatalf("got an event of download %d", ev.DownloadID)
+			}
+			if name != ev.Type.String() {
+				t.Errorf("sent as %q but it's a %v", name, ev.Type)
+			}
+			if ev.Type == util.Failed {
+				t.Fatalf("the download failed: %s", ev.Reason)
+			}
+			if ev.Type == util.Finished {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no finished event")
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/placeholder14032/download-manager/internal/controller"
+	"github.com/placeholder14032/download-manager/internal/queue"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// limit [-queue ID | -download ID] RATE
+// without either flag it is the global limit
+func runLimit(c *session, args []string) error {
+	flags := newFlags("limit", c)
+	qid := flags.Int64("queue", 0, "limit this queue")
+	dlid := flags.Int64("download", 0, "limit this download")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		c.errorf("limit: expected a rate like 500k or 2m (per second), 0 for none\n")
+		return errUsage
+	}
+	rate, ok := parseRate(flags.Arg(0))
+	if !ok {
+		c.errorf("limit: bad rate %q\n", flags.Arg(0))
+		return errUsage
+	}
+	switch {
+	case *qid != 0 && *dlid != 0:
+		c.errorf("limit: -queue or -download, not both\n")
+		return errUsage
+	case *qid != 0:
+		return controller.SetBandwidth(util.ScopeQueue, *qid, rate)
+	case *dlid != 0:
+		return controller.SetBandwidth(util.ScopeDownload, *dlid, rate)
+	}
+	return controller.SetBandwidth(util.ScopeGlobal, 0, rate)
+}
+
+// schedule [-queue ID] ["[DAYS] HH:MM-HH:MM RATE"...]
+// replaces the whole schedule. without rules there is none anymore
+func runSchedule(c *session, args []string) error {
+	flags := newFlags("schedule", c)
+	qid := flags.Int64("queue", 0, "the schedule of this queue instead of the global one")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	rules := make([]queue.BandwidthRule, 0, flags.NArg())
+	for _, arg := range flags.Args() {
+		rule, err := parseRule(arg)
+		if err != nil {
+			c.errorf("schedule: %v\n", err)
+			return errUsage
+		}
+		rules = append(rules, rule)
+	}
+	if *qid != 0 {
+		return controller.SetBandwidthSchedule(util.ScopeQueue, *qid, rules)
+	}
+	return controller.SetBandwidthSchedule(util.ScopeGlobal, 0, rules)
+}
+
+// "mon-fri 09:00-17:00 500k": a window and the rate at the end
+func parseRule(s string) (queue.BandwidthRule, error) {
+	var rule queue.BandwidthRule
+	s = strings.TrimSpace(s)
+	cut := strings.LastIndexByte(s, ' ')
+	if cut == -1 {
+		return rule, fmt.Errorf("bad rule %q, expected \"[DAYS] HH:MM-HH:MM RATE\"", s)
+	}
+	window, err := queue.ParseWindow(s[:cut])
+	if err != nil {
+		return rule, err
+	}
+	rate, ok := parseRate(s[cut+1:])
+	if !ok {
+		return rule, fmt.Errorf("bad rate %q", s[cut+1:])
+	}
+	rule.Window = window
+	rule.Limit = rate
+	return rule, nil
+}
+
+// bytes, or with a k, m or g after it (powers of 1024)
+func parseRate(s string) (int64, bool) {
+	s = strings.ToLower(s)
+	mult := int64(1)
+	for i, unit := range []string{"k", "m", "g"} {
+		if strings.HasSuffix(s, unit) {
+			mult = 1 << (10 * (i + 1))
+			s = strings.TrimSuffix(s, unit)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n * mult, true
+}
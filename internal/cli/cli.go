@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/placeholder14032/download-manager/internal/api"
+	"github.com/placeholder14032/download-manager/internal/controller"
+	"github.com/placeholder14032/download-manager/internal/daemon"
+	"github.com/placeholder14032/download-manager/internal/manager"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// exit codes
+const (
+	EXIT_OK     = 0
+	EXIT_FAILED = 1 // the manager said no or a download failed
+	EXIT_USAGE  = 2
+)
+
+const USAGE = `usage: dm [-server URL] [-token TOKEN] [-socket PATH] <command> [arguments]
+
+dm talks to the manager given with -server (or $DM_SERVER), otherwise to
+the daemon on the socket if there is one. without either the manager runs
+inside dm itself and "add" waits until its downloads are finished.
+
+commands:
+  daemon [-listen ADDR] [-detach]
+  stop
+  add [-queue ID] [-name NAME] [-checksum ALGO:HEX] [-priority N] [-dir DIR] [-no-start] [-wait]
+      [-header "NAME: VALUE"]... [-cookies FILE] [-user-agent UA]
+      [-user USER[:PASSWORD] | -bearer] URL...
+  ls [-status STATUS] [-json]
+  start|pause|resume|cancel|retry ID...
+  rm ID...
+  move ID up|down|top|bottom
+  priority ID N
+  mv [-files] ID QUEUE
+  limit [-queue ID | -download ID] RATE
+  schedule [-queue ID] ["[DAYS] HH:MM-HH:MM RATE"...]
+  queue ls [-json]
+  queue add -dir DIR [-name NAME] [-max-simul N] [-max-bandwidth N] [-max-retries N]
+            [-chunk-retries N] [-window "[DAYS] HH:MM-HH:MM"]... [-tz ZONE]
+            [-start-at "YYYY-MM-DD HH:MM"] [-stop-at "YYYY-MM-DD HH:MM"]
+            [-storage parts|prealloc] [-on-change restart|fail]
+            [-on-collision rename|overwrite|skip|fail]
+            [-header "NAME: VALUE"]... [-cookies FILE] [-user-agent UA]
+            [-proxy system|none|URL] [-no-proxy HOST,...]
+            [-tls-host PATTERN] [-ca FILE]... [-client-cert FILE] [-client-key FILE]
+            [-pin sha256//BASE64]... [-insecure-skip-verify]
+  queue edit ID [the same flags as queue add]
+  queue rm ID
+`
+
+// used by commands for mistakes in the command line itself
+var errUsage = errors.New("bad usage")
+
+type command func(c *session, args []string) error
+
+var commands = map[string]command{
+	"add":      runAdd,
+	"ls":       runList,
+	"start":    modCommand(util.StartDownload),
+	"pause":    modCommand(util.PauseDownload),
+	"resume":   modCommand(util.ResumeDownload),
+	"cancel":   modCommand(util.CancelDownload),
+	"retry":    modCommand(util.RetryDownload),
+	"rm":       modCommand(util.DeleteDownload),
+	"move":     runMove,
+	"priority": runPriority,
+	"mv":       runMv,
+	"limit":    runLimit,
+	"schedule": runSchedule,
+	"queue":    runQueue,
+}
+
+// these don't talk to a manager at all
+var standaloneCommands = map[string]command{
+	"daemon": runDaemon,
+	"stop":   runStop,
+}
+
+// everything a command needs to know about how it was started
+type session struct {
+	out       io.Writer
+	errOut    io.Writer
+	inProcess bool // the manager lives as long as this command does
+	socket    string
+	token     string
+}
+
+func (c *session) printf(format string, a ...any) {
+	fmt.Fprintf(c.out, format, a...)
+}
+
+func (c *session) errorf(format string, a ...any) {
+	fmt.Fprintf(c.errOut, format, a...)
+}
+
+// runs the command line (without the program name) and returns the exit code
+func Run(args []string, out, errOut io.Writer) int {
+	flags := flag.NewFlagSet("dm", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	flags.Usage = func() { fmt.Fprint(errOut, USAGE) }
+	server := flags.String("server", os.Getenv("DM_SERVER"), "address of a running manager, e.g. http://127.0.0.1:7878")
+	token := flags.String("token", os.Getenv("DM_API_TOKEN"), "bearer token of the running manager")
+	socket := flags.String("socket", daemon.DefaultSocketPath(), "socket of the daemon")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+	name := flags.Arg(0)
+	c := &session{out: out, errOut: errOut, socket: *socket, token: *token}
+	cmd, standalone := standaloneCommands[name]
+	if !standalone {
+		var ok bool
+		cmd, ok = commands[name]
+		if !ok {
+			fmt.Fprintf(errOut, "unknown command %q\n", name)
+			flags.Usage()
+			return EXIT_USAGE
+		}
+		if *server != "" {
+			controller.SetSender(api.NewClient(*server, *token))
+		} else if client, err := daemon.Dial(*socket); err == nil {
+			defer client.Close()
+			controller.SetSender(client)
+		} else {
+			c.inProcess = true
+			m := startManager()
+			defer m.Shutdown()
+		}
+	}
+
+	err := cmd(c, flags.Args()[1:])
+	switch {
+	case err == nil:
+		return EXIT_OK
+	case errors.Is(err, errUsage):
+		return EXIT_USAGE
+	default:
+		fmt.Fprintln(errOut, "dm:", err)
+		return EXIT_FAILED
+	}
+}
+
+// runs a manager in this process and points the controller at it
+func startManager() *manager.Manager {
+	// the manager and the downloads log to stdout. that goes to stderr
+	// so what we print ourselves stays usable in scripts
+	os.Stdout = os.Stderr
+	reqs := make(chan util.Request)
+	resps := make(chan util.Response)
+	// it only lives for this command. the state belongs to the daemon or the ui
+	m := &manager.Manager{Ephemeral: true}
+	go m.Start(reqs, resps)
+	controller.SetSender(controller.NewChannelSender(reqs, resps))
+	return m
+}
+
+// a flag set for a subcommand that reports its mistakes as errUsage
+func newFlags(name string, c *session) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(c.errOut)
+	return flags
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+// parses every argument as an id
+func parseIDs(c *session, args []string) ([]int64, error) {
+	if len(args) == 0 {
+		c.errorf("expected at least one id\n")
+		return nil, errUsage
+	}
+	ids := make([]int64, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			c.errorf("bad id %q\n", arg)
+			return nil, errUsage
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// keeps going over every id and fails at the end if any of them did
+func forEachID(c *session, ids []int64, f func(id int64) error) error {
+	failed := 0
+	for _, id := range ids {
+		if err := f(id); err != nil {
+			c.errorf("%d: %v\n", id, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d failed", failed, len(ids))
+	}
+	return nil
+}
+
+// like controller.GetAllDownloads but a manager we can't reach is an
+// error instead of an empty list
+func listDownloads() ([]util.DownloadBody, error) {
+	resp := controller.SendReq(util.Request{Type: util.GetDownloads})
+	if err := failure(resp); err != nil {
+		return nil, err
+	}
+	body, _ := resp.Body.(util.StaticDownloadList)
+	sort.Slice(body.Downloads, func(i, j int) bool { return body.Downloads[i].ID < body.Downloads[j].ID })
+	return body.Downloads, nil
+}
+
+func listQueues() ([]util.QueueBody, error) {
+	resp := controller.SendReq(util.Request{Type: util.GetQueues})
+	if err := failure(resp); err != nil {
+		return nil, err
+	}
+	body, _ := resp.Body.(util.StaticQueueList)
+	return body.Queues, nil
+}
+
+func failure(resp util.Response) error {
+	if resp.Type == util.OK {
+		return nil
+	}
+	if msg, ok := resp.Body.(util.FailureMessage); ok {
+		return errors.New(msg.Message)
+	}
+	return errors.New("the manager failed without saying why")
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/placeholder14032/download-manager/internal/controller"
+	"github.com/placeholder14032/download-manager/internal/daemon"
+	"github.com/placeholder14032/download-manager/internal/download"
+	"github.com/placeholder14032/download-manager/internal/manager"
+	"github.com/placeholder14032/download-manager/internal/testorigin"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+const (
+	DATA     = "the file on the origin"
+	BAD_HASH = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+// runs dm with args against the daemon on socket, the way a script would
+func run(t *testing.T, socket string, args ...string) (int, string, string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code := Run(append([]string{"-socket", socket}, args...), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+// an ephemeral manager behind a daemon socket, so every dm run sees the
+// same downloads. returns the socket
+func serve(t *testing.T) string {
+	t.Helper()
+	t.Setenv("DM_SERVER", "")
+	reqs := make(chan util.Request)
+	resps := make(chan util.Response)
+	config := t.TempDir()
+	m := &manager.Manager{
+		Ephemeral:       true,
+		CredentialsFile: filepath.Join(config, manager.CREDENTIALS_FILE),
+		NetrcFile:       filepath.Join(config, ".netrc"),
+	}
+	go m.Start(reqs, resps)
+	t.Cleanup(m.Shutdown)
+
+	socket := filepath.Join(t.TempDir(), daemon.SOCKET_NAME)
+	server, err := daemon.Listen(socket, controller.NewChannelSender(reqs, resps), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+	return socket
+}
+
+func list(t *testing.T, socket string, args ...string) []util.DownloadBody {
+	t.Helper()
+	code, out, errOut := run(t, socket, append([]string{"ls", "-json"}, args...)...)
+	if code != EXIT_OK {
+		t.Fatalf("ls exited with %d: %s", code, errOut)
+	}
+	var dls []util.DownloadBody
+	if err := json.Unmarshal([]byte(out), &dls); err != nil {
+		t.Fatalf("ls -json printed %q: %v", out, err)
+	}
+	return dls
+}
+
+func TestExitCodes(t *testing.T) {
+	socket := serve(t)
+	t.Setenv("DM_BEARER_TOKEN", "")
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"nothing", nil, EXIT_USAGE},
+		{"unknown command", []string{"fetch"}, EXIT_USAGE},
+		{"unknown flag", []string{"ls", "-all"}, EXIT_USAGE},
+		{"unknown status", []string{"ls", "-status", "sleeping"}, EXIT_USAGE},
+		{"add without url", []string{"add"}, EXIT_USAGE},
+		{"-name with two urls", []string{"add", "-name", "x", "http://a/1", "http://a/2"}, EXIT_USAGE},
+		{"-bearer without a token", []string{"add", "-bearer", "http://a/1"}, EXIT_USAGE},
+		{"bad id", []string{"pause", "one"}, EXIT_USAGE},
+		{"unknown move", []string{"move", "1", "sideways"}, EXIT_USAGE},
+		{"queue add without -dir", []string{"queue", "add"}, EXIT_USAGE},
+		{"no such download", []string{"pause", "99"}, EXIT_FAILED},
+		{"no such queue", []string{"queue", "rm", "99"}, EXIT_FAILED},
+		{"empty list", []string{"ls"}, EXIT_OK},
+		{"queues", []string{"queue", "ls"}, EXIT_OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _, errOut := run(t, socket, tt.args...); code != tt.code {
+				t.Errorf("exited with %d, want %d: %s", code, tt.code, errOut)
+			}
+		})
+	}
+}
+
+func TestAddAndList(t *testing.T) {
+	origin := testorigin.New()
+	defer origin.Close()
+	good := origin.Add("/good.bin", &testorigin.File{Data: []byte(DATA)})
+	bad := origin.Add("/bad.bin", &testorigin.File{Data: []byte(DATA)})
+	socket := serve(t)
+	dir := t.TempDir()
+
+	code, out, errOut := run(t, socket, "queue", "add", "-dir", dir, "-name", "cli", "-max-retries", "0")
+	if code != EXIT_OK {
+		t.Fatalf("queue add exited with %d: %s", code, errOut)
+	}
+	qid := strings.TrimSpace(out)
+
+	code, out, errOut = run(t, socket, "add", "-queue", qid, "-wait", good)
+	if code != EXIT_OK {
+		t.Fatalf("add exited with %d: %s", code, errOut)
+	}
+	path := filepath.Join(dir, "good.bin")
+	if !strings.Contains(out, "done\t"+path) {
+		t.Errorf("add printed %q", out)
+	}
+	if got, _ := os.ReadFile(path); string(got) != DATA {
+		t.Errorf("the file has %q", got)
+	}
+
+	// a download that fails makes the whole add fail
+	code, _, errOut = run(t, socket, "add", "-queue", qid, "-wait", "-checksum", BAD_HASH, bad)
+	if code != EXIT_FAILED {
+		t.Fatalf("add of a bad file exited with %d, want %d", code, EXIT_FAILED)
+	}
+	if !strings.Contains(errOut, bad) {
+		t.Errorf("the failure doesn't name the url: %q", errOut)
+	}
+
+	dls := list(t, socket)
+	if len(dls) != 2 {
+		t.Fatalf("ls -json has %d downloads, want 2", len(dls))
+	}
+	if dl := dls[0]; dl.URL != good || dl.Status != download.Done || dl.FilePath != path || dl.QueueName != "cli" || dl.Downloaded != int64(len(DATA)) {
+		t.Errorf("the good download is %+v", dl)
+	}
+	if dl := dls[1]; dl.URL != bad || dl.Status != download.Failed || dl.FailReason == "" {
+		t.Errorf("the bad download is %+v", dl)
+	}
+	if failed := list(t, socket, "-status", "failed"); len(failed) != 1 || failed[0].ID != dls[1].ID {
+		t.Errorf("ls -status failed has %+v", failed)
+	}
+
+	// the ids it gets don't have to all work, the ones that do still count
+	code, _, _ = run(t, socket, "rm", "99", strconv.FormatInt(dls[1].ID, 10))
+	if code != EXIT_FAILED {
+		t.Errorf("rm with an unknown id exited with %d, want %d", code, EXIT_FAILED)
+	}
+	if dls := list(t, socket); len(dls) != 1 {
+		t.Errorf("%d downloads left after rm, want 1", len(dls))
+	}
+	if empty := list(t, socket, "-status", "paused"); empty == nil || len(empty) != 0 {
+		t.Errorf("ls -json of nothing printed %v, want []", empty)
+	}
+}
+
+// without a daemon the manager runs inside dm and add waits on its own
+func TestInProcess(t *testing.T) {
+	origin := testorigin.New()
+	defer origin.Close()
+	good := origin.Add("/good.bin", &testorigin.File{Data: []byte(DATA)})
+	bad := origin.Add("/bad.bin", &testorigin.File{Data: []byte(DATA)})
+	t.Setenv("DM_SERVER", "")
+	// the in-process manager moves stdout out of the way
+	stdout := os.Stdout
+	t.Cleanup(func() { os.Stdout = stdout })
+	socket := filepath.Join(t.TempDir(), "nobody-listens")
+	dir := t.TempDir()
+
+	if code, _, errOut := run(t, socket, "add", "-dir", dir, good); code != EXIT_OK {
+		t.Fatalf("add exited with %d: %s", code, errOut)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "good.bin")); string(got) != DATA {
+		t.Errorf("the file has %q", got)
+	}
+	if code, _, _ := run(t, socket, "add", "-dir", dir, "-checksum", BAD_HASH, bad); code != EXIT_FAILED {
+		t.Errorf("add of a bad file exited with %d, want %d", code, EXIT_FAILED)
+	}
+	if code, _, _ := run(t, socket, "add", "-dir", dir, "-no-start", good); code != EXIT_USAGE {
+		t.Errorf("add -no-start exited with %d, want %d", code, EXIT_USAGE)
+	}
+}
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/placeholder14032/download-manager/internal/daemon"
+)
+
+func runDaemon(c *session, args []string) error {
+	flags := newFlags("daemon", c)
+	listen := flags.String("listen", "", "also serve the http api on this address")
+	detach := flags.Bool("detach", false, "run in the background")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *detach {
+		// the same thing again minus -detach, in its own session
+		again := []string{"-socket", c.socket, "daemon"}
+		if *listen != "" {
+			again = append(again, "-listen", *listen)
+		}
+		// the token goes through the environment so it doesn't show up in ps
+		os.Setenv("DM_API_TOKEN", c.token)
+		pid, err := daemon.Detach(again)
+		if err != nil {
+			return err
+		}
+		c.printf("%d\n", pid)
+		return nil
+	}
+	return daemon.Run(daemon.Options{Socket: c.socket, Listen: *listen, Token: c.token})
+}
+
+// the daemon keeps its lock and pid file next to its socket
+func runStop(c *session, args []string) error {
+	return daemon.Stop(filepath.Dir(c.socket))
+}
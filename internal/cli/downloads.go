@@ -0,0 +1,278 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/controller"
+	"github.com/placeholder14032/download-manager/internal/download"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// how often we ask the manager how the downloads we wait for are doing
+const POLL_INTERVAL = 500 * time.Millisecond
+
+func runAdd(c *session, args []string) error {
+	flags := newFlags("add", c)
+	qid := flags.Int64("queue", 0, "queue to add to (default: 1, or a new queue in -dir when running in-process)")
+	checksum := flags.String("checksum", "", "expected checksum of the file, e.g. sha256:<hex>")
+	name := flags.String("name", "", "save it under this name instead of the one of the server (only with one url)")
+	priority := flags.Int64("priority", 0, "higher ones run first")
+	dir := flags.String("dir", ".", "where to save when running in-process without -queue")
+	noStart := flags.Bool("no-start", false, "only add them, don't start")
+	wait := flags.Bool("wait", false, "wait until the downloads finish (always on when running in-process)")
+	var request download.RequestOptions
+	requestFlags(flags, &request)
+	user := flags.String("user", "", "log in with basic auth as USER:PASSWORD. without the password it's taken from DM_PASSWORD")
+	// as a flag value the token would show up in ps. and -token of dm is
+	// the one of the manager, not of the download
+	bearer := flags.Bool("bearer", false, "log in with the bearer token in DM_BEARER_TOKEN")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	urls := flags.Args()
+	if len(urls) == 0 {
+		c.errorf("add: expected at least one url\n")
+		return errUsage
+	}
+	if *name != "" && len(urls) > 1 {
+		c.errorf("add: -name only works with one url\n")
+		return errUsage
+	}
+	var auth download.Credentials
+	if *bearer {
+		if auth.Token = strings.TrimSpace(os.Getenv("DM_BEARER_TOKEN")); auth.Token == "" {
+			c.errorf("add: -bearer needs the token in DM_BEARER_TOKEN\n")
+			return errUsage
+		}
+	}
+	if *user != "" {
+		if *bearer {
+			c.errorf("add: -user and -bearer don't go together\n")
+			return errUsage
+		}
+		var ok bool
+		if auth.Username, auth.Password, ok = strings.Cut(*user, ":"); !ok {
+			auth.Password = os.Getenv("DM_PASSWORD")
+		}
+	}
+	if c.inProcess {
+		if *noStart {
+			c.errorf("add: -no-start makes no sense without -server, nothing would be downloaded\n")
+			return errUsage
+		}
+		*wait = true
+	}
+
+	if *qid == 0 {
+		*qid = 1
+		if c.inProcess {
+			id, err := addRunQueue(*dir, len(urls))
+			if err != nil {
+				return err
+			}
+			*qid = id
+		}
+	}
+
+	ids := make([]int64, 0, len(urls))
+	for _, url := range urls {
+		id, err := controller.AddDownloadBody(util.BodyAddDownload{
+			URL:      url,
+			QueueID:  *qid,
+			FileName: *name,
+			Checksum: *checksum,
+			Priority: *priority,
+			Request:  request,
+			Auth:     auth,
+		})
+		if err != nil {
+			return fmt.Errorf("can't add %s: %v", url, err)
+		}
+		c.printf("%d\t%s\n", id, url)
+		ids = append(ids, id)
+	}
+	if *noStart {
+		return nil
+	}
+	for _, id := range ids {
+		if err := controller.ModDownload(util.StartDownload, id); err != nil {
+			// most likely the queue is full. it gets started when a spot frees up
+			c.errorf("%d: not started yet: %v\n", id, err)
+		}
+	}
+	if !*wait {
+		return nil
+	}
+	return waitFor(c, ids)
+}
+
+// a queue just for the downloads of this run. everything in it runs at once
+func addRunQueue(dir string, simul int) (int64, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, err
+	}
+	return controller.AddQueueBody(util.QueueBody{
+		Directory:  abs,
+		Name:       "dm",
+		MaxSimul:   int64(simul),
+		MaxRetries: 1,
+	})
+}
+
+func finished(s download.State) bool {
+	return s == download.Done || s == download.Failed || s == download.Cancelled
+}
+
+// polls until every download in ids is done, failed or cancelled.
+// fails if any of them didn't make it
+func waitFor(c *session, ids []int64) error {
+	left := make(map[int64]bool)
+	for _, id := range ids {
+		left[id] = true
+	}
+	failed := 0
+	for len(left) > 0 {
+		time.Sleep(POLL_INTERVAL)
+		dls, err := listDownloads()
+		if err != nil {
+			return fmt.Errorf("lost track of the downloads: %v", err)
+		}
+		for _, dl := range dls {
+			if !left[dl.ID] || !finished(dl.Status) {
+				continue
+			}
+			delete(left, dl.ID)
+			if dl.Status == download.Done {
+				c.printf("%d\tdone\t%s\n", dl.ID, dl.FilePath)
+				continue
+			}
+			failed++
+			reason := dl.FailReason
+			if reason == "" {
+				reason = strings.ToLower(dl.Status.String())
+			}
+			c.errorf("%d\t%s\t%s\n", dl.ID, dl.URL, reason)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d downloads failed", failed, len(ids))
+	}
+	return nil
+}
+
+func runList(c *session, args []string) error {
+	flags := newFlags("ls", c)
+	status := flags.String("status", "", "only show downloads in this state (pending, downloading, paused, ...)")
+	asJSON := flags.Bool("json", false, "print json instead of a table")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	var only download.State
+	if *status != "" {
+		s, ok := download.ParseState(*status)
+		if !ok {
+			c.errorf("ls: unknown status %q\n", *status)
+			return errUsage
+		}
+		only = s
+	}
+
+	all, err := listDownloads()
+	if err != nil {
+		return err
+	}
+	dls := make([]util.DownloadBody, 0)
+	for _, dl := range all {
+		if *status == "" || dl.Status == only {
+			dls = append(dls, dl)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "\t")
+		return enc.Encode(dls)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tPROGRESS\tSPEED\tQUEUE\tPRI\tFILE")
+	for _, dl := range dls {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%s\n", dl.ID, dl.Status, progressText(dl), dl.Speed, dl.QueueName, dl.Priority, dl.FilePath)
+	}
+	return w.Flush()
+}
+
+// a percentage, or just the bytes when the size of the file is unknown
+func progressText(dl util.DownloadBody) string {
+	if dl.Size < 0 {
+		return download.FormatBytes(dl.Downloaded)
+	}
+	return fmt.Sprintf("%.1f%%", dl.Progress)
+}
+
+// start, pause, resume, cancel, retry and rm all just send the id
+func modCommand(t util.RequestType) command {
+	return func(c *session, args []string) error {
+		ids, err := parseIDs(c, args)
+		if err != nil {
+			return err
+		}
+		return forEachID(c, ids, func(id int64) error {
+			return controller.ModDownload(t, id)
+		})
+	}
+}
+
+// move ID up|down|top|bottom
+func runMove(c *session, args []string) error {
+	if len(args) != 2 {
+		c.errorf("move: expected an id and one of up, down, top, bottom\n")
+		return errUsage
+	}
+	ids, err := parseIDs(c, args[:1])
+	if err != nil {
+		return err
+	}
+	move, ok := util.ParseMove(args[1])
+	if !ok {
+		c.errorf("move: unknown move %q\n", args[1])
+		return errUsage
+	}
+	return controller.ReorderDownload(ids[0], move)
+}
+
+// priority ID N
+func runPriority(c *session, args []string) error {
+	if len(args) != 2 {
+		c.errorf("priority: expected an id and a priority\n")
+		return errUsage
+	}
+	ids, err := parseIDs(c, args)
+	if err != nil {
+		return err
+	}
+	return controller.SetPriority(ids[0], ids[1])
+}
+
+// mv [-files] ID QUEUE puts the download in another queue
+func runMv(c *session, args []string) error {
+	flags := newFlags("mv", c)
+	files := flags.Bool("files", false, "also move what is already downloaded to the directory of the queue")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		c.errorf("mv: expected an id and a queue id\n")
+		return errUsage
+	}
+	ids, err := parseIDs(c, flags.Args())
+	if err != nil {
+		return err
+	}
+	return controller.MoveDownload(ids[0], ids[1], *files)
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/placeholder14032/download-manager/internal/download"
+)
+
+// -header, -cookies and -user-agent. they write into o, which can already
+// have settings in it (queue edit). the first -header replaces the headers
+// it had and "none" removes them, the same for -cookies
+func requestFlags(flags *flag.FlagSet, o *download.RequestOptions) {
+	replaced := false
+	flags.Func("header", "send this header with every request, e.g. \"Referer: https://example.com/\". can be repeated. \"none\" removes them", func(s string) error {
+		if !replaced {
+			replaced = true
+			o.Headers = nil
+		}
+		if s == "none" {
+			return nil
+		}
+		key, value, ok := strings.Cut(s, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("expected \"Name: value\"")
+		}
+		if o.Headers == nil {
+			o.Headers = make(http.Header)
+		}
+		o.Headers.Add(key, strings.TrimSpace(value))
+		return nil
+	})
+	flags.Func("cookies", "send the cookies of this cookies.txt (netscape format) that fit the url. \"none\" removes them", func(s string) error {
+		if s == "none" {
+			o.Cookies = nil
+			return nil
+		}
+		cookies, err := download.LoadCookieFile(s)
+		if err != nil {
+			return err
+		}
+		o.Cookies = cookies
+		return nil
+	})
+	flags.StringVar(&o.UserAgent, "user-agent", o.UserAgent, "User-Agent to send. empty is "+download.DEFAULT_USER_AGENT)
+}
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"flag"
+	"path/filepath"
+	"strconv"
+
+	"github.com/placeholder14032/download-manager/internal/download"
+)
+
+// -ca, -client-cert, -client-key, -pin and -insecure-skip-verify. they go
+// to the whole queue until a -tls-host, then to that host. the first -ca
+// and -pin of each replace what it had and "none" removes them
+func tlsFlags(flags *flag.FlagSet, c *session, s *download.TLSSettings) {
+	host := -1 // the index in s.Hosts the flags go to, -1 for the whole queue
+	target := func() *download.TLSOptions {
+		if host == -1 {
+			return &s.TLSOptions
+		}
+		return &s.Hosts[host].TLSOptions
+	}
+	replaced := make(map[string]bool) // "ca 2" if the first -ca for host 2 was seen
+	replace := func(what string) bool {
+		key := what + " " + strconv.Itoa(host)
+		first := !replaced[key]
+		replaced[key] = true
+		return first
+	}
+
+	flags.Func("tls-host", "the tls flags after it are only for hosts like this, e.g. *.corp.example.com. \"none\" removes every host", func(pattern string) error {
+		if pattern == "none" {
+			s.Hosts = nil
+			host = -1
+			return nil
+		}
+		for i := range s.Hosts {
+			if s.Hosts[i].Pattern == pattern {
+				host = i
+				return nil
+			}
+		}
+		s.Hosts = append(s.Hosts, download.HostTLS{Pattern: pattern})
+		host = len(s.Hosts) - 1
+		return nil
+	})
+	flags.Func("ca", "trust the certificates in this pem file on top of the system ones. can be repeated. \"none\" removes them", func(file string) error {
+		o := target()
+		if replace("ca") {
+			o.CAFiles = nil
+		}
+		if file != "none" {
+			o.CAFiles = append(o.CAFiles, file)
+		}
+		return nil
+	})
+	flags.Func("client-cert", "pem certificate to show servers that ask for one. \"none\" removes it", func(file string) error {
+		o := target()
+		if file == "none" {
+			o.ClientCert, o.ClientKey = "", ""
+			return nil
+		}
+		o.ClientCert = file
+		return nil
+	})
+	flags.Func("client-key", "the key of -client-cert if it isn't in the same file", func(file string) error {
+		target().ClientKey = file
+		return nil
+	})
+	flags.Func("pin", "only accept servers with this public key in their chain, as sha256//<base64>. can be repeated. \"none\" removes them", func(pin string) error {
+		o := target()
+		if replace("pin") {
+			o.Pins = nil
+		}
+		if pin != "none" {
+			o.Pins = append(o.Pins, pin)
+		}
+		return nil
+	})
+	flags.BoolFunc("insecure-skip-verify", "DANGEROUS: accept any certificate. anyone in between can read and change the downloads", func(v string) error {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		target().InsecureSkipVerify = insecure
+		if insecure {
+			c.errorf("WARNING: tls certificates won't be verified, anyone in between can read and change the downloads\n")
+		}
+		return nil
+	})
+}
+
+// the files of s relative to where dm was started
+func absTLSFiles(s *download.TLSSettings) error {
+	abs := func(o *download.TLSOptions) error {
+		var err error
+		for i := range o.CAFiles {
+			if o.CAFiles[i], err = filepath.Abs(o.CAFiles[i]); err != nil {
+				return err
+			}
+		}
+		for _, file := range []*string{&o.ClientCert, &o.ClientKey} {
+			if *file == "" {
+				continue
+			}
+			if *file, err = filepath.Abs(*file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := abs(&s.TLSOptions); err != nil {
+		return err
+	}
+	for i := range s.Hosts {
+		if err := abs(&s.Hosts[i].TLSOptions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// a clock that only moves when it's told to. timers and tickers fire
+// during Advance, in the order they are due
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	changed chan struct{} // closed and replaced whenever waiters change. see BlockUntil
+}
+
+type fakeWaiter struct {
+	clock  *Fake
+	at     time.Time
+	period time.Duration // 0 for timers
+	c      chan time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now, changed: make(chan struct{})}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d)}
+}
+
+func (f *Fake) add(d time.Duration, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// buffered like the real ones. a tick nobody takes is dropped
+	w := &fakeWaiter{clock: f, at: f.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 && period == 0 {
+		w.c <- f.now
+		return w
+	}
+	f.waiters = append(f.waiters, w)
+	f.notify()
+	return w
+}
+
+// has to be called with mu held
+func (f *Fake) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *Fake) remove(w *fakeWaiter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.notify()
+			return true
+		}
+	}
+	return false
+}
+
+// moves the time forward by d and fires everything that came due on the way
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for {
+		next := f.nextDue(end)
+		if next == nil {
+			break
+		}
+		f.now = next.at
+		select {
+		case next.c <- f.now:
+		default:
+		}
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			for i, w := range f.waiters {
+				if w == next {
+					f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+		f.notify()
+	}
+	f.now = end
+}
+
+// the earliest waiter that is due by end
+func (f *Fake) nextDue(end time.Time) *fakeWaiter {
+	var next *fakeWaiter
+	for _, w := range f.waiters {
+		if !w.at.After(end) && (next == nil || w.at.Before(next.at)) {
+			next = w
+		}
+	}
+	return next
+}
+
+// blocks until at least n timers or tickers are waiting. tests use it to
+// know that the code they drive went to sleep before they move the time
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mu.Lock()
+		count := len(f.waiters)
+		changed := f.changed
+		f.mu.Unlock()
+		if count >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *fakeWaiter) Stop() bool {
+	return w.clock.remove(w)
+}
+
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeWaiter.Stop()
+}
@@ -0,0 +1,105 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	f := NewFake(start)
+	timer := f.NewTimer(time.Second)
+	f.Advance(999 * time.Millisecond)
+	if fired(timer.C()) {
+		t.Fatal("timer fired early")
+	}
+	f.Advance(time.Millisecond)
+	if !fired(timer.C()) {
+		t.Fatal("timer didn't fire when it was due")
+	}
+	if timer.Stop() {
+		t.Error("Stop after firing should report false")
+	}
+	if got := f.Now(); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("now is %v, want %v", got, start.Add(time.Second))
+	}
+}
+
+func TestFakeTimerStop(t *testing.T) {
+	f := NewFake(start)
+	timer := f.NewTimer(time.Second)
+	if !timer.Stop() {
+		t.Fatal("Stop of a pending timer should report true")
+	}
+	f.Advance(time.Hour)
+	if fired(timer.C()) {
+		t.Fatal("a stopped timer fired")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for i := 0; i < 3; i++ {
+		f.Advance(time.Minute)
+		select {
+		case at := <-ticker.C():
+			if want := start.Add(time.Duration(i+1) * time.Minute); !at.Equal(want) {
+				t.Errorf("tick %d at %v, want %v", i, at, want)
+			}
+		default:
+			t.Fatalf("no tick %d", i)
+		}
+	}
+	// like the real one it drops what nobody takes
+	f.Advance(10 * time.Minute)
+	if !fired(ticker.C()) || fired(ticker.C()) {
+		t.Error("expected exactly one buffered tick")
+	}
+}
+
+func TestFakeFiresInOrder(t *testing.T) {
+	f := NewFake(start)
+	late := f.NewTimer(2 * time.Second)
+	early := f.NewTimer(time.Second)
+	f.Advance(3 * time.Second)
+	a, b := <-early.C(), <-late.C()
+	if !a.Before(b) {
+		t.Errorf("early fired at %v, late at %v", a, b)
+	}
+}
+
+func TestSleep(t *testing.T) {
+	f := NewFake(start)
+	done := make(chan error)
+	go func() {
+		done <- Sleep(context.Background(), f, time.Minute)
+	}()
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- Sleep(ctx, f, time.Minute)
+	}()
+	f.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
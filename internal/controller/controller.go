@@ -3,6 +3,7 @@ package controller
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/placeholder14032/download-manager/internal/util"
 )
@@ -10,14 +11,44 @@ import (
 var Req chan util.Request
 var Resp chan util.Response
 
+// anything that can get a request to the manager and bring back its answer.
+// the functions of this package all go through the one set with SetSender
+type Sender interface {
+	SendReq(r util.Request) util.Response
+}
+
+// talks to a manager running in the same process over its channels
+type ChannelSender struct {
+	mu   sync.Mutex // one request at a time so nobody reads someone elses response
+	req  chan util.Request
+	resp chan util.Response
+}
+
+func NewChannelSender(req chan util.Request, resp chan util.Response) *ChannelSender {
+	return &ChannelSender{req: req, resp: resp}
+}
+
+func (s *ChannelSender) SendReq(r util.Request) util.Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.req <- r
+	return <-s.resp
+}
+
+var sender Sender
+
 func SetChannels(req chan util.Request, resp chan util.Response) {
 	Req = req
 	Resp = resp
+	sender = NewChannelSender(req, resp)
+}
+
+func SetSender(s Sender) {
+	sender = s
 }
 
 func SendReq(r util.Request) util.Response {
-	Req <- r
-	return <- Resp
+	return sender.SendReq(r)
 }
 
 func SendAndPrint(r util.Request) {
@@ -38,4 +69,3 @@ func returnResp(resp util.Response) error {
 func Close(){
 
 }
-
@@ -1,20 +1,29 @@
 package controller
 
 import (
+	"github.com/placeholder14032/download-manager/internal/queue"
 	"github.com/placeholder14032/download-manager/internal/util"
 )
 
-func AddDownload(url string, qid int64, fileName string) error {
-	req := util.Request{
-		Type: util.AddDownload,
-		Body: util.BodyAddDownload{
-			URL: url,
-			QueueID: qid,
-			FileName: fileName,
-		},
+// checksum is optional and looks like sha256:<hex digest>.
+// returns the id of the new download
+func AddDownload(url string, qid int64, fileName string, checksum string) (int64, error) {
+	return AddDownloadBody(util.BodyAddDownload{
+		URL: url,
+		QueueID: qid,
+		FileName: fileName,
+		Checksum: checksum,
+	})
+}
+
+// like AddDownload but with everything a new download can have
+func AddDownloadBody(body util.BodyAddDownload) (int64, error) {
+	resp := SendReq(util.Request{Type: util.AddDownload, Body: body})
+	if err := returnResp(resp); err != nil {
+		return 0, err
 	}
-	resp := SendReq(req)
-	return returnResp(resp)
+	added, _ := resp.Body.(util.DownloadBody)
+	return added.ID, nil
 }
 
 func ModDownload(t util.RequestType, id int64) error {
@@ -40,3 +49,34 @@ func GetAllDownloads() []util.DownloadBody {
 	return body.Downloads
 }
 
+
+// moves a download up, down, to the top or to the bottom of its queue
+func ReorderDownload(id int64, move util.Move) error {
+	resp := SendReq(util.Request{Type: util.ReorderDownload, Body: util.BodyReorderDownload{ID: id, Move: move}})
+	return returnResp(resp)
+}
+
+func SetPriority(id int64, priority int64) error {
+	resp := SendReq(util.Request{Type: util.SetPriority, Body: util.BodySetPriority{ID: id, Priority: priority}})
+	return returnResp(resp)
+}
+
+// puts a download in another queue. with moveFiles whatever it already
+// downloaded goes along to the directory of that queue
+func MoveDownload(id int64, queueID int64, moveFiles bool) error {
+	resp := SendReq(util.Request{Type: util.MoveDownload, Body: util.BodyMoveDownload{ID: id, QueueID: queueID, MoveFiles: moveFiles}})
+	return returnResp(resp)
+}
+
+// changes the global limit, the one of a queue or of a single download
+// (id is ignored for the global one). limit is in bytes per second, 0 is none
+func SetBandwidth(scope util.Scope, id int64, limit int64) error {
+	resp := SendReq(util.Request{Type: util.SetBandwidth, Body: util.BodySetBandwidth{Scope: scope, ID: id, Limit: limit}})
+	return returnResp(resp)
+}
+
+// replaces the schedule of the global limit or of a queue. no rules removes it
+func SetBandwidthSchedule(scope util.Scope, id int64, rules []queue.BandwidthRule) error {
+	resp := SendReq(util.Request{Type: util.SetBandwidthSchedule, Body: util.BodySetBandwidthSchedule{Scope: scope, ID: id, Rules: rules}})
+	return returnResp(resp)
+}
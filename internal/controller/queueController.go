@@ -12,7 +12,9 @@ var(
 	DEFAULT_END_TIME = time.Date(0, 1, 1, 23, 59, 59, 0, time.UTC)
 )
 
-func createBody(id int64, dir, name string, maxSim, maxBandWidth, maxRetry int64,
+// fills the given fields on top of base. base is the current state of the queue
+// when editing so the settings these functions don't know about are kept as they are
+func createBody(base util.QueueBody, id int64, dir, name string, maxSim, maxBandWidth, maxRetry int64,
 	hasTimeConstraint bool, start, end string) util.QueueBody {
 	var sttime, edtime time.Time
 	if hasTimeConstraint {
@@ -23,28 +25,54 @@ func createBody(id int64, dir, name string, maxSim, maxBandWidth, maxRetry int64
 		edtime = DEFAULT_END_TIME
 	}
 	//
-	return 	util.QueueBody{
-			ID: id,
-			Directory: dir,
-			Name: name,
-			MaxSimul: maxSim,
-			MaxBandWidth: maxBandWidth,
-			MaxRetries: maxRetry,
-			HasTimeConstraint: hasTimeConstraint,
-			TimeRange: queue.TimeRange{Start: sttime, End: edtime},
+	base.ID = id
+	base.Directory = dir
+	base.Name = name
+	base.MaxSimul = maxSim
+	base.MaxBandWidth = maxBandWidth
+	base.MaxRetries = maxRetry
+	base.HasTimeConstraint = hasTimeConstraint
+	base.TimeRange = queue.TimeRange{Start: sttime, End: edtime}
+	return base
+}
+
+// returns the current settings of a queue. the bool is false if there is no such queue
+func findQueue(qid int64) (util.QueueBody, bool) {
+	for _, q := range GetQueues() {
+		if q.ID == qid {
+			return q, true
 		}
+	}
+	return util.QueueBody{}, false
 }
 
 func AddQueue(dir, name string, maxSim, maxBandWidth, maxRetry int64,
 	hasTimeConstraint bool, start, end string) error {
 	req := util.Request{
 		Type: util.AddQueue,
-		Body: createBody(-1, dir, name, maxSim, maxBandWidth, maxRetry, hasTimeConstraint, start, end),
+		Body: createBody(util.QueueBody{}, -1, dir, name, maxSim, maxBandWidth, maxRetry, hasTimeConstraint, start, end),
 	}
 	resp := SendReq(req)
 	return returnResp(resp)
 }
 
+// like AddQueue but with every setting a queue has. returns the id of the new queue
+func AddQueueBody(body util.QueueBody) (int64, error) {
+	body.ID = -1
+	resp := SendReq(util.Request{Type: util.AddQueue, Body: body})
+	if err := returnResp(resp); err != nil {
+		return 0, err
+	}
+	added, _ := resp.Body.(util.QueueBody)
+	return added.ID, nil
+}
+
+// replaces every setting of the queue with body.ID
+func EditQueueBody(body util.QueueBody) error {
+	resp := SendReq(util.Request{Type: util.EditQueue, Body: body})
+	return returnResp(resp)
+}
+
 func DeleteQueue(qid int64) error {
 	req := util.Request{
 		Type: util.DeleteQueue,
@@ -58,9 +86,10 @@ func DeleteQueue(qid int64) error {
 
 func EditQueue(id int64, dir, name string, maxSim, maxBandWidth, maxRetry int64,
 	hasTimeConstraint bool, start, end string) error {
+	base, _ := findQueue(id)
 	req := util.Request{
 		Type: util.EditQueue,
-		Body: createBody(id, dir, name, maxSim, maxBandWidth, maxRetry, hasTimeConstraint, start, end),
+		Body: createBody(base, id, dir, name, maxSim, maxBandWidth, maxRetry, hasTimeConstraint, start, end),
 	}
 	resp := SendReq(req)
 	return returnResp(resp)
@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+const DIAL_TIMEOUT = 2 * time.Second
+
+// Client talks to a daemon over its socket. like api.Client it implements
+// controller.Sender so the ui and the cli don't care where the manager is
+type Client struct {
+	mu   sync.Mutex // a request and its response have to stay together
+	path string     // of the socket, Subscribe needs a connection of its own
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func Dial(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{path: path, conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// the events of the daemon's manager, on a second connection so they don't
+// get mixed up with responses. the channel is closed when the daemon goes
+// away or after the returned function is called
+func (c *Client) Subscribe() (<-chan util.Event, func(), error) {
+	conn, err := net.DialTimeout("unix", c.path, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	var resp util.Response
+	if err := writeFrame(conn, util.Request{Type: SUBSCRIBE}); err == nil {
+		err = readFrame(r, &resp)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.Type != util.OK {
+		conn.Close()
+		if failure, ok := resp.Body.(util.FailureMessage); ok {
+			return nil, nil, errors.New(failure.Message)
+		}
+		return nil, nil, errors.New("the daemon doesn't send events")
+	}
+
+	events := make(chan util.Event)
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+		for {
+			var e util.Event
+			if err := readFrame(r, &e); err != nil {
+				return
+			}
+			select {
+			case events <- e:
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			close(done)
+			conn.Close()
+		})
+	}, nil
+}
+
+func (c *Client) SendReq(r util.Request) util.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := writeFrame(c.conn, r); err != nil {
+		return lostDaemon(err)
+	}
+	var resp util.Response
+	if err := readFrame(c.r, &resp); err != nil {
+		return lostDaemon(err)
+	}
+	return resp
+}
+
+func lostDaemon(err error) util.Response {
+	return util.Response{
+		Type: util.FAIL,
+		Body: util.FailureMessage{Message: "lost the connection to the daemon: " + err.Error(), Kind: util.BadRequest},
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,129 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/placeholder14032/download-manager/internal/api"
+	"github.com/placeholder14032/download-manager/internal/controller"
+	"github.com/placeholder14032/download-manager/internal/manager"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+var (
+	ErrAlreadyRunning = errors.New("another daemon is already running")
+	ErrNotRunning     = errors.New("no daemon is running")
+)
+
+type Options struct {
+	Dir    string // dir for the lock and the pid file. the dir of Socket, or RuntimeDir() if both are empty
+	Socket string // DefaultSocketPath() if empty
+	Listen string // optional address for the http api
+	Token  string // bearer token of the http api
+}
+
+// makes sure there is only one daemon. the returned function gives
+// everything back and removes the pid file
+func acquire(dir string) (func(), error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	lockPath := filepath.Join(dir, LOCK_NAME)
+	pidPath := filepath.Join(dir, PID_NAME)
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		if errors.Is(err, ErrAlreadyRunning) {
+			if pid, ok := ReadPID(dir); ok {
+				return nil, fmt.Errorf("%w (pid %d)", err, pid)
+			}
+		}
+		return nil, err
+	}
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o600); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return func() {
+		os.Remove(pidPath)
+		lock.Close()
+	}, nil
+}
+
+// the pid of the running daemon according to the pid file
+func ReadPID(dir string) (int, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, PID_NAME))
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid, err == nil
+}
+
+// runs the manager until SIGTERM or SIGINT. then every running download
+// is stopped and the state is saved before returning. the next daemon
+// picks them up again
+func Run(opts Options) error {
+	if opts.Dir == "" && opts.Socket != "" {
+		opts.Dir = filepath.Dir(opts.Socket) // so Stop finds them from the socket alone
+	}
+	if opts.Dir == "" {
+		opts.Dir = RuntimeDir()
+	}
+	if opts.Socket == "" {
+		opts.Socket = filepath.Join(opts.Dir, SOCKET_NAME)
+	}
+	release, err := acquire(opts.Dir)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	reqs := make(chan util.Request)
+	resps := make(chan util.Response)
+	m := &manager.Manager{}
+	go m.Start(reqs, resps)
+	sender := controller.NewChannelSender(reqs, resps)
+
+	server, err := Listen(opts.Socket, sender, m)
+	if err != nil {
+		m.Shutdown()
+		return err
+	}
+	go server.Serve()
+	fmt.Fprintf(os.Stderr, "daemon %d listening on %s\n", os.Getpid(), opts.Socket)
+
+	var httpServer *http.Server
+	if opts.Listen != "" {
+		httpServer = &http.Server{Addr: opts.Listen, Handler: api.NewServer(sender, m, opts.Token)}
+		go func() {
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Fprintln(os.Stderr, "http api stopped:", err)
+			}
+		}()
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-sigs
+	signal.Stop(sigs)
+	fmt.Fprintf(os.Stderr, "got %v, stopping downloads and saving\n", sig)
+
+	// no new requests while we shut down
+	server.Close()
+	if httpServer != nil {
+		httpServer.Close()
+	}
+	m.Shutdown()
+	return nil
+}
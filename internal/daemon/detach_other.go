@@ -0,0 +1,13 @@
+//go:build !unix
+
+package daemon
+
+import "errors"
+
+func Detach(args []string) (int, error) {
+	return 0, errors.New("daemon mode is only supported on unix systems")
+}
+
+func Stop(dir string) error {
+	return errors.New("daemon mode is only supported on unix systems")
+}
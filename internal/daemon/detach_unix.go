@@ -0,0 +1,68 @@
+//go:build unix
+
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// starts the same executable again with args in its own session so it
+// outlives the terminal. its output goes to dm.log in the runtime dir
+func Detach(args []string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	dir := RuntimeDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return 0, err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, "dm.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer log.Close()
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// asks the running daemon to pause everything and exit. the pid file is only
+// believed while someone holds the lock, otherwise it's left over from a
+// daemon that crashed and its pid might belong to anything by now
+func Stop(dir string) error {
+	pidPath := filepath.Join(dir, PID_NAME)
+	lock, err := os.OpenFile(filepath.Join(dir, LOCK_NAME), os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		os.Remove(pidPath)
+		return ErrNotRunning
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	err = lockFile(lock)
+	if err == nil {
+		os.Remove(pidPath) // closing lock lets go of it again
+		return ErrNotRunning
+	}
+	if !errors.Is(err, ErrAlreadyRunning) {
+		return err
+	}
+	pid, ok := ReadPID(dir)
+	if !ok {
+		return fmt.Errorf("a daemon is running but %s has no pid in it", pidPath)
+	}
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
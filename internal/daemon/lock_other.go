@@ -0,0 +1,12 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"errors"
+	"os"
+)
+
+func lockFile(f *os.File) error {
+	return errors.New("daemon mode is only supported on unix systems")
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// takes an exclusive lock on the file without waiting. the os drops it
+// when the process dies so a crashed daemon never leaves a stale lock
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrAlreadyRunning
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	SOCKET_NAME = "dm.sock"
+	PID_NAME    = "dm.pid"
+	LOCK_NAME   = "dm.lock"
+)
+
+// where the socket, the pid file and the lock live. $XDG_RUNTIME_DIR is
+// private to the user already, otherwise we make our own dir in /tmp
+func RuntimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "download-manager")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("download-manager-%d", os.Getuid()))
+}
+
+func DefaultSocketPath() string {
+	return filepath.Join(RuntimeDir(), SOCKET_NAME)
+}
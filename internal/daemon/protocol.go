@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// every message on the socket is a frame:
+//
+//	[1 byte version][4 bytes big endian length][gob encoded payload]
+//
+// clients send util.Requests and get exactly one util.Response back for
+// each of them. a request of type SUBSCRIBE is answered with an OK and then
+// the connection only carries util.Event frames from the daemon, one for
+// every event of the manager, until either side hangs up. the version goes
+// up whenever the payloads change in a way an older peer can't read
+const (
+	PROTOCOL_VERSION = 1
+	MAX_FRAME_SIZE   = 16 << 20 // a list of every download is the biggest thing we send
+
+	SUBSCRIBE util.RequestType = -1 // never reaches the manager. an older daemon answers it with a FAIL
+)
+
+var ErrVersion = errors.New("unsupported protocol version")
+
+func init() {
+	// the bodies are interfaces so gob has to know every type that can be in them
+	gob.Register(util.BodyAddDownload{})
+	gob.Register(util.BodyModDownload{})
+	gob.Register(util.BodyReorderDownload{})
+	gob.Register(util.BodySetPriority{})
+	gob.Register(util.BodyMoveDownload{})
+	gob.Register(util.BodySetBandwidth{})
+	gob.Register(util.BodySetBandwidthSchedule{})
+	gob.Register(util.QueueBody{})
+	gob.Register(util.DownloadBody{})
+	gob.Register(util.StaticQueueList{})
+	gob.Register(util.StaticDownloadList{})
+	gob.Register(util.FailureMessage{})
+}
+
+func writeFrame(w io.Writer, v any) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(v); err != nil {
+		return fmt.Errorf("failed to encode frame: %w", err)
+	}
+	if payload.Len() > MAX_FRAME_SIZE {
+		return fmt.Errorf("frame too big: %d bytes", payload.Len())
+	}
+	header := make([]byte, 5)
+	header[0] = PROTOCOL_VERSION
+	binary.BigEndian.PutUint32(header[1:], uint32(payload.Len()))
+	// one write so frames of different goroutines never interleave
+	_, err := w.Write(append(header, payload.Bytes()...))
+	return err
+}
+
+// reads one frame into v. io.EOF means the other side closed cleanly
+func readFrame(r *bufio.Reader, v any) error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if header[0] != PROTOCOL_VERSION {
+		return fmt.Errorf("%w: %d (we speak %d)", ErrVersion, header[0], PROTOCOL_VERSION)
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MAX_FRAME_SIZE {
+		return fmt.Errorf("frame too big: %d bytes", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode frame: %w", err)
+	}
+	return nil
+}
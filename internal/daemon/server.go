@@ -0,0 +1,136 @@
+package daemon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/placeholder14032/download-manager/internal/api"
+	"github.com/placeholder14032/download-manager/internal/controller"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// Server hands whatever comes in on the socket to the sender, one request
+// at a time per connection. any number of clients can be connected
+type Server struct {
+	sender controller.Sender
+	events api.EventSource // for SUBSCRIBE. can be nil, then there are no events
+	ln     net.Listener
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// listens on a unix socket at path. a socket file left over from a daemon
+// that died is removed, so only call this while holding the instance lock
+func Listen(path string, sender controller.Sender, events api.EventSource) (*Server, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove old socket %s: %v", path, err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &Server{sender: sender, events: events, ln: ln, conns: make(map[net.Conn]bool)}, nil
+}
+
+// accepts clients until Close is called
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		var req util.Request
+		err := readFrame(r, &req)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			// tell them why before hanging up. can't go on after a broken frame
+			writeFrame(conn, util.Response{Type: util.FAIL, Body: util.FailureMessage{Message: err.Error(), Kind: util.BadRequest}})
+			return
+		}
+		if req.Type == SUBSCRIBE {
+			s.stream(conn, r)
+			return
+		}
+		if err := writeFrame(conn, s.sender.SendReq(req)); err != nil {
+			return
+		}
+	}
+}
+
+// sends every event until the client hangs up or the manager goes away
+func (s *Server) stream(conn net.Conn, r *bufio.Reader) {
+	if s.events == nil {
+		writeFrame(conn, util.Response{Type: util.FAIL, Body: util.FailureMessage{Message: "event streaming is not available", Kind: util.BadRequest}})
+		return
+	}
+	events, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+	if err := writeFrame(conn, util.Response{Type: util.OK}); err != nil {
+		return
+	}
+	// nothing else comes from them, reading only tells us when they are gone
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r)
+		close(gone)
+	}()
+	for {
+		select {
+		case <-gone:
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			e.Err = nil // gob can't send just any error. Reason has it
+			if err := writeFrame(conn, e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// stops accepting, hangs up on every client and removes the socket file
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	return s.ln.Close() // removes the file as well
+}
@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/api"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+type senderFunc func(util.Request) util.Response
+
+func (f senderFunc) SendReq(r util.Request) util.Response {
+	return f(r)
+}
+
+// hands out one channel and remembers when it's given back
+type fakeEvents struct {
+	ch   chan util.Event
+	gone chan struct{}
+}
+
+func (f *fakeEvents) Subscribe() (<-chan util.Event, func()) {
+	return f.ch, func() { close(f.gone) }
+}
+
+func listen(t *testing.T, events api.EventSource) *Client {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), SOCKET_NAME)
+	sender := senderFunc(func(r util.Request) util.Response {
+		return util.Response{Type: util.OK, Body: util.StaticQueueList{}}
+	})
+	server, err := Listen(path, sender, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+	client, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestSubscribe(t *testing.T) {
+	events := &fakeEvents{ch: make(chan util.Event), gone: make(chan struct{})}
+	client := listen(t, events)
+	got, unsubscribe, err := client.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := util.Event{Type: util.Progress, DownloadID: 7, Progress: 42, Size: 100}
+	events.ch <- sent
+	select {
+	case e := <-got:
+		if e != sent {
+			t.Errorf("got %+v, want %+v", e, sent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	// requests still work next to it
+	if resp := client.SendReq(util.Request{Type: util.GetQueues}); resp.Type != util.OK {
+		t.Errorf("request got %v", resp.Type)
+	}
+
+	unsubscribe()
+	select {
+	case <-events.gone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon still streams after the client hung up")
+	}
+	for range got {
+	}
+}
+
+func TestSubscribeWithoutEvents(t *testing.T) {
+	client := listen(t, nil)
+	if _, _, err := client.Subscribe(); err == nil {
+		t.Fatal("subscribed to a daemon without events")
+	}
+}
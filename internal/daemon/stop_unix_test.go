@@ -0,0 +1,71 @@
+//go:build unix
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestStopStalePID(t *testing.T) {
+	dir := t.TempDir()
+	// a daemon that crashed leaves the lock file and the pid file behind,
+	// the pid might be someone else's by now
+	sleeper := exec.Command("sleep", "10")
+	if err := sleeper.Start(); err != nil {
+		t.Skip("no sleep to start:", err)
+	}
+	defer sleeper.Process.Kill()
+	os.WriteFile(filepath.Join(dir, LOCK_NAME), nil, 0o600)
+	os.WriteFile(filepath.Join(dir, PID_NAME), []byte(strconv.Itoa(sleeper.Process.Pid)+"\n"), 0o600)
+
+	if err := Stop(dir); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("got %v, want %v", err, ErrNotRunning)
+	}
+	if _, err := os.Stat(filepath.Join(dir, PID_NAME)); !os.IsNotExist(err) {
+		t.Errorf("the stale pid file is still there: %v", err)
+	}
+	if err := sleeper.Process.Signal(syscall.Signal(0)); err != nil {
+		t.Errorf("the process of the stale pid got killed: %v", err)
+	}
+}
+
+func TestStopNothing(t *testing.T) {
+	if err := Stop(t.TempDir()); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("got %v, want %v", err, ErrNotRunning)
+	}
+}
+
+func TestStopRunning(t *testing.T) {
+	dir := t.TempDir()
+	release, err := acquire(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	// we hold the lock, but signalling ourselves would end the test
+	sleeper := exec.Command("sleep", "10")
+	if err := sleeper.Start(); err != nil {
+		t.Skip("no sleep to start:", err)
+	}
+	defer sleeper.Process.Kill()
+	os.WriteFile(filepath.Join(dir, PID_NAME), []byte(strconv.Itoa(sleeper.Process.Pid)+"\n"), 0o600)
+
+	if err := Stop(dir); err != nil {
+		t.Fatal(err)
+	}
+	err = sleeper.Wait()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.Sys().(syscall.WaitStatus).Signal() != syscall.SIGTERM {
+		t.Errorf("the daemon ended with %v, want SIGTERM", err)
+	}
+	// and we still have the lock
+	if _, err := acquire(dir); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("got %v, want %v", err, ErrAlreadyRunning)
+	}
+}
@@ -0,0 +1,300 @@
+package download
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// how a download logs in. a Token makes it Bearer, otherwise it's Basic
+// with Username and Password
+type Credentials struct {
+	Host     string // only sent to this host. empty is the host of the download
+	Username string
+	Password string
+	Token    string
+}
+
+// credentials for every host that matches Pattern, like *.example.com
+type HostCredentials struct {
+	Pattern string
+	Credentials
+}
+
+func (c Credentials) IsEmpty() bool {
+	return c.Username == "" && c.Password == "" && c.Token == ""
+}
+
+// what the ui and the logs may see: the kind and the user, never the secret
+func (c Credentials) String() string {
+	switch {
+	case c.Token != "":
+		return "bearer"
+	case !c.IsEmpty():
+		return "basic " + c.Username
+	}
+	return ""
+}
+
+func (c Credentials) header() string {
+	if c.Token != "" {
+		return "Bearer " + c.Token
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+}
+
+// whether pattern matches host. it goes label by label so a * never covers
+// a dot: *.example.com matches www.example.com and example.com itself, but
+// not a.b.example.com. a pattern of only * matches every host
+func hostMatches(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if pattern == "*" {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(pattern, "*."); ok && host == rest {
+		return true
+	}
+	patterns, labels := strings.Split(pattern, "."), strings.Split(host, ".")
+	if len(patterns) != len(labels) {
+		return false
+	}
+	for i := range patterns {
+		if ok, err := path.Match(patterns[i], labels[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// the credentials a handler uses. the manager fills it before every run,
+// the transport reads it for every request on its way out
+type authState struct {
+	mu    sync.Mutex
+	own   Credentials       // of the download itself. Host is always set
+	hosts []HostCredentials // by pattern, then from the netrc. the first match wins
+}
+
+// which credentials a request to host gets, if any
+func (a *authState) lookup(host string) (Credentials, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.own.IsEmpty() && strings.EqualFold(a.own.Host, host) {
+		return a.own, true
+	}
+	for _, hc := range a.hosts {
+		if hostMatches(hc.Pattern, host) {
+			return hc.Credentials, true
+		}
+	}
+	return Credentials{}, false
+}
+
+// puts the Authorization header on every request that goes to a host we
+// have credentials for. done here and not when the request is made so a
+// redirect to another host never gets them
+type authTransport struct {
+	base http.RoundTripper
+	auth *authState
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Header.Get("Authorization") != "" {
+		return base.RoundTrip(req) // set by hand with -header
+	}
+	creds, ok := t.auth.lookup(req.URL.Hostname())
+	if !ok {
+		return base.RoundTrip(req)
+	}
+	// a RoundTripper must not change the request it was given
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", creds.header())
+	return base.RoundTrip(req)
+}
+
+// a copy of client that logs in with whatever a has
+func withAuth(client *http.Client, a *authState) *http.Client {
+	c := *client
+	c.Transport = &authTransport{base: client.Transport, auth: a}
+	return &c
+}
+
+// what the next run logs in with. own is for the host of the download, hosts
+// are tried after it. set by the manager right before a run
+func (h *DownloadHandler) SetCredentials(own Credentials, hosts []HostCredentials) {
+	if h.auth == nil {
+		return // not made by NewDownloadHandler or Import, no transport to give them to
+	}
+	if own.Host == "" {
+		own.Host = hostOf(h.URL)
+	}
+	h.auth.mu.Lock()
+	h.auth.own = own
+	h.auth.hosts = hosts
+	h.auth.mu.Unlock()
+}
+
+func hostOf(rawURL string) string {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return ""
+	}
+	return req.URL.Hostname()
+}
+
+// reads credentials by host pattern. one per line:
+//
+//	*.corp.example.com basic alice hunter2
+//	api.example.com    bearer eyJhbGciOi...
+//
+// empty lines and lines starting with # are skipped
+func ParseCredentialsFile(r io.Reader) ([]HostCredentials, error) {
+	var creds []HostCredentials
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected a host pattern, basic or bearer and the credentials", n)
+		}
+		hc := HostCredentials{Pattern: fields[0]}
+		switch strings.ToLower(fields[1]) {
+		case "basic":
+			if len(fields) > 4 {
+				return nil, fmt.Errorf("line %d: expected a user and a password", n)
+			}
+			hc.Username = fields[2]
+			if len(fields) == 4 {
+				hc.Password = fields[3]
+			}
+		case "bearer":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: expected only a token", n)
+			}
+			hc.Token = fields[2]
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind %q, expected basic or bearer", n, fields[1])
+		}
+		if _, err := path.Match(hc.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("line %d: bad pattern %q", n, hc.Pattern)
+		}
+		creds = append(creds, hc)
+	}
+	return creds, scanner.Err()
+}
+
+// reads a .netrc. every machine matches that host only and default
+// matches every host, after all the machines
+func ParseNetrc(r io.Reader) ([]HostCredentials, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []string
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		for _, field := range strings.Fields(lines[i]) {
+			if strings.HasPrefix(field, "#") {
+				break
+			}
+			if field == "macdef" {
+				// a macro goes on until the next empty line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				break
+			}
+			tokens = append(tokens, field)
+		}
+	}
+
+	var machines []HostCredentials
+	var fallback *HostCredentials
+	var current *HostCredentials // the entry login and password go to. nil before the first one
+	for i := 0; i < len(tokens); i++ {
+		value := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return tokens[i]
+			}
+			return ""
+		}
+		switch tokens[i] {
+		case "machine":
+			// a machine name is a host, not a pattern
+			machines = append(machines, HostCredentials{Pattern: escapePattern(value())})
+			current = &machines[len(machines)-1] // only moves with the next append, which sets it again
+		case "default":
+			fallback = &HostCredentials{Pattern: "*"}
+			current = fallback
+		case "login", "password", "account":
+			key := tokens[i]
+			v := value() // moves i, so not on the same line as tokens[i]
+			if current == nil {
+				continue
+			}
+			if key == "login" {
+				current.Username = v
+			} else if key == "password" {
+				current.Password = v
+			}
+		}
+	}
+	if fallback != nil {
+		machines = append(machines, *fallback)
+	}
+	return machines, nil
+}
+
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// reads file with parse. a file that isn't there has nothing in it
+func loadCredentials(file string, parse func(io.Reader) ([]HostCredentials, error)) ([]HostCredentials, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	creds, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return creds, nil
+}
+
+// the credentials by host pattern from file and then the ones of the netrc
+// at netrc. either can be missing
+func LoadHostCredentials(file, netrc string) ([]HostCredentials, error) {
+	creds, err := loadCredentials(file, ParseCredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	fromNetrc, err := loadCredentials(netrc, ParseNetrc)
+	if err != nil {
+		return nil, err
+	}
+	return append(creds, fromNetrc...), nil
+}
@@ -0,0 +1,150 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	netrc := `# work
+machine files.example.com login alice password hunter2
+machine api.example.com
+	login bob
+	account whatever
+	password s3cret
+
+macdef init
+machine evil.example.com login mallory password x
+
+default login anonymous password guest@
+machine *.example.org login star password s
+`
+	creds, err := ParseNetrc(strings.NewReader(netrc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HostCredentials{
+		{Pattern: "files.example.com", Credentials: Credentials{Username: "alice", Password: "hunter2"}},
+		{Pattern: "api.example.com", Credentials: Credentials{Username: "bob", Password: "s3cret"}},
+		{Pattern: `\*.example.org`, Credentials: Credentials{Username: "star", Password: "s"}},
+		{Pattern: "*", Credentials: Credentials{Username: "anonymous", Password: "guest@"}},
+	}
+	if !reflect.DeepEqual(creds, want) {
+		t.Errorf("got %+v\nwant %+v", creds, want)
+	}
+	if hostMatches(creds[2].Pattern, "a.example.org") {
+		t.Errorf("a netrc machine matched like a pattern")
+	}
+}
+
+func TestParseCredentialsFile(t *testing.T) {
+	file := `
+# host pattern, kind, credentials
+*.corp.example.com  basic   alice hunter2
+api.example.com     Bearer  t0ken
+open.example.com    basic   guest
+`
+	creds, err := ParseCredentialsFile(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HostCredentials{
+		{Pattern: "*.corp.example.com", Credentials: Credentials{Username: "alice", Password: "hunter2"}},
+		{Pattern: "api.example.com", Credentials: Credentials{Token: "t0ken"}},
+		{Pattern: "open.example.com", Credentials: Credentials{Username: "guest"}},
+	}
+	if !reflect.DeepEqual(creds, want) {
+		t.Errorf("got %+v\nwant %+v", creds, want)
+	}
+	for _, bad := range []string{"host basic\n", "host digest a b\n", "host bearer a b\n", "[ basic a b\n"} {
+		if _, err := ParseCredentialsFile(strings.NewReader(bad)); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestHostMatches(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "example.com", true},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"*", "anything", true},
+	}
+	for _, tt := range tests {
+		if got := hostMatches(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("hostMatches(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestCredentialsString(t *testing.T) {
+	if s := (Credentials{Username: "alice", Password: "hunter2"}).String(); s != "basic alice" {
+		t.Errorf("got %q", s)
+	}
+	if s := (Credentials{Token: "t0ken"}).String(); s != "bearer" {
+		t.Errorf("got %q", s)
+	}
+}
+
+// the credentials only go to the host they are for, also after a redirect
+func TestAuthTransport(t *testing.T) {
+	got := make(map[string]string)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got["other"] = r.Header.Get("Authorization")
+	}))
+	defer other.Close()
+	// the same server by another name
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got[r.URL.Path] = r.Header.Get("Authorization")
+		if r.URL.Path == "/away" {
+			http.Redirect(w, r, otherURL+"/there", http.StatusFound)
+		}
+	}))
+	defer origin.Close()
+
+	d := Download{URL: origin.URL + "/file"}
+	CreateDefaultHandler(&d)
+	h := &d.Handler
+	h.SetCredentials(Credentials{Username: "alice", Password: "hunter2"}, nil)
+	for _, path := range []string{"/file", "/away"} {
+		resp, err := h.Client.Get(origin.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if want := "Basic YWxpY2U6aHVudGVyMg=="; got["/file"] != want || got["/away"] != want {
+		t.Errorf("the origin got %q", got)
+	}
+	if got["other"] != "" {
+		t.Errorf("the credentials went along to another host: %q", got["other"])
+	}
+
+	// by pattern for the other host, and a header set by hand wins
+	h.SetCredentials(Credentials{}, []HostCredentials{{Pattern: "localhost", Credentials: Credentials{Token: "t0ken"}}})
+	resp, _ := h.Client.Get(otherURL + "/there")
+	resp.Body.Close()
+	if got["other"] != "Bearer t0ken" {
+		t.Errorf("got %q by pattern", got["other"])
+	}
+	req, _ := h.newRequest(context.Background(), "GET")
+	req.URL, _ = req.URL.Parse(otherURL + "/there")
+	req.Header.Set("Authorization", "Custom x")
+	resp, _ = h.Client.Do(req)
+	resp.Body.Close()
+	if got["other"] != "Custom x" {
+		t.Errorf("got %q instead of the header set by hand", got["other"])
+	}
+}
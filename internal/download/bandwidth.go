@@ -0,0 +1,126 @@
+package download
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
+)
+
+// the smallest amount a bucket lets through at once, so slow limits still
+// read in sensible pieces
+const MIN_BURST = 16 * 1024
+
+// a token bucket shared by everything that reads under the same limit.
+// buckets hang below each other (download -> queue -> global) and a read
+// has to get through every one of them on the way up. a nil bucket or a
+// rate of 0 doesn't limit anything
+type Bucket struct {
+	mu     sync.Mutex
+	rate   int64 // bytes per second
+	tokens float64 // can go below 0 when readers reserved more than there was
+	last   time.Time
+	parent *Bucket
+	clock  clock.Clock // the one of the parent
+}
+
+func NewBucket(rate int64, parent *Bucket) *Bucket {
+	c := clock.Real
+	if parent != nil {
+		c = parent.clock
+	}
+	b := NewRootBucket(rate, c)
+	b.parent = parent
+	return b
+}
+
+// a bucket on top with its own clock. everything below it uses the same
+func NewRootBucket(rate int64, c clock.Clock) *Bucket {
+	b := &Bucket{clock: clock.Or(c)}
+	b.last = b.clock.Now()
+	b.SetRate(rate)
+	return b
+}
+
+// changes the limit for everyone reading through the bucket right away
+func (b *Bucket) SetRate(rate int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(b.clock.Now())
+	b.rate = max(rate, 0)
+	b.tokens = min(b.tokens, float64(b.burst()))
+}
+
+func (b *Bucket) Rate() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+// the bucket above this one. it never changes
+func (b *Bucket) Parent() *Bucket {
+	if b == nil {
+		return nil
+	}
+	return b.parent
+}
+
+// about a quarter of a second worth of bytes
+func (b *Bucket) burst() int64 {
+	return max(b.rate/4, MIN_BURST)
+}
+
+// has to be called with mu held
+func (b *Bucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(b.rate), float64(b.burst()))
+	}
+	b.last = now
+}
+
+// how much a reader should ask for at once: the smallest burst on the way up
+func (b *Bucket) chunk(want int) int {
+	for ; b != nil; b = b.parent {
+		b.mu.Lock()
+		if b.rate > 0 {
+			want = min(want, int(b.burst()))
+		}
+		b.mu.Unlock()
+	}
+	return want
+}
+
+// takes n bytes out of this bucket and every one above it. the readers
+// reserve them right away and then wait until the slowest bucket has them,
+// so the ones sharing a bucket take turns instead of all sleeping
+// and waking together
+func (b *Bucket) WaitN(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	c := b.clock
+	now := c.Now()
+	var wait time.Duration
+	for ; b != nil; b = b.parent {
+		b.mu.Lock()
+		b.refill(now)
+		if b.rate > 0 {
+			b.tokens -= float64(n)
+			if b.tokens < 0 {
+				wait = max(wait, time.Duration(-b.tokens/float64(b.rate)*float64(time.Second)))
+			}
+		}
+		b.mu.Unlock()
+	}
+	if wait <= 0 {
+		return nil
+	}
+	return clock.Sleep(ctx, c, wait)
+}
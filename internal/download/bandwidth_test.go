@@ -0,0 +1,101 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
+)
+
+var epoch = time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+
+// reads size bytes through b in pieces of MIN_BURST. every piece makes the
+// reader wait once, for step. the clock is moved by exactly that each time.
+// returns how long it took on the clock
+func readThrottled(t *testing.T, f *clock.Fake, b *Bucket, size int, step time.Duration) time.Duration {
+	t.Helper()
+	r := NewLimitedReader(context.Background(), bytes.NewReader(make([]byte, size)), b)
+	done := make(chan int64)
+	go func() {
+		// hides ReadFrom of io.Discard so our buffer size is used
+		n, _ := io.CopyBuffer(struct{ io.Writer }{io.Discard}, r, make([]byte, MIN_BURST))
+		done <- n
+	}()
+	begin := f.Now()
+	for i := 0; i < size/MIN_BURST; i++ {
+		f.BlockUntil(1)
+		f.Advance(step)
+	}
+	if n := <-done; n != int64(size) {
+		t.Fatalf("read %d bytes, want %d", n, size)
+	}
+	return f.Now().Sub(begin)
+}
+
+func TestBucketLimitsRate(t *testing.T) {
+	f := clock.NewFake(epoch)
+	b := NewRootBucket(64*1024, f)
+	took := readThrottled(t, f, b, 256*1024, 250*time.Millisecond)
+	if took != 4*time.Second {
+		t.Errorf("256KiB at 64KiB/s took %v, want 4s", took)
+	}
+}
+
+func TestBucketParentLimits(t *testing.T) {
+	f := clock.NewFake(epoch)
+	global := NewRootBucket(32*1024, f)
+	queue := NewBucket(0, global) // no limit of its own
+	dl := NewBucket(1024*1024, queue)
+	// the global one is the slowest so that's what every piece waits for
+	took := readThrottled(t, f, dl, 64*1024, 500*time.Millisecond)
+	if took != 2*time.Second {
+		t.Errorf("64KiB under a 32KiB/s parent took %v, want 2s", took)
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	var b *Bucket
+	if err := b.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	f := clock.NewFake(epoch)
+	if err := NewRootBucket(0, f).WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBucketSetRate(t *testing.T) {
+	f := clock.NewFake(epoch)
+	b := NewRootBucket(16*1024, f)
+	took := readThrottled(t, f, b, 32*1024, time.Second)
+	if took != 2*time.Second {
+		t.Fatalf("32KiB at 16KiB/s took %v, want 2s", took)
+	}
+	// twice as fast from now on
+	b.SetRate(32 * 1024)
+	took = readThrottled(t, f, b, 32*1024, 500*time.Millisecond)
+	if took != time.Second {
+		t.Errorf("32KiB at 32KiB/s took %v, want 1s", took)
+	}
+	// no limit, nothing waits at all
+	b.SetRate(0)
+	if err := b.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBucketWaitCancelled(t *testing.T) {
+	f := clock.NewFake(epoch)
+	b := NewRootBucket(1024, f)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.WaitN(ctx, 1<<20) }()
+	f.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
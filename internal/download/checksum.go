@@ -0,0 +1,209 @@
+package download
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// supported algorithms from the weakest to the strongest.
+// when the server gives us more than one we pick the strongest
+var checksumAlgorithms = []string{"md5", "sha1", "sha256", "sha512"}
+
+// an expected (or verified) digest of the whole file
+type Checksum struct {
+	Algorithm string // one of checksumAlgorithms
+	Value     string // lowercase hex
+}
+
+func (c Checksum) IsEmpty() bool {
+	return c.Algorithm == ""
+}
+
+func (c Checksum) String() string {
+	if c.IsEmpty() {
+		return ""
+	}
+	return c.Algorithm + ":" + c.Value
+}
+
+// turns "SHA-256", "sha256" and friends into the names we use
+func normalizeAlgorithm(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.ReplaceAll(name, "-", "")
+}
+
+func algorithmRank(algo string) int {
+	for i, a := range checksumAlgorithms {
+		if a == algo {
+			return i
+		}
+	}
+	return -1
+}
+
+func newHash(algo string) hash.Hash {
+	switch algo {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// parses the user given format which is "<algorithm>:<hex digest>"
+// e.g. sha256:9f86d08...  an empty string means no checksum
+func ParseChecksum(s string) (Checksum, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Checksum{}, nil
+	}
+	algo, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return Checksum{}, fmt.Errorf("bad checksum %q: expected <algorithm>:<hex digest>", s)
+	}
+	algo = normalizeAlgorithm(algo)
+	h := newHash(algo)
+	if h == nil {
+		return Checksum{}, fmt.Errorf("unsupported checksum algorithm %q (use md5, sha1, sha256 or sha512)", algo)
+	}
+	value = strings.ToLower(strings.TrimSpace(value))
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) != h.Size() {
+		return Checksum{}, fmt.Errorf("bad %s digest %q", algo, value)
+	}
+	return Checksum{Algorithm: algo, Value: value}, nil
+}
+
+// builds a checksum out of a base64 digest coming from a header.
+// returns false if it doesn't make sense
+func checksumFromBase64(algo, b64 string) (Checksum, bool) {
+	algo = normalizeAlgorithm(algo)
+	h := newHash(algo)
+	if h == nil {
+		return Checksum{}, false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+	if err != nil || len(raw) != h.Size() {
+		return Checksum{}, false
+	}
+	return Checksum{Algorithm: algo, Value: hex.EncodeToString(raw)}, true
+}
+
+// parses the `algo=value, algo=value` lists used by all of the digest headers.
+// Repr-Digest and Content-Digest wrap the value in colons (structured fields)
+// and the old Digest header doesn't
+func parseDigestList(header string) []Checksum {
+	sums := make([]Checksum, 0)
+	for _, item := range strings.Split(header, ",") {
+		algo, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), ":")
+		if sum, ok := checksumFromBase64(algo, value); ok {
+			sums = append(sums, sum)
+		}
+	}
+	return sums
+}
+
+// picks the strongest digest the server advertised for the whole file.
+// the empty checksum is returned if there isn't any. Content-Digest and
+// Content-MD5 are about the body that was sent, so they only count if the
+// body is the whole file (whole is false for a range)
+func checksumFromHeaders(header http.Header, whole bool) Checksum {
+	sums := make([]Checksum, 0)
+	names := []string{"Repr-Digest", "Digest"}
+	if whole {
+		names = append(names, "Content-Digest")
+	}
+	for _, name := range names {
+		for _, value := range header.Values(name) {
+			sums = append(sums, parseDigestList(value)...)
+		}
+	}
+	if md5sum := header.Get("Content-MD5"); whole && md5sum != "" {
+		if sum, ok := checksumFromBase64("md5", md5sum); ok {
+			sums = append(sums, sum)
+		}
+	}
+	best := Checksum{}
+	for _, sum := range sums {
+		if best.IsEmpty() || algorithmRank(sum.Algorithm) > algorithmRank(best.Algorithm) {
+			best = sum
+		}
+	}
+	return best
+}
+
+// hashes the whole file with the given algorithm
+func hashFile(filePath string, algo string) (string, error) {
+	h := newHash(algo)
+	if h == nil {
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s for hashing: %v", filePath, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %v", filePath, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checks the finished file against the expected checksum (if there is one).
+// a file that doesn't match is removed because there is no way to tell
+// which part of it is broken so the next try starts from scratch
+func (h *DownloadHandler) verifyChecksum() error {
+	want := h.expectedChecksum()
+	if want.IsEmpty() {
+		return nil
+	}
+	fmt.Printf("Verifying %s checksum of %s\n", want.Algorithm, h.FilePath)
+	got, err := hashFile(h.FilePath, want.Algorithm)
+	if err != nil {
+		return err
+	}
+	if got != want.Value {
+		if err := os.Remove(h.FilePath); err != nil {
+			fmt.Printf("Warning: failed to remove corrupted file %s: %v\n", h.FilePath, err)
+		}
+		// nothing we have is trustworthy anymore so a retry has to download everything again
+		h.State.Mutex.Lock()
+		h.State.Completed = make([]bool, len(h.State.Completed))
+		h.State.IncompleteParts = nil
+		h.State.CurrentByte = 0
+		h.State.Mutex.Unlock()
+		return fmt.Errorf("%w: expected %s, got %s:%s", ErrChecksumMismatch, want, want.Algorithm, got)
+	}
+	h.VerifiedChecksum = want.String()
+	return nil
+}
+
+// the user's checksum wins over whatever the server advertised
+func (h *DownloadHandler) expectedChecksum() Checksum {
+	if !h.Checksum.IsEmpty() {
+		return h.Checksum
+	}
+	return h.Advertised
+}
@@ -0,0 +1,175 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrFileExists = errors.New("there is already a file")
+
+// what to do when the file a download saves to is already there
+type CollisionPolicy int
+
+const (
+	RenameOnCollision    CollisionPolicy = iota // saves as name (1).ext, name (2).ext, ...
+	OverwriteOnCollision                        // replaces what is there
+	SkipIfIdentical                             // keeps what is there if it's the same file, renames otherwise
+	FailOnCollision                             // stops and lets the user decide
+)
+
+func (p CollisionPolicy) String() string {
+	switch p {
+	case OverwriteOnCollision:
+		return "Overwrite"
+	case SkipIfIdentical:
+		return "Skip"
+	case FailOnCollision:
+		return "Fail"
+	}
+	return "Rename"
+}
+
+// dir/name (n).ext for dir/name.ext. everything after the first dot counts
+// as the extension so archive.tar.gz becomes archive (1).tar.gz
+func NumberedPath(p string, n int) string {
+	dir, name := filepath.Split(p)
+	base, ext := name, ""
+	if i := strings.Index(name[min(1, len(name)):], "."); i >= 0 { // a leading dot is part of the name
+		base, ext = name[:i+1], name[i+1:]
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+}
+
+// true if anything of a download is at p: the file or its parts
+func PathInUse(p string) bool {
+	if _, err := os.Lstat(p); err == nil {
+		return true
+	}
+	return hasPartFiles(p)
+}
+
+// the .partN files and the stream of a download saving to p. not a glob,
+// the name might have brackets in it
+func PartFilesOf(p string) []string {
+	dir := filepath.Dir(p)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	prefix := filepath.Base(p) + ".part"
+	var files []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files
+}
+
+func hasPartFiles(p string) bool {
+	return len(PartFilesOf(p)) > 0
+}
+
+// p if nothing is there, otherwise the first numbered path that is free.
+// taken can say that a path belongs to something that isn't on disk yet
+func FreePath(p string, taken func(string) bool) string {
+	free := func(p string) bool {
+		return !PathInUse(p) && (taken == nil || !taken(p))
+	}
+	if free(p) {
+		return p
+	}
+	for n := 1; ; n++ {
+		if candidate := NumberedPath(p, n); free(candidate) {
+			return candidate
+		}
+	}
+}
+
+// runs right before the finished data is put at FilePath. whatever is there
+// now isn't ours so the policy decides what happens. ours are the files that
+// hold our data in order and are moved along if we rename. they are nil when
+// the data is already at FilePath (preallocated), then a skip can't compare
+// and renames instead. returns true if ours should be thrown away because
+// the same file is already there
+func (h *DownloadHandler) resolveCollision(ours []string) (bool, error) {
+	if err := h.checkPath(); err != nil {
+		return false, err
+	}
+	info, err := os.Stat(h.FilePath)
+	if err != nil {
+		return false, nil // nothing there. or something we can't see, then the write tells
+	}
+	switch h.OnCollision {
+	case OverwriteOnCollision:
+		fmt.Printf("Overwriting %s\n", h.FilePath)
+		return false, nil
+	case FailOnCollision:
+		return false, fmt.Errorf("%w at %s", ErrFileExists, h.FilePath)
+	case SkipIfIdentical:
+		if ours != nil && sameContent(h.FilePath, info.Size(), ours) {
+			fmt.Printf("%s is already there, keeping it\n", h.FilePath)
+			return true, nil
+		}
+	}
+	newPath := FreePath(h.FilePath, nil)
+	fmt.Printf("%s is already there, saving as %s\n", h.FilePath, newPath)
+	for _, file := range ours {
+		moved := newPath + strings.TrimPrefix(file, h.FilePath)
+		if err := os.Rename(file, moved); err != nil {
+			return false, fmt.Errorf("failed to rename %s: %v", file, err)
+		}
+	}
+	h.State.Mutex.Lock()
+	h.FilePath = newPath
+	h.State.Mutex.Unlock()
+	return false, nil
+}
+
+// whether the file at p (of the given size) has exactly what the pieces
+// have one after the other
+func sameContent(p string, size int64, pieces []string) bool {
+	var total int64
+	for _, piece := range pieces {
+		info, err := os.Stat(piece)
+		if err != nil {
+			return false
+		}
+		total += info.Size()
+	}
+	if total != size {
+		return false
+	}
+	want, err := hashFile(p, "sha256")
+	if err != nil {
+		return false
+	}
+	h := sha256.New()
+	for _, piece := range pieces {
+		file, err := os.Open(piece)
+		if err != nil {
+			return false
+		}
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			return false
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)) == want
+}
+
+// removes our copy of the data after a skip
+func removeAll(files []string) {
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			fmt.Printf("Warning: failed to remove %s: %v\n", file, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNumberedPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/d/setup.exe", "/d/setup (2).exe"},
+		{"/d/archive.tar.gz", "/d/archive (2).tar.gz"},
+		{"/d/README", "/d/README (2)"},
+		{"/d/.bashrc", "/d/.bashrc (2)"},
+		{"/d/.config.json", "/d/.config (2).json"},
+	}
+	for _, tt := range tests {
+		if got := NumberedPath(tt.path, 2); got != tt.want {
+			t.Errorf("NumberedPath(%q, 2) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFreePath(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "file[1].bin")
+	if got := FreePath(p, nil); got != p {
+		t.Errorf("nothing there but got %s", got)
+	}
+	os.WriteFile(p, nil, 0644)
+	// only the parts of (1) are there, it's still somebody's
+	os.WriteFile(NumberedPath(p, 1)+".part0", nil, 0644)
+	taken := func(s string) bool { return s == NumberedPath(p, 2) }
+	if got, want := FreePath(p, taken), NumberedPath(p, 3); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
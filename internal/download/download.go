@@ -10,6 +10,7 @@ const(
 	HANDLER_NAME = "Handler"
 	CHUNK_SIZE = 1024 * 1024 // 1mb chunks
 	WORKER_COUNT = 8
+	UNKNOWN_LENGTH = -1 // the size of a file the server didn't tell us (yet)
 )
 
 type Download struct {
@@ -19,7 +20,19 @@ type Download struct {
 	Status       State
 	RetryCount   int64
 	MaxRetries   int64
-
+	ChunkRetries int64 // retries of a single chunk before the whole download counts as failed. 0 means default
+	Storage      StorageMode
+	OnRemoteChange ChangePolicy // restart or fail when the file changes on the server
+	OnCollision  CollisionPolicy // what happens when there is already a file where this one goes
+	Checksum     Checksum // expected checksum given by the user. can be empty
+	VerifiedChecksum string // what the finished file was verified against
+	FailReason   string // why the last attempt failed
+	Priority     int64 // higher ones run first
+	Position     int64 // order among the downloads of a queue with the same priority. lower runs first
+	MaxBandwidth int64 // bytes per second for this download alone. 0 means only the queue and global limits apply
+	FixedName    bool // the user gave the file its name. otherwise the server can pick one when it starts
+	Request      RequestOptions `json:",omitempty"` // its own headers, cookies and user agent, on top of the ones of the queue
+	Auth         Credentials `json:"-"` // never in the state file. the manager keeps it in a file of its own
 
 	Handler		DownloadHandler `json:"-"`
 }
@@ -36,18 +49,43 @@ func (d *Download) GetProgress() float64 {
 	return d.Handler.Progress.GetProgress()
 }
 
+// bytes downloaded so far and the size of the file. the size is
+// UNKNOWN_LENGTH when the server doesn't say, then only the bytes mean anything
+func (d *Download) GetBytes() (int64, int64) {
+	d.Handler.State.Mutex.Lock()
+	defer d.Handler.State.Mutex.Unlock()
+	return d.Handler.State.CurrentByte, d.Handler.State.TotalBytes
+}
+
 func (d *Download) GetSpeed() string {
 	// formatted speed
 	return d.Handler.Progress.GetCurrentSpeed()
 }
 
 func CreateDefaultHandler(d *Download) {
-	d.Handler = *d.NewDownloadHandler(&http.Client{Timeout: 0}, 0)
-	// TODO check bandwidth limit because its buggy
+	d.Handler = *d.NewDownloadHandler(&http.Client{Timeout: 0}, d.MaxBandwidth)
+	d.Handler.Storage = d.Storage
+	d.Handler.Checksum = d.Checksum
+	d.Handler.ChunkRetry = NewRetryPolicy(d.ChunkRetries)
+	d.Handler.OnRemoteChange = d.OnRemoteChange
+	d.Handler.OnCollision = d.OnCollision
+	d.Handler.FixedName = d.FixedName
+}
+
+// the handler might have renamed the file when it started. this is where
+// it really is, FilePath only catches up when the manager hears from it
+func (d *Download) GetFilePath() string {
+	if d.Handler.State == nil {
+		return d.FilePath
+	}
+	return d.Handler.GetFilePath()
 }
 
 func (d Download) MarshalJSON() ([]byte, error) {
 	dlst, _ := d.Handler.Export()
+	if dlst.FilePath != "" {
+		d.FilePath = dlst.FilePath // it's a copy, in case the handler renamed it
+	}
 	rep := downloadRepresentation{
 		DownloadAlias: (*DownloadAlias)(&d),
 		SavedState: *dlst,
@@ -55,13 +93,24 @@ func (d Download) MarshalJSON() ([]byte, error) {
 	return json.Marshal(rep)
 }
 
-func (d *Download) UnmarshalJson(bts []byte) (error) {
+func (d *Download) UnmarshalJSON(bts []byte) (error) {
 	var rep = downloadRepresentation{
 		DownloadAlias: (*DownloadAlias)(d),
 	}
 	if err := json.Unmarshal(bts, &rep); err != nil {
 		return err
 	}
+	if rep.SavedState.URL == "" {
+		// saved without a handler. it has to start from nothing anyway
+		rep.SavedState.URL = d.URL
+		rep.SavedState.FilePath = d.FilePath
+		rep.SavedState.Storage = d.Storage
+		rep.SavedState.Checksum = d.Checksum
+		rep.SavedState.ChunkRetry = NewRetryPolicy(d.ChunkRetries)
+		rep.SavedState.OnRemoteChange = d.OnRemoteChange
+		rep.SavedState.OnCollision = d.OnCollision
+		rep.SavedState.FixedName = d.FixedName
+	}
 	hd, err := Import(&rep.SavedState, &http.Client{Timeout: 0})
 	if err != nil {
 		return err
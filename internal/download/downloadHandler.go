@@ -8,6 +8,8 @@ import (
 	"sync"
 	"context"
 	"time"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
 )
 
 type DownloadHandler struct {
@@ -17,7 +19,9 @@ type DownloadHandler struct {
     PartsCount    int64
 
     URL           string
-    FilePath      string
+    FilePath      string // changes once when the server tells us a name. use GetFilePath from outside
+    FixedName     bool   // the user picked the name, don't take the one of the server
+    remoteName    string // the name the server told us in the last probe
     State         *DownloadState
 
 	ctx           context.Context    
@@ -27,7 +31,28 @@ type DownloadHandler struct {
 	
 	Progress        *ProgressTracker
 
-	BandwidthLimit int64 // bytes per second, 0 means no limit
+	Bandwidth      *Bucket // limits how fast the workers read. nil means no limit
+	Storage        StorageMode // part files or one preallocated file
+
+	Checksum         Checksum // expected digest of the whole file. empty means don't check
+	Advertised       Checksum // what the server says the file hashes to. only used if Checksum is empty
+	VerifiedChecksum string   // set once the finished file matched Checksum
+
+	ChunkRetry RetryPolicy // how hard we try a single chunk before giving up on the download
+	Remote         RemoteValidator // etag and stuff of the version we are downloading
+	OnRemoteChange ChangePolicy    // what to do if that version goes away
+	OnCollision    CollisionPolicy // what to do if FilePath is taken when we are done
+	remoteChanged  bool            // the last run failed because of a change
+	stopped    chan struct{} // closed when the last run of the workers is completely over
+	Clock      clock.Clock // for waiting between retries. nil is the real one
+	// picks where the file goes when the server names it p and moves us
+	// there with SetFilePath before anyone else can pick it. nil goes by
+	// what is on disk only
+	ClaimPath  func(ctx context.Context, p string, policy CollisionPolicy) error
+	request    RequestOptions // headers, cookies and user agent. SetRequestOptions changes them
+	auth       *authState     // who we log in as. Client sends it, SetCredentials changes it
+	network    *networkState  // the proxy and tls settings of Client. SetProxy and SetTLS change them
+	root       string         // the directory FilePath has to be in. SetRoot changes it
 }
 
 type DownloadState struct {
@@ -45,22 +70,22 @@ type chunk struct {
 }
 
 // Initializing 
+// the server isn't asked anything yet. how the file is split up is decided
+// when the download starts and we know its size
 func (download *Download) NewDownloadHandler(client *http.Client,bandwidthLimit int64) *DownloadHandler {
 	ctx, cancel := context.WithCancel(context.Background())
-
-	// we might need this to avoid NaN we got for speed:
-	resp, err := client.Head(download.URL)
-	if err != nil {
-		fmt.Printf("Failed to get content length: %v\n", err)
-	}
-	defer resp.Body.Close()
-	cl := resp.ContentLength
+	auth := &authState{}
+	network := &networkState{}
 
 	dh := &DownloadHandler{
-        Client:   client,
+        Client:   withAuth(withNetwork(client, network), auth),
+        auth:     auth,
+        network:  network,
+        CHUNK_SIZE: CHUNK_SIZE,
+        WORKERS_COUNT: 4,
         URL:      download.URL,
         FilePath: download.FilePath,
-        State:    &DownloadState{TotalBytes: cl},
+        State:    &DownloadState{TotalBytes: UNKNOWN_LENGTH},
         PauseChan: make(chan struct{}),
         ResumeChan: make(chan struct{}),
         ctx:      ctx,
@@ -70,107 +95,60 @@ func (download *Download) NewDownloadHandler(client *http.Client,bandwidthLimit
             LastUpdateTime: time.Now(),
             SpeedSamples:   make([]float64, 0, 5), // Initialize SpeedSamples
         },
-		BandwidthLimit: bandwidthLimit,
+		Bandwidth: NewBucket(bandwidthLimit, nil),
     }
 
-	// Call the optimization functions inside the handler setup
-    dh.CHUNK_SIZE = dh.calculateOptimalChunkSize(cl)
-    dh.WORKERS_COUNT = dh.calculateOptimalWorkerCount(cl)
-
     return dh
 }
 
 func (h *DownloadHandler) StartDownloading() error {
+	return h.restartOnChange(h.startDownloading)
+}
+
+func (h *DownloadHandler) startDownloading() error {
 	// First, we will check if the server supports range requests or not -> using our IsAcceptRangeSupported() method
     supportsRange, contentLength, err := h.IsAcceptRangeSupported()
     if err != nil {
         return err
     }
+    if err := h.takeRemoteName(); err != nil {
+        return err
+    }
+    if err := h.checkPath(); err != nil {
+        return err
+    }
 
-	// If the server does not support range requests, we will download the file without using range requests
-    if (!supportsRange) {
-        return h.downloadWithoutRanges()
+	// If the server does not support range requests, we will download the file without using range requests.
+	// same if we don't know where the file ends, there is nothing to split up then
+    if (!supportsRange || contentLength <= 0) {
+        return h.downloadWithoutRanges(contentLength)
     }
 
+	// Call the optimization functions now that we know the size
+    h.CHUNK_SIZE = h.calculateOptimalChunkSize(contentLength)
+    h.WORKERS_COUNT = h.calculateOptimalWorkerCount(contentLength)
     h.PartsCount = (contentLength + h.CHUNK_SIZE - 1) / h.CHUNK_SIZE
+    h.State.Mutex.Lock()
     h.State.Completed = make([]bool, h.PartsCount)
+    h.State.IncompleteParts = nil
+    h.State.CurrentByte = 0
     h.State.TotalBytes = int64(contentLength)
+    h.State.Mutex.Unlock()
 
-    // jobs: it's a channel used to send chunks to worker "task to download a specific piece (or "chunk")""
-    jobs := make(chan chunk, h.WORKERS_COUNT)    // sends chunk information to workers
-    errChan := make(chan error, h.WORKERS_COUNT)
-    done := make(chan bool, 1) // used to notify if it's done or not
-    pauseAck := make(chan bool, h.WORKERS_COUNT) // channel to acknowledge worker pause completion
-
-
-    var wg sync.WaitGroup
-    for i := 0; i < h.WORKERS_COUNT; i++ {
-        wg.Add(1)
-        go h.worker(i, jobs, errChan, pauseAck, &wg)
-    }
-
-	go func() {
-		h.distributeJobs(jobs, int(contentLength))
-		// close(jobs) // close jobs channel after all tasks are sent 
-	}()
-
-	// waiting for workers to be done
-	go func() {
-        wg.Wait()
-        h.State.Mutex.Lock()
-        if h.State.CurrentByte >= h.State.TotalBytes {
-            done <- true
+    if h.Storage == Preallocated {
+        // the data goes straight to FilePath so this is the last chance to not overwrite something
+        if _, err := h.resolveCollision(nil); err != nil {
+            return err
         }
-        h.State.Mutex.Unlock()
-    }()
-
-	select {
-    case <-done:
-        close(errChan)
-        for err := range errChan {
-            if err != nil {
-                return err
-            }
+        if err := h.preallocate(contentLength); err != nil {
+            return err
         }
-        fmt.Println("Calling combineParts")
-        return h.combineParts(contentLength)
-    case err := <-errChan:
-		// close(jobs)
-        return err
-    }
-}
-
-func (h *DownloadHandler) downloadWithoutRanges() error {
-    req, err := http.NewRequest("GET",h.URL, nil)
-    if err != nil {
-        return fmt.Errorf("failed to create request: %v", err)
     }
 
-    resp, err := h.Client.Do(req)
-    if err != nil {
-        return fmt.Errorf("failed to execute request: %v", err)
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode >= 400 {
-        return fmt.Errorf("server returned error status: %d", resp.StatusCode)
-    }
-
-    file, err := os.Create(h.FilePath)
-    if err != nil {
-        return fmt.Errorf("failed to create file: %v", err)
-    }
-    defer file.Close()
-
-    _, err = io.Copy(file, resp.Body)
-    if err != nil {
-        return fmt.Errorf("failed to download file: %v", err)
-    }
-
-    return nil
+    return h.runWorkers()
 }
 
-func (h *DownloadHandler) downloadWithRanges(start int64, end int64) error {
+func (h *DownloadHandler) downloadWithRanges(ctx context.Context, start int64, end int64) error {
 	// defining expected sixe and stuff we will use later 
 	expectedSize := end - start + 1
     partNumber := start / h.CHUNK_SIZE
@@ -178,17 +156,30 @@ func (h *DownloadHandler) downloadWithRanges(start int64, end int64) error {
     fmt.Printf("Worker starting download for chunk %d-%d at %s\n", start, end, time.Now().Format(time.RFC3339))
 
 	// Check if part exists and is valid
-	if info, err := os.Stat(partFileName); err == nil && info.Size() == expectedSize {
-		fmt.Printf("Chunk %d-%d already complete on disk\n", start, end)
-		return nil
+	// (only for part files. a preallocated file always has the full size so
+	// for that one State.Completed is what tells us a chunk is done)
+	if h.Storage == PartFiles {
+		if info, err := os.Stat(partFileName); err == nil && info.Size() == expectedSize {
+			fmt.Printf("Chunk %d-%d already complete on disk\n", start, end)
+			h.State.Mutex.Lock()
+			h.State.CurrentByte += expectedSize
+			h.State.Mutex.Unlock()
+			return nil
+		}
 	}
 
 	// creating request for server
-	req, err := http.NewRequest("GET", h.URL, nil)
+	req, err := h.newRequest(ctx, "GET")
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
 	req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	// only give us the range if it's still the same file. otherwise the server
+	// sends the whole new file with a 200 and we know something changed
+	ifRange := h.Remote.ifRange()
+	if ifRange != "" {
+		req.Header.Add("If-Range", ifRange)
+	}
 
 	// executing the request
     resp, err := h.Client.Do(req)
@@ -199,51 +190,59 @@ func (h *DownloadHandler) downloadWithRanges(start int64, end int64) error {
 
 	// validating response:
 	// server status
+    if resp.StatusCode == http.StatusOK && ifRange != "" {
+        return ErrRemoteChanged
+    }
     if resp.StatusCode != http.StatusPartialContent {
-        return fmt.Errorf("server returned unexpected status: %d", resp.StatusCode)
+        return newStatusError(resp, clock.Or(h.Clock).Now())
     }
 	// making sure server is returning expectedSize
     if resp.ContentLength != expectedSize {
         return fmt.Errorf("server returned wrong content length: got %d, want %d", resp.ContentLength, expectedSize)
     }
 
-    // creating file we will write the chunk on
-    file, err := os.OpenFile(partFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    // opening the part file (or the preallocated file at the right offset) we will write the chunk on
+    file, dst, err := h.openChunkWriter(start, partFileName)
     if err != nil {
-        return fmt.Errorf("failed to create part file %s: %v", partFileName, err)
+        return err
     }
 	defer file.Close()
 
     // now we will use a custom reader to count actual bytes read from the response -> make sure we are reading and writing all bytes
 
 	// Use a custom reader to count actual bytes read
+	// it also adds them to CurrentByte as they come so if this chunk
+	// doesn't make it we have to take them back out
 	var totalRead int64
 	counting := &countingReader{reader: resp.Body, count: &totalRead, handler: h}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			h.State.Mutex.Lock()
+			h.State.CurrentByte -= totalRead
+			h.State.Mutex.Unlock()
+		}
+	}()
 
-	var reader io.Reader = counting
-    if h.BandwidthLimit > 0 {
-        reader = NewLimitedReader(counting, h.BandwidthLimit)
-    }
-
+	reader := NewLimitedReader(ctx, counting, h.Bandwidth)
 
     buffer := make([]byte, 4*1024)
-    written, err := io.CopyBuffer(file, reader, buffer)
+    written, err := io.CopyBuffer(dst, reader, buffer)
     if err != nil {
         return fmt.Errorf("failed to write chunk: %v", err)
     }
 
-    // Update progress incrementally
-    h.State.Mutex.Lock()
-    h.State.CurrentByte += written
-    h.State.Mutex.Unlock()
-
     // ennsuring file is properly written
     if err := file.Sync(); err != nil {
-        return fmt.Errorf("failed to sync part file %s: %v", partFileName, err)
+        return fmt.Errorf("failed to sync %s: %v", file.Name(), err)
     }
 
-    // verifying file size after closing
-    if info, err := os.Stat(partFileName); err != nil {
+    // verifying the size of what we wrote
+    if h.Storage == Preallocated {
+        if written != expectedSize {
+            return fmt.Errorf("chunk size mismatch: wrote %d, want %d", written, expectedSize)
+        }
+    } else if info, err := os.Stat(partFileName); err != nil {
         return fmt.Errorf("failed to stat part file %s: %v", partFileName, err)
     } else if info.Size() != expectedSize {
         return fmt.Errorf("file size mismatch after close: got %d, want %d", info.Size(), expectedSize)
@@ -251,6 +250,7 @@ func (h *DownloadHandler) downloadWithRanges(start int64, end int64) error {
 
     fmt.Printf("Completed part %d, wrote %d bytes (read from server: %d bytes, verified on disk: %d bytes)\n",
         partNumber, written, totalRead, expectedSize)
+    succeeded = true
 
     return nil
 }
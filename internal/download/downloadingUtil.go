@@ -3,18 +3,40 @@ package download
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
     "io"
     "context"
     "time"
 )
 
+// finds out if the server does ranges and how big the file is (UNKNOWN_LENGTH
+// if it doesn't say). HEAD is asked first. if it doesn't work, doesn't know
+// the length or doesn't mention ranges we ask for the first byte with a GET,
+// some servers block HEAD or only talk about ranges when asked for one
 func (h *DownloadHandler) IsAcceptRangeSupported() (bool, int64, error) {
-    req, err := http.NewRequest("HEAD", h.URL, nil)
+    supportsRange, length, err := h.probeHead()
+    if err == nil && supportsRange && length >= 0 {
+        return true, length, nil
+    }
+    if err != nil {
+        fmt.Println("HEAD didn't work, asking for the first byte instead:", err)
+    }
+    rangeSupported, rangeLength, rerr := h.probeRange()
+    if rerr != nil {
+        if err == nil {
+            return supportsRange, length, nil // HEAD at least answered
+        }
+        return false, 0, rerr
+    }
+    return rangeSupported, rangeLength, nil
+}
+
+func (h *DownloadHandler) probeHead() (bool, int64, error) {
+    req, err := h.newRequest(context.Background(), "HEAD")
     if err != nil {
         return false, 0, fmt.Errorf("failed to create HEAD request: %v", err)
     }
-    req.Header.Add("User-Agent", "Go-Download-Client/1.0")
 
     resp, err := h.Client.Do(req)
     if err != nil {
@@ -30,6 +52,8 @@ func (h *DownloadHandler) IsAcceptRangeSupported() (bool, int64, error) {
         return false, 0, fmt.Errorf("server returned status: %d", resp.StatusCode)
     }
 
+    h.learnFrom(resp, resp.ContentLength)
+
     acceptRanges := strings.ToLower(resp.Header.Get("Accept-Ranges"))
     fmt.Println("Accept-Ranges:", acceptRanges)
 
@@ -43,6 +67,85 @@ func (h *DownloadHandler) IsAcceptRangeSupported() (bool, int64, error) {
     return false, resp.ContentLength, nil
 }
 
+// a GET for the first byte. a 206 means ranges work and Content-Range tells
+// the size, a 200 means the server ignored the range
+func (h *DownloadHandler) probeRange() (bool, int64, error) {
+    req, err := h.newRequest(context.Background(), "GET")
+    if err != nil {
+        return false, 0, fmt.Errorf("failed to create request: %v", err)
+    }
+    req.Header.Add("Range", "bytes=0-0")
+
+    resp, err := h.Client.Do(req)
+    if err != nil {
+        return false, 0, fmt.Errorf("range probe failed: %v", err)
+    }
+    // we don't want the body. if the server sends the whole file anyway
+    // closing it just drops the connection
+    defer resp.Body.Close()
+
+    switch {
+    case resp.StatusCode == http.StatusPartialContent:
+        length, ok := parseContentRange(resp.Header.Get("Content-Range"))
+        if !ok {
+            return false, 0, fmt.Errorf("server sent a bad Content-Range: %q", resp.Header.Get("Content-Range"))
+        }
+        h.learnFrom(resp, length)
+        return true, length, nil
+    case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+        // an empty file doesn't even have a first byte
+        length, ok := parseContentRange(resp.Header.Get("Content-Range"))
+        if !ok {
+            return false, 0, fmt.Errorf("server returned status: %d", resp.StatusCode)
+        }
+        h.learnFrom(resp, length)
+        return false, length, nil
+    case resp.StatusCode >= 400:
+        return false, 0, fmt.Errorf("server returned status: %d", resp.StatusCode)
+    }
+    h.learnFrom(resp, resp.ContentLength)
+    return false, resp.ContentLength, nil
+}
+
+// remembers what identifies this version of the file. length is the size of
+// the whole file which isn't the Content-Length of a range
+func (h *DownloadHandler) learnFrom(resp *http.Response, length int64) {
+    h.Remote = validatorFromResponse(resp)
+    h.Remote.Size = length
+    h.remoteName = fileNameFromResponse(resp)
+
+    // if nobody told us what the file should hash to, maybe the server knows.
+    // it's kept apart from Checksum because it's only true for this version
+    if h.Checksum.IsEmpty() {
+        // a 206 (or 416) body is only a piece of the file
+        if sum := checksumFromHeaders(resp.Header, resp.StatusCode == http.StatusOK); !sum.IsEmpty() {
+            fmt.Println("Server advertised checksum:", sum)
+            h.Advertised = sum
+        }
+    }
+}
+
+// the size of the whole file from "bytes 0-0/1234" or "bytes */1234".
+// "*" means the server doesn't know it either
+func parseContentRange(value string) (int64, bool) {
+    unit, rest, ok := strings.Cut(value, " ")
+    if !ok || unit != "bytes" {
+        return 0, false
+    }
+    _, total, ok := strings.Cut(rest, "/")
+    if !ok {
+        return 0, false
+    }
+    if total == "*" {
+        return UNKNOWN_LENGTH, true
+    }
+    n, err := strconv.ParseInt(total, 10, 64)
+    if err != nil || n < 0 {
+        return 0, false
+    }
+    return n, true
+}
+
 // Custom reader to ensure we are reading bytes properly
 type countingReader struct {
     reader io.Reader
@@ -66,6 +169,12 @@ func (r *countingReader) Read(p []byte) (n int, err error) {
 
 func (h *DownloadHandler) combineParts( contentLength int64) error {
     c :=  NewPartsCombiner(contentLength,int(h.PartsCount),h.CHUNK_SIZE)
+    if h.Storage == Preallocated {
+        // everything is already in place, just make sure nothing is missing
+        h.State.Mutex.Lock()
+        defer h.State.Mutex.Unlock()
+        return c.VerifyInPlace(h.FilePath, contentLength, h.State.Completed)
+    }
     return c.CombineParts(h.FilePath, contentLength, int(h.PartsCount))
 }
 
@@ -74,12 +183,14 @@ func (download *Download) NewDlHandler(client *http.Client, chunkSize int64, wor
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// we might need this to avoid NaN we got for speed:
+	cl := int64(UNKNOWN_LENGTH)
 	resp, err := client.Head(download.URL)
 	if err != nil {
 		fmt.Printf("Failed to get content length: %v\n", err)
+	} else {
+		resp.Body.Close()
+		cl = resp.ContentLength
 	}
-	defer resp.Body.Close()
-	cl := resp.ContentLength
 
     dh := &DownloadHandler{
         Client:            client,
@@ -0,0 +1,71 @@
+package download
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/placeholder14032/download-manager/internal/testorigin"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"bytes 0-0/1234", 1234, true},
+		{"bytes */1234", 1234, true},
+		{"bytes 0-0/*", UNKNOWN_LENGTH, true},
+		{"bytes 0-0", 0, false},
+		{"items 0-0/10", 0, false},
+		{"bytes 0-0/lots", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseContentRange(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseContentRange(%q) = %d, %v, want %d, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	o := testorigin.New()
+	defer o.Close()
+	data := make([]byte, 5000)
+	tests := []struct {
+		name       string
+		file       testorigin.File
+		wantRanges bool
+		wantLength int64
+	}{
+		{"head", testorigin.File{}, true, 5000},
+		{"head blocked", testorigin.File{NoHead: true}, true, 5000},
+		{"ranges not advertised", testorigin.File{HideRanges: true}, true, 5000},
+		{"no ranges", testorigin.File{NoRanges: true}, false, 5000},
+		{"no length", testorigin.File{NoLength: true}, false, UNKNOWN_LENGTH},
+		{"nothing", testorigin.File{NoLength: true, NoHead: true}, false, UNKNOWN_LENGTH},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.file
+			f.Data = data
+			h := &DownloadHandler{Client: http.DefaultClient, URL: o.Add("/"+tt.name, &f)}
+			ranges, length, err := h.IsAcceptRangeSupported()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ranges != tt.wantRanges || length != tt.wantLength {
+				t.Errorf("got ranges %v and length %d, want %v and %d", ranges, length, tt.wantRanges, tt.wantLength)
+			}
+			if h.Remote.ETag == "" {
+				t.Error("the etag wasn't kept")
+			}
+		})
+	}
+
+	h := &DownloadHandler{Client: http.DefaultClient, URL: o.URL + "/missing"}
+	if _, _, err := h.IsAcceptRangeSupported(); err == nil {
+		t.Error("no error for a file that isn't there")
+	}
+}
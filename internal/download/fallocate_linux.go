@@ -0,0 +1,16 @@
+//go:build linux
+
+package download
+
+import (
+	"os"
+	"syscall"
+)
+
+// reserves the blocks for the whole file so we don't run out of disk halfway
+func fallocate(file *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return syscall.Fallocate(int(file.Fd()), 0, 0, size)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package download
+
+import "os"
+
+// no portable way to do this, the sparse file from Truncate is good enough
+func fallocate(file *os.File, size int64) error {
+	return nil
+}
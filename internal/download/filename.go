@@ -0,0 +1,209 @@
+package download
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	DEFAULT_FILE_NAME = "download" // when nothing tells us a name
+	MAX_FILE_NAME     = 255        // bytes. the limit of most filesystems
+)
+
+// names windows doesn't allow for files, with or without an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// the last piece of the path of the url, without the query and decoded.
+// DEFAULT_FILE_NAME if there is nothing usable
+func FileNameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return DEFAULT_FILE_NAME
+	}
+	name := SanitizeFileName(path.Base(u.Path)) // Path is already decoded
+	if name == "" {
+		return DEFAULT_FILE_NAME
+	}
+	return name
+}
+
+// the name the server wants us to use: Content-Disposition first and then
+// the url we ended up at after the redirects. empty if neither has one
+func fileNameFromResponse(resp *http.Response) string {
+	if name := SanitizeFileName(fileNameFromDisposition(resp.Header.Get("Content-Disposition"))); name != "" {
+		return name
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		return SanitizeFileName(path.Base(resp.Request.URL.Path))
+	}
+	return ""
+}
+
+// filename* (RFC 5987) wins over filename. the mime package does most of it,
+// the rest is for servers that don't quote properly or use latin-1
+func fileNameFromDisposition(value string) string {
+	if value == "" {
+		return ""
+	}
+	if _, params, err := mime.ParseMediaType(value); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	var plain, extended string
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+		switch key {
+		case "filename":
+			plain = strings.Trim(val, `"`)
+		case "filename*":
+			extended = decodeExtValue(val)
+		}
+	}
+	if extended != "" {
+		return extended
+	}
+	return plain
+}
+
+// charset'language'percent-encoded. only utf-8 and latin-1 are worth knowing
+func decodeExtValue(value string) string {
+	parts := strings.SplitN(value, "'", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	raw, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(parts[0]) {
+	case "utf-8":
+		if !utf8.ValidString(raw) {
+			return ""
+		}
+		return raw
+	case "iso-8859-1":
+		// every byte is the rune with the same number
+		runes := make([]rune, len(raw))
+		for i := 0; i < len(raw); i++ {
+			runes[i] = rune(raw[i])
+		}
+		return string(runes)
+	}
+	return ""
+}
+
+// makes name safe to use as a file name on linux, mac and windows. anything
+// that looks like a directory goes away, only the last piece is kept.
+// returns "" if nothing is left
+func SanitizeFileName(name string) string {
+	// both kinds of separators, a server might send a windows path
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	// windows drops these at the end without asking
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" {
+		return ""
+	}
+	base, ext, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+	if len(name) > MAX_FILE_NAME {
+		name = truncateFileName(name, ext)
+	}
+	return name
+}
+
+// cuts the name down to MAX_FILE_NAME bytes and keeps the extension if it
+// isn't too long itself. never cuts a character in half
+func truncateFileName(name, ext string) string {
+	suffix := ""
+	if ext != "" && len(ext) < 32 {
+		suffix = path.Ext(name)
+		name = strings.TrimSuffix(name, suffix)
+	}
+	limit := MAX_FILE_NAME - len(suffix)
+	for len(name) > limit {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name + suffix
+}
+
+// renames the download to what the server called the file. only before
+// anything was written, the file and its parts would be left behind otherwise
+func (h *DownloadHandler) takeRemoteName() error {
+	if h.FixedName || h.remoteName == "" {
+		return nil
+	}
+	if h.remoteName == FileNameFromURL(h.URL) {
+		// the name we started with. the path might already have a number for it
+		return nil
+	}
+	newPath := filepath.Join(filepath.Dir(h.FilePath), h.remoteName)
+	if newPath == h.FilePath {
+		return nil
+	}
+	fmt.Printf("The server calls %s %s\n", h.URL, h.remoteName)
+	if h.ClaimPath == nil {
+		h.SetFilePath(RemotePath(newPath, h.OnCollision, nil))
+		return nil
+	}
+	// whoever claims it also moves us there, nobody else can take it in between
+	if err := h.ClaimPath(h.ctx, newPath, h.OnCollision); err != nil {
+		if h.ctx.Err() != nil {
+			return ErrPaused
+		}
+		return err
+	}
+	return nil
+}
+
+// where a download the server named p goes. parts there belong to another
+// download that is working on that name, those are never touched whatever
+// the policy says. neither is a path taken says is someone else's
+func RemotePath(p string, policy CollisionPolicy, taken func(string) bool) string {
+	if policy == RenameOnCollision || hasPartFiles(p) || (taken != nil && taken(p)) {
+		return FreePath(p, taken)
+	}
+	return p
+}
+
+// moves the download to p before it writes anything. safe to call while
+// it's running
+func (h *DownloadHandler) SetFilePath(p string) {
+	h.State.Mutex.Lock()
+	h.FilePath = p
+	h.State.Mutex.Unlock()
+}
+
+// where the file ends up. safe to call while the download is running
+func (h *DownloadHandler) GetFilePath() string {
+	h.State.Mutex.Lock()
+	defer h.State.Mutex.Unlock()
+	return h.FilePath
+}
@@ -0,0 +1,87 @@
+package download
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\me\evil.exe`, "evil.exe"},
+		{`a<b>c:d"e|f?g*h.txt`, "a_b_c_d_e_f_g_h.txt"},
+		{"line\nbreak\x00.txt", "line_break_.txt"},
+		{"trailing. . ", "trailing"},
+		{"CON", "_CON"},
+		{"nul.tar.gz", "_nul.tar.gz"},
+		{"console.log", "console.log"},
+		{"..", ""},
+		{"/", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := SanitizeFileName(tt.name); got != tt.want {
+			t.Errorf("SanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeFileNameTruncates(t *testing.T) {
+	long := strings.Repeat("é", 200) + ".iso" // 404 bytes
+	got := SanitizeFileName(long)
+	if len(got) > MAX_FILE_NAME {
+		t.Errorf("got %d bytes, want at most %d", len(got), MAX_FILE_NAME)
+	}
+	if !strings.HasSuffix(got, ".iso") {
+		t.Errorf("lost the extension: %q", got)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("cut a character in half: %q", got)
+	}
+}
+
+func TestFileNameFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/files/report.pdf", "report.pdf"},
+		{"https://example.com/get?id=42&name=x.zip", "get"},
+		{"https://example.com/my%20file%231.txt", "my file#1.txt"},
+		{"https://example.com/a%2F..%2Fb.txt", "b.txt"},
+		{"https://example.com/", DEFAULT_FILE_NAME},
+		{"https://example.com", DEFAULT_FILE_NAME},
+		{"://broken", DEFAULT_FILE_NAME},
+	}
+	for _, tt := range tests {
+		if got := FileNameFromURL(tt.url); got != tt.want {
+			t.Errorf("FileNameFromURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestFileNameFromDisposition(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`attachment; filename="setup v2.exe"`, "setup v2.exe"},
+		{`attachment; filename=plain.txt`, "plain.txt"},
+		{`attachment; filename="fallback.txt"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`, "résumé.pdf"},
+		{`attachment; filename*=iso-8859-1''caf%E9.txt`, "café.txt"},
+		// not valid for the mime package, a space before the value
+		{`attachment; filename= "spaced.txt"`, "spaced.txt"},
+		{`inline`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		if got := fileNameFromDisposition(tt.value); got != tt.want {
+			t.Errorf("fileNameFromDisposition(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -18,10 +18,34 @@ type SavedDownloadState struct {
 	PartsCount      int64 
 	IsPaused        bool
 	IncompleteParts []int64 
+	Storage         StorageMode
+	Checksum        Checksum
+	Advertised      Checksum
+	VerifiedChecksum string
+	ChunkRetry      RetryPolicy
+	ETag            string
+	LastModified    string
+	OnRemoteChange  ChangePolicy
+	OnCollision     CollisionPolicy
+	FixedName       bool
 }
 
 // Export: serializes the current state to SavedDownloadState
 func (h *DownloadHandler) Export() (*SavedDownloadState, error) {
+	if h.State == nil {
+		// a handler that was never set up. only the settings are worth saving
+		return &SavedDownloadState{
+			URL:            h.URL,
+			FilePath:       h.FilePath,
+			CHUNK_SIZE:     h.CHUNK_SIZE,
+			Storage:        h.Storage,
+			Checksum:       h.Checksum,
+			ChunkRetry:     h.ChunkRetry,
+			OnRemoteChange: h.OnRemoteChange,
+			OnCollision:    h.OnCollision,
+			FixedName:      h.FixedName,
+		}, nil
+	}
 	h.State.Mutex.Lock()
 	defer h.State.Mutex.Unlock()
 
@@ -40,6 +64,16 @@ func (h *DownloadHandler) Export() (*SavedDownloadState, error) {
 		PartsCount:      h.PartsCount,
 		IsPaused:        h.State.IsPaused,
 		IncompleteParts: incompleteParts,
+		Storage:         h.Storage,
+		Checksum:        h.Checksum,
+		Advertised:      h.Advertised,
+		VerifiedChecksum: h.VerifiedChecksum,
+		ChunkRetry:      h.ChunkRetry,
+		ETag:            h.Remote.ETag,
+		LastModified:    h.Remote.LastModified,
+		OnRemoteChange:  h.OnRemoteChange,
+		OnCollision:     h.OnCollision,
+		FixedName:       h.FixedName,
 	}
 
 	return savedState, nil
@@ -50,10 +84,18 @@ func Import(state *SavedDownloadState, client *http.Client) (*DownloadHandler, e
     if state == nil {
         return nil, fmt.Errorf("invalid state: nil")
     }
+    if state.CHUNK_SIZE <= 0 {
+        // saved before it knew the size of the file
+        state.CHUNK_SIZE = CHUNK_SIZE
+    }
 
     ctx, cancel := context.WithCancel(context.Background())
+    auth := &authState{}
+    network := &networkState{}
     handler := &DownloadHandler{
-        Client:        client,
+        Client:        withAuth(withNetwork(client, network), auth),
+        auth:          auth,
+        network:       network,
         CHUNK_SIZE:    state.CHUNK_SIZE,
         WORKERS_COUNT: 4,
         PartsCount:    state.PartsCount,
@@ -63,6 +105,19 @@ func Import(state *SavedDownloadState, client *http.Client) (*DownloadHandler, e
         ResumeChan:    make(chan struct{}),
         ctx:           ctx,
         cancel:        cancel,
+        Storage:       state.Storage,
+        Checksum:      state.Checksum,
+        Advertised:    state.Advertised,
+        VerifiedChecksum: state.VerifiedChecksum,
+        ChunkRetry:    state.ChunkRetry,
+        Remote:        RemoteValidator{
+            ETag:         state.ETag,
+            LastModified: state.LastModified,
+            Size:         state.TotalBytes,
+        },
+        OnRemoteChange: state.OnRemoteChange,
+        OnCollision:   state.OnCollision,
+        FixedName:     state.FixedName,
 
 		Progress: &ProgressTracker{
 			StartTime:      time.Now(),
@@ -98,6 +153,15 @@ func Import(state *SavedDownloadState, client *http.Client) (*DownloadHandler, e
         }
     }
 
+    if state.PartsCount == 0 {
+        // a single stream, what it got is on disk
+        currentByte = handler.streamedBytes()
+    }
+    if state.TotalBytes > 0 {
+        handler.Progress.Percent = float64(currentByte) / float64(state.TotalBytes) * 100
+    }
+    handler.Progress.LastBytes = currentByte // so the first speed isn't everything we already had
+
     handler.State = &DownloadState{
         Completed:       state.CompletedParts,
         IncompleteParts: incompleteParts,
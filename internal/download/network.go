@@ -0,0 +1,111 @@
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// how a handler connects to the server. the manager sets it from the queue
+// right before a run
+type networkState struct {
+	mu    sync.Mutex
+	proxy ProxySettings
+	tls   TLSSettings
+}
+
+func (n *networkState) settings() (ProxySettings, TLSSettings) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.proxy, n.tls
+}
+
+// one transport for every way to connect that is in use, so downloads that
+// go the same way share their connections. never cleaned up, there are only
+// as many as there are different settings
+var transports = struct {
+	sync.Mutex
+	byKey map[string]*http.Transport
+}{byKey: make(map[string]*http.Transport)}
+
+func transportFor(proxy ProxySettings, tlsOptions TLSOptions) (*http.Transport, error) {
+	bts, err := json.Marshal(struct {
+		Proxy ProxySettings
+		TLS   TLSOptions
+	}{proxy, tlsOptions})
+	if err != nil {
+		return nil, err
+	}
+	key := string(bts)
+	transports.Lock()
+	defer transports.Unlock()
+	if t, ok := transports.byKey[key]; ok {
+		return t, nil
+	}
+	if err := proxy.Validate(); err != nil {
+		return nil, err
+	}
+	cfg, err := tlsOptions.config()
+	if err != nil {
+		return nil, err
+	}
+	if tlsOptions.InsecureSkipVerify {
+		fmt.Println("WARNING: not verifying tls certificates. anyone in between can read and change these downloads")
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy.proxyFunc()
+	if cfg != nil {
+		t.TLSClientConfig = cfg
+	}
+	transports.byKey[key] = t
+	return t, nil
+}
+
+// sends every request with the transport for the settings the handler has
+// right now. the tls options are picked by host, also after a redirect
+type networkTransport struct {
+	network *networkState
+}
+
+func (t *networkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxy, tlsSettings := t.network.settings()
+	transport, err := transportFor(proxy, tlsSettings.forHost(req.URL.Hostname()))
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
+
+// a copy of client that connects the way n says. a transport the caller
+// gave the client is kept, it knows best
+func withNetwork(client *http.Client, n *networkState) *http.Client {
+	c := *client
+	if c.Transport == nil {
+		c.Transport = &networkTransport{network: n}
+	}
+	return &c
+}
+
+// how the next run connects. set by the manager right before a run
+func (h *DownloadHandler) SetProxy(p ProxySettings) {
+	if h.network == nil {
+		return // not made by NewDownloadHandler or Import
+	}
+	h.network.mu.Lock()
+	h.network.proxy = p
+	h.network.mu.Unlock()
+}
+
+// how the next run checks certificates. set by the manager right before a run
+func (h *DownloadHandler) SetTLS(t TLSSettings) {
+	if h.network == nil {
+		return
+	}
+	h.network.mu.Lock()
+	h.network.tls = t
+	h.network.mu.Unlock()
+}
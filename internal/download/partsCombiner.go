@@ -26,11 +26,8 @@ func NewPartsCombiner(contentLength int64, partsCount int, chunkSize int64) *Par
 
 func (c *PartsCombiner) CombineParts(filePath string, contentLength int64, partsCount int) error {
 	fmt.Println("Starting combine parts")
-	
-	// if it's already completed we don't need to do anything
-    if c.isFileComplete(filePath, contentLength) {
-        return nil
-    }
+	// whatever is at filePath already isn't ours. the collision policy was
+	// asked before this and said it can go
 
 	// finding file parts and making sure every part is exisiting
     partFiles, err := c.findPartFiles(filePath)
@@ -67,9 +64,19 @@ func (c *PartsCombiner) CombineParts(filePath string, contentLength int64, parts
     return nil
 }
 
-func (c *PartsCombiner) isFileComplete(filePath string, contentLength int64) bool {
-    info, err := os.Stat(filePath)
-    return err == nil && info.Size() == contentLength
+// the combine step for preallocated downloads. there is nothing to merge,
+// we only check that every part got written and the file has the right size
+func (c *PartsCombiner) VerifyInPlace(filePath string, contentLength int64, completed []bool) error {
+    fmt.Println("Verifying preallocated file")
+    if len(completed) != c.PartsCount {
+        return fmt.Errorf("expected %d parts but tracking %d", c.PartsCount, len(completed))
+    }
+    for i, done := range completed {
+        if !done {
+            return fmt.Errorf("missing part %d", i)
+        }
+    }
+    return c.verifyCombinedFile(filePath, contentLength)
 }
 
 func (c *PartsCombiner) findPartFiles(filePath string) ([]string, error) {
@@ -85,7 +92,7 @@ func (c *PartsCombiner) parsePartFiles(basePath string, partFiles []string) (map
     partsMap := make(map[int]string)
     for _, partFile := range partFiles {
         partBase := filepath.Base(partFile)
-        if !strings.HasPrefix(partBase, baseName+".part") {
+        if !strings.HasPrefix(partBase, baseName+".part") || partBase == baseName+STREAM_SUFFIX {
             continue
         }
         numStr := strings.TrimPrefix(partBase, baseName+".part")
@@ -2,13 +2,12 @@ package download
 
 import(
 	"context"
-	"sync"
 	"fmt"
 )
 
 func (h *DownloadHandler) Pause() {
 	h.State.Mutex.Lock()
-	if h.State.CurrentByte >= h.State.TotalBytes {
+	if h.State.TotalBytes >= 0 && h.State.CurrentByte >= h.State.TotalBytes { // a negative one is UNKNOWN_LENGTH
 		h.State.Mutex.Unlock()
 		fmt.Println("Ignoring pause request - download already complete")
 		return
@@ -28,73 +27,71 @@ func (h *DownloadHandler) Resume() error {
 	h.State.IsPaused = false
 	h.State.Mutex.Unlock()
 
+	h.Wait() // the workers of the paused run might still be on their way out
 	h.ctx, h.cancel = context.WithCancel(context.Background())
 	h.PauseChan = make(chan struct{})
 	close(h.ResumeChan)
 	h.ResumeChan = make(chan struct{})
 
-	return h.restartDownload()
+	if h.hasStream() {
+		return h.restartOnChange(h.resumeStream)
+	}
+	if !h.hasParts() {
+		return h.StartDownloading() // paused before it even got going
+	}
+	return h.restartOnChange(h.restartDownload)
 }
 
-func (h *DownloadHandler) restartDownload() error {
-	jobs := make(chan chunk, h.WORKERS_COUNT)
-	errChan := make(chan error, h.WORKERS_COUNT)
-	done := make(chan bool, 1)
-	pauseAck := make(chan bool, h.WORKERS_COUNT)
-
-	var wg sync.WaitGroup
-	for i := 0; i < h.WORKERS_COUNT; i++ {
-		wg.Add(1)
-		go h.worker(i, jobs, errChan, pauseAck, &wg)
+// starts again after a failure, keeping every part that was already done.
+// if it failed before we even knew how to split the file, or because the file
+// changed on the server, it just starts over
+func (h *DownloadHandler) Retry() error {
+	h.Wait()
+	if h.remoteChanged {
+		h.remoteChanged = false
+		h.discardProgress()
+		h.forgetRemote()
 	}
+	h.State.Mutex.Lock()
+	h.State.IsPaused = false
+	h.State.Mutex.Unlock()
 
-	go func() {
-		defer close(jobs)
-		h.State.Mutex.Lock()
-		incomplete := h.State.IncompleteParts
-		h.State.IncompleteParts = nil
-		h.State.Mutex.Unlock()
-
-		for _, chunk := range incomplete {
-			jobs <- chunk
-		}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	if h.hasStream() {
+		return h.restartOnChange(h.resumeStream)
+	}
+	if !h.hasParts() {
+		return h.StartDownloading()
+	}
+	return h.restartOnChange(h.restartDownload)
+}
 
-		if h.State.CurrentByte < h.State.TotalBytes {
-			h.distributeRemainingJobs(jobs)
-		}
-	}()
+// true once we know how the file is split up, so there is something to continue from
+func (h *DownloadHandler) hasParts() bool {
+	h.State.Mutex.Lock()
+	defer h.State.Mutex.Unlock()
+	return h.PartsCount > 0 && int64(len(h.State.Completed)) == h.PartsCount
+}
 
-	go func() {
-		wg.Wait()
-		h.State.Mutex.Lock()
-		if h.State.CurrentByte >= h.State.TotalBytes {
-			done <- true
-		}
-		h.State.Mutex.Unlock()
-	}()
+// blocks until the workers of the last run are all gone
+func (h *DownloadHandler) Wait() {
+	h.State.Mutex.Lock()
+	stopped := h.stopped
+	h.State.Mutex.Unlock()
+	if stopped != nil {
+		<-stopped
+	}
+}
 
-	select {
-	case <-done:
-		close(errChan)
-		for err := range errChan {
-			if err != nil {
-				return err
-			}
-		}
-		return h.combineParts(h.State.TotalBytes)
-	case err := <-errChan:
+func (h *DownloadHandler) restartDownload() error {
+	// the parts we have are only worth something if the file is still the same
+	if err := h.checkRemoteUnchanged(); err != nil {
 		return err
 	}
+	if h.Storage == Preallocated {
+		if err := h.ensurePreallocated(); err != nil {
+			return err
+		}
+	}
+	return h.runWorkers()
 }
-
-func (h *DownloadHandler) distributeRemainingJobs(jobs chan<- chunk) {
-    currentByte := h.State.CurrentByte
-    for currentByte < h.State.TotalBytes {
-        end := currentByte + h.CHUNK_SIZE
-        if end > h.State.TotalBytes {
-            end = h.State.TotalBytes
-        }
-        jobs <- chunk{Start: currentByte, End: end - 1}
-        currentByte = end
-    }
-}
\ No newline at end of file
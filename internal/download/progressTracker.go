@@ -4,6 +4,8 @@ import(
 	"sync"
 	"time"
 	"fmt"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
 )
 
 type ProgressTracker struct {
@@ -15,6 +17,21 @@ type ProgressTracker struct {
 	SpeedSamples   []float64 // we will use this to make progress tracking more smooth (like the realetion we had in physics)
     Mutex          sync.Mutex
     Percent        float64
+    Clock          clock.Clock // nil is the real one
+}
+
+// switches to another clock. the times we had are from the old one so the
+// speeds start over from now
+func (pt *ProgressTracker) SetClock(c clock.Clock) {
+    pt.Mutex.Lock()
+    defer pt.Mutex.Unlock()
+    c = clock.Or(c)
+    if clock.Or(pt.Clock) == c && !pt.StartTime.IsZero() {
+        return
+    }
+    pt.Clock = c
+    pt.StartTime = c.Now()
+    pt.LastUpdateTime = pt.StartTime
 }
 
 
@@ -24,7 +41,7 @@ func (h *DownloadHandler) updateProgress() {
     h.State.Mutex.Lock()
     defer h.State.Mutex.Unlock()
 
-    now := time.Now()
+    now := clock.Or(h.Progress.Clock).Now()
     totalElapsed := now.Sub(h.Progress.StartTime).Seconds()
     intervalElapsed := now.Sub(h.Progress.LastUpdateTime).Seconds()
 
@@ -49,7 +66,11 @@ func (h *DownloadHandler) updateProgress() {
         speedSum += speed
     }
     h.Progress.CurrentSpeed = speedSum / float64(len(h.Progress.SpeedSamples))
-    h.Progress.Percent = float64(h.State.CurrentByte) / float64(h.State.TotalBytes) * 100
+    if h.State.TotalBytes > 0 {
+        h.Progress.Percent = float64(h.State.CurrentByte) / float64(h.State.TotalBytes) * 100
+    } else {
+        h.Progress.Percent = 0 // unknown size. the bytes are all we have
+    }
 
     // Average speed (from start to now)
     if totalElapsed > 0 {
@@ -75,18 +96,34 @@ func (h *DownloadHandler) updateProgress() {
 // 		percent, currentSpeedMBps, avgSpeedMBps, h.State.CurrentByte, h.State.TotalBytes)
 // }
 
+// the getters lock because the workers update these while others read them
 func (pt *ProgressTracker) GetCurrentSpeed() string {
+	pt.Mutex.Lock()
+	defer pt.Mutex.Unlock()
 	return formatSpeed(pt.CurrentSpeed)
 }
 
 func (pt *ProgressTracker) GetOverallSpeed() string {
+	pt.Mutex.Lock()
+	defer pt.Mutex.Unlock()
 	return formatSpeed(pt.AvgSpeed)
 }
 
 func (pt  *ProgressTracker) GetProgress() float64 {
+    pt.Mutex.Lock()
+    defer pt.Mutex.Unlock()
     return pt.Percent
 }
 func formatSpeed(bytesPerSec float64) string {
+	return formatSize(bytesPerSec) + "/s"
+}
+
+// how much was downloaded, for when we don't know the size and a percentage means nothing
+func FormatBytes(n int64) string {
+	return formatSize(float64(n))
+}
+
+func formatSize(bytes float64) string {
 	const (
 		KB = 1024
 		MB = 1024 * KB
@@ -94,13 +131,13 @@ func formatSpeed(bytesPerSec float64) string {
 	)
 
 	switch {
-	case bytesPerSec >= GB:
-		return fmt.Sprintf("%.2f GB/s", bytesPerSec/float64(GB))
-	case bytesPerSec >= MB:
-		return fmt.Sprintf("%.2f MB/s", bytesPerSec/float64(MB))
-	case bytesPerSec >= KB:
-		return fmt.Sprintf("%.2f KB/s", bytesPerSec/float64(KB))
+	case bytes >= GB:
+		return fmt.Sprintf("%.2f GB", bytes/float64(GB))
+	case bytes >= MB:
+		return fmt.Sprintf("%.2f MB", bytes/float64(MB))
+	case bytes >= KB:
+		return fmt.Sprintf("%.2f KB", bytes/float64(KB))
 	default:
-		return fmt.Sprintf("%.2f B/s", bytesPerSec)
+		return fmt.Sprintf("%.2f B", bytes)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,59 @@
+package download
+
+import (
+	"testing"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
+)
+
+func TestProgressSpeed(t *testing.T) {
+	f := clock.NewFake(epoch)
+	h := &DownloadHandler{
+		State:    &DownloadState{TotalBytes: 1000},
+		Progress: &ProgressTracker{},
+	}
+	h.Progress.SetClock(f)
+
+	// 100 bytes every second
+	for i := 1; i <= 3; i++ {
+		f.Advance(time.Second)
+		h.State.CurrentByte += 100
+		h.updateProgress()
+	}
+	if h.Progress.CurrentSpeed != 100 {
+		t.Errorf("current speed %v, want 100", h.Progress.CurrentSpeed)
+	}
+	if h.Progress.AvgSpeed != 100 {
+		t.Errorf("average speed %v, want 100", h.Progress.AvgSpeed)
+	}
+	if h.Progress.Percent != 30 {
+		t.Errorf("percent %v, want 30", h.Progress.Percent)
+	}
+
+	// then 400 in a second, the current speed is smoothed over the samples
+	f.Advance(time.Second)
+	h.State.CurrentByte += 400
+	h.updateProgress()
+	if h.Progress.CurrentSpeed != 175 {
+		t.Errorf("current speed %v, want 175", h.Progress.CurrentSpeed)
+	}
+	if h.Progress.AvgSpeed != 175 {
+		t.Errorf("average speed %v, want 175", h.Progress.AvgSpeed)
+	}
+}
+
+func TestProgressSetClock(t *testing.T) {
+	f := clock.NewFake(epoch)
+	pt := &ProgressTracker{}
+	pt.SetClock(f)
+	if !pt.StartTime.Equal(epoch) {
+		t.Fatalf("start time %v, want %v", pt.StartTime, epoch)
+	}
+	// same clock again keeps the times we had
+	f.Advance(time.Minute)
+	pt.SetClock(f)
+	if !pt.StartTime.Equal(epoch) {
+		t.Errorf("start time moved to %v on the same clock", pt.StartTime)
+	}
+}
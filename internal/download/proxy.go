@@ -0,0 +1,156 @@
+package download
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// how the requests of a queue get to the server
+type ProxyMode int
+
+const (
+	SystemProxy      ProxyMode = iota // HTTP_PROXY, HTTPS_PROXY and NO_PROXY of the environment
+	DirectConnection                  // never through a proxy
+	CustomProxy                       // through the proxy at URL
+)
+
+func (m ProxyMode) String() string {
+	switch m {
+	case DirectConnection:
+		return "none"
+	case CustomProxy:
+		return "custom"
+	}
+	return "system"
+}
+
+type ProxySettings struct {
+	Mode    ProxyMode `json:",omitempty"`
+	URL     string    `json:",omitempty"` // only for CustomProxy. http, https, socks5 or socks5h, with user:password@ if it wants them
+	NoProxy []string  `json:",omitempty"` // hosts that always go direct, on top of NO_PROXY with SystemProxy
+}
+
+// "none", "system" or the url of a proxy
+func ParseProxy(s string) (ProxySettings, error) {
+	switch strings.ToLower(s) {
+	case "none", "direct":
+		return ProxySettings{Mode: DirectConnection}, nil
+	case "system", "":
+		return ProxySettings{Mode: SystemProxy}, nil
+	}
+	p := ProxySettings{Mode: CustomProxy, URL: s}
+	if _, err := parseProxyURL(s); err != nil {
+		return ProxySettings{}, err
+	}
+	return p, nil
+}
+
+// the errors never have the url in them, it can have a password
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("bad proxy url, expected scheme://[user:password@]host:port")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https, socks5 or socks5h", u.Scheme)
+}
+
+func (p ProxySettings) Validate() error {
+	switch p.Mode {
+	case SystemProxy, DirectConnection:
+		if p.URL != "" {
+			return fmt.Errorf("a proxy url only goes with a custom proxy")
+		}
+	case CustomProxy:
+		if _, err := parseProxyURL(p.URL); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown proxy mode %d", p.Mode)
+	}
+	for _, entry := range p.NoProxy {
+		if strings.TrimSpace(entry) == "" {
+			return fmt.Errorf("empty host in the no proxy list")
+		}
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return fmt.Errorf("bad network %q in the no proxy list", entry)
+			}
+		}
+	}
+	return nil
+}
+
+// for showing. the password of the proxy is never in it
+func (p ProxySettings) String() string {
+	s := p.Mode.String()
+	if p.Mode == CustomProxy {
+		if u, err := parseProxyURL(p.URL); err == nil {
+			s = u.Redacted()
+		}
+	}
+	if len(p.NoProxy) > 0 {
+		s += " (not for " + strings.Join(p.NoProxy, ", ") + ")"
+	}
+	return s
+}
+
+// a copy that can be shown or saved anywhere. the password in the url is
+// replaced by xxxxx, the real one has to be kept somewhere else
+func (p ProxySettings) Redacted() ProxySettings {
+	if u, err := parseProxyURL(p.URL); err == nil {
+		p.URL = u.Redacted()
+	}
+	return p
+}
+
+// whether host skips the proxy. an entry matches the host itself and its
+// subdomains like NO_PROXY does, a leading . or *. changes nothing. an ip
+// matches only itself, a network like 10.0.0.0/8 every ip in it and * all
+func (p ProxySettings) bypasses(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range p.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// what the transport asks for every request. p has to be valid
+func (p ProxySettings) proxyFunc() func(*http.Request) (*url.URL, error) {
+	var custom *url.URL
+	if p.Mode == CustomProxy {
+		custom, _ = parseProxyURL(p.URL)
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if p.bypasses(req.URL.Hostname()) {
+			return nil, nil
+		}
+		switch p.Mode {
+		case DirectConnection:
+			return nil, nil
+		case CustomProxy:
+			return custom, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+}
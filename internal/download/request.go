@@ -0,0 +1,207 @@
+package download
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
+)
+
+// sent when nobody set a User-Agent
+const DEFAULT_USER_AGENT = "Go-Download-Client/1.0"
+
+// extra things every request of a download sends. a queue has them for all
+// of its downloads and a download can add its own on top
+type RequestOptions struct {
+	Headers   http.Header `json:",omitempty"`
+	Cookies   []Cookie    `json:",omitempty"`
+	UserAgent string      `json:",omitempty"` // empty is DEFAULT_USER_AGENT
+}
+
+// a cookie like it is in a cookies.txt file
+type Cookie struct {
+	Domain     string // without the leading dot
+	Subdomains bool   // also sent to every subdomain of Domain
+	Path       string
+	Secure     bool      // only over https
+	Expires    time.Time // zero for a session cookie, those never expire here
+	Name       string
+	Value      string
+}
+
+// headers we set ourselves. taking them from the user would break the download
+var ownHeaders = map[string]bool{"Range": true, "If-Range": true}
+
+func (o RequestOptions) IsEmpty() bool {
+	return len(o.Headers) == 0 && len(o.Cookies) == 0 && o.UserAgent == ""
+}
+
+// o with more on top. a header of more replaces the one of o, a cookie
+// with the same name, domain and path too
+func (o RequestOptions) With(more RequestOptions) RequestOptions {
+	merged := RequestOptions{Headers: o.Headers.Clone(), UserAgent: o.UserAgent}
+	for key, values := range more.Headers {
+		if merged.Headers == nil {
+			merged.Headers = make(http.Header)
+		}
+		merged.Headers[http.CanonicalHeaderKey(key)] = values
+	}
+	if more.UserAgent != "" {
+		merged.UserAgent = more.UserAgent
+	}
+	for _, c := range o.Cookies {
+		if !more.hasCookie(c) {
+			merged.Cookies = append(merged.Cookies, c)
+		}
+	}
+	merged.Cookies = append(merged.Cookies, more.Cookies...)
+	return merged
+}
+
+func (o RequestOptions) hasCookie(c Cookie) bool {
+	for _, other := range o.Cookies {
+		if other.Name == c.Name && other.Domain == c.Domain && other.Path == c.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// puts the headers, the cookies for the url and the User-Agent on req
+func (o RequestOptions) apply(req *http.Request, now time.Time) {
+	for key, values := range o.Headers {
+		key = http.CanonicalHeaderKey(key)
+		if ownHeaders[key] {
+			continue
+		}
+		req.Header[key] = append([]string(nil), values...)
+	}
+	userAgent := o.UserAgent
+	if userAgent == "" {
+		userAgent = DEFAULT_USER_AGENT
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for _, c := range o.Cookies {
+		if c.matches(req, now) {
+			req.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+}
+
+// whether the cookie should be sent with req
+func (c Cookie) matches(req *http.Request, now time.Time) bool {
+	if !c.Expires.IsZero() && !now.Before(c.Expires) {
+		return false
+	}
+	if c.Secure && req.URL.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(req.URL.Hostname())
+	domain := strings.ToLower(c.Domain)
+	if host != domain && !(c.Subdomains && strings.HasSuffix(host, "."+domain)) {
+		return false
+	}
+	cookiePath := c.Path
+	if cookiePath == "" {
+		cookiePath = "/"
+	}
+	reqPath := req.URL.Path
+	if reqPath == "" {
+		reqPath = "/"
+	}
+	if reqPath == cookiePath {
+		return true
+	}
+	return strings.HasPrefix(reqPath, cookiePath) &&
+		(strings.HasSuffix(cookiePath, "/") || reqPath[len(cookiePath)] == '/')
+}
+
+// reads a cookies.txt in the netscape format browsers and curl export.
+// every line is domain, subdomains, path, secure, expires, name and value
+// separated by tabs
+func ParseCookieFile(r io.Reader) ([]Cookie, error) {
+	var cookies []Cookie
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// curl writes http only cookies like a comment
+		line, _ = strings.CutPrefix(line, "#HttpOnly_")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			fields = append(fields, "") // no value at all
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 fields separated by tabs, got %d", n, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad expiry %q", n, fields[4])
+		}
+		c := Cookie{
+			Domain:     strings.TrimPrefix(fields[0], "."),
+			Subdomains: strings.EqualFold(fields[1], "TRUE") || strings.HasPrefix(fields[0], "."),
+			Path:       fields[2],
+			Secure:     strings.EqualFold(fields[3], "TRUE"),
+			Name:       fields[5],
+			Value:      fields[6],
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cookies, nil
+}
+
+func LoadCookieFile(path string) ([]Cookie, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cookies, err := ParseCookieFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cookies, nil
+}
+
+// what the requests of the next run send. set by the manager right before a
+// run, the workers of the last one might still be asking for it
+func (h *DownloadHandler) SetRequestOptions(o RequestOptions) {
+	h.State.Mutex.Lock()
+	h.request = o
+	h.State.Mutex.Unlock()
+}
+
+func (h *DownloadHandler) requestOptions() RequestOptions {
+	if h.State == nil {
+		return h.request // a handler that was never set up, nothing else has it
+	}
+	h.State.Mutex.Lock()
+	defer h.State.Mutex.Unlock()
+	return h.request
+}
+
+// every request to the server is made here so they all send the same things
+func (h *DownloadHandler) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	h.requestOptions().apply(req, clock.Or(h.Clock).Now())
+	return req, nil
+}
@@ -0,0 +1,122 @@
+package download
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCookieFile(t *testing.T) {
+	file := "# Netscape HTTP Cookie File\n" +
+		"\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tsession\tabc\n" +
+		"#HttpOnly_portal.example.com\tFALSE\t/artifacts\tTRUE\t2000000000\ttoken\tx=y\r\n" +
+		"example.org\tFALSE\t/\tFALSE\t0\tempty\n"
+	cookies, err := ParseCookieFile(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Cookie{
+		{Domain: "example.com", Subdomains: true, Path: "/", Name: "session", Value: "abc"},
+		{Domain: "portal.example.com", Path: "/artifacts", Secure: true, Expires: time.Unix(2000000000, 0), Name: "token", Value: "x=y"},
+		{Domain: "example.org", Path: "/", Name: "empty"},
+	}
+	if !reflect.DeepEqual(cookies, want) {
+		t.Errorf("got %+v\nwant %+v", cookies, want)
+	}
+
+	for _, bad := range []string{"example.com\tTRUE\t/\n", "example.com\tTRUE\t/\tFALSE\tsoon\tname\tvalue\n"} {
+		if _, err := ParseCookieFile(strings.NewReader(bad)); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestCookieMatches(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		cookie Cookie
+		url    string
+		want   bool
+	}{
+		{Cookie{Domain: "example.com", Path: "/"}, "http://example.com/file", true},
+		{Cookie{Domain: "example.com", Path: "/"}, "http://cdn.example.com/file", false},
+		{Cookie{Domain: "example.com", Subdomains: true, Path: "/"}, "http://cdn.example.com/file", true},
+		{Cookie{Domain: "example.com", Subdomains: true, Path: "/"}, "http://badexample.com/file", false},
+		{Cookie{Domain: "example.com", Path: "/files"}, "http://example.com/files/a", true},
+		{Cookie{Domain: "example.com", Path: "/files"}, "http://example.com/filesystem", false},
+		{Cookie{Domain: "example.com", Path: "/", Secure: true}, "http://example.com/file", false},
+		{Cookie{Domain: "example.com", Path: "/", Secure: true}, "https://example.com/file", true},
+		{Cookie{Domain: "example.com", Path: "/", Expires: now.Add(-time.Second)}, "http://example.com/file", false},
+		{Cookie{Domain: "example.com", Path: "/", Expires: now.Add(time.Hour)}, "http://example.com:8080/file", true},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		if got := tt.cookie.matches(req, now); got != tt.want {
+			t.Errorf("%+v for %s: got %v, want %v", tt.cookie, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestRequestOptionsWith(t *testing.T) {
+	q := RequestOptions{
+		Headers:   http.Header{"Referer": {"https://queue/"}, "X-Team": {"a"}},
+		Cookies:   []Cookie{{Domain: "example.com", Path: "/", Name: "session", Value: "old"}},
+		UserAgent: "queue",
+	}
+	d := RequestOptions{
+		Headers: http.Header{"referer": {"https://download/"}},
+		Cookies: []Cookie{{Domain: "example.com", Path: "/", Name: "session", Value: "new"}},
+	}
+	got := q.With(d)
+	want := RequestOptions{
+		Headers:   http.Header{"Referer": {"https://download/"}, "X-Team": {"a"}},
+		Cookies:   []Cookie{{Domain: "example.com", Path: "/", Name: "session", Value: "new"}},
+		UserAgent: "queue",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if q.Headers.Get("Referer") != "https://queue/" {
+		t.Errorf("the queue options changed")
+	}
+}
+
+func TestApplyKeepsOwnHeaders(t *testing.T) {
+	o := RequestOptions{Headers: http.Header{"Range": {"bytes=5-"}, "X-Token": {"1"}}}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	o.apply(req, time.Now())
+	if req.Header.Get("Range") != "" {
+		t.Errorf("took Range from the options")
+	}
+	if req.Header.Get("X-Token") != "1" || req.Header.Get("User-Agent") != DEFAULT_USER_AGENT {
+		t.Errorf("got headers %v", req.Header)
+	}
+}
+
+func TestRequestOptionsSaved(t *testing.T) {
+	d := Download{
+		URL:      "http://example.com/file",
+		FilePath: "/tmp/file",
+		Request: RequestOptions{
+			Headers:   http.Header{"Referer": {"https://example.com/"}},
+			Cookies:   []Cookie{{Domain: "example.com", Path: "/", Name: "session", Value: "abc"}},
+			UserAgent: "agent",
+		},
+	}
+	CreateDefaultHandler(&d)
+	bts, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded Download
+	if err := json.Unmarshal(bts, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Request, d.Request) {
+		t.Errorf("got %+v, want %+v", loaded.Request, d.Request)
+	}
+}
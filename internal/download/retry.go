@@ -0,0 +1,135 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
+)
+
+const (
+	DEFAULT_CHUNK_RETRIES = 4
+	RETRY_BASE_DELAY      = 500 * time.Millisecond
+	RETRY_MAX_DELAY       = 30 * time.Second
+	MAX_RETRY_AFTER       = 5 * time.Minute // we don't wait longer than this even if the server asks
+)
+
+// returned by the downloading functions when they stopped because of a pause.
+// it is not a failure
+var ErrPaused = errors.New("download paused")
+
+// how many times a single chunk is tried and how long we wait in between
+type RetryPolicy struct {
+	MaxAttempts int // including the first try
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// retries is the number of extra tries after the first one. 0 or less means default
+func NewRetryPolicy(retries int64) RetryPolicy {
+	if retries <= 0 {
+		retries = DEFAULT_CHUNK_RETRIES
+	}
+	return RetryPolicy{
+		MaxAttempts: int(retries) + 1,
+		BaseDelay:   RETRY_BASE_DELAY,
+		MaxDelay:    RETRY_MAX_DELAY,
+	}
+}
+
+// error for responses with a status we didn't want
+type statusError struct {
+	Code       int
+	RetryAfter time.Duration // only set when the server sent a usable Retry-After
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned unexpected status: %d", e.Code)
+}
+
+// now is what an http date in Retry-After is counted from, it should come
+// from the same clock that does the waiting
+func newStatusError(resp *http.Response, now time.Time) *statusError {
+	e := &statusError{Code: resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	}
+	return e
+}
+
+// Retry-After is either a number of seconds or an http date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// client errors (except timeouts and rate limiting) won't get better by asking again
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrRemoteChanged) {
+		return false // asking again gets us the same new file
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		if se.Code == http.StatusRequestTimeout || se.Code == http.StatusTooManyRequests {
+			return true
+		}
+		return se.Code >= 500
+	}
+	return true
+}
+
+// exponential backoff with jitter. attempt starts from 1 for the first retry
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	var se *statusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		return min(se.RetryAfter, MAX_RETRY_AFTER)
+	}
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || d > p.MaxDelay { // <= 0 in case of overflow
+		d = p.MaxDelay
+	}
+	// somewhere between half and the full delay so workers don't all come back at once
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// downloads a chunk and retries it according to the handlers retry policy.
+// only when it keeps failing the error makes it up to the whole download
+func (h *DownloadHandler) downloadChunkWithRetry(ctx context.Context, c chunk) error {
+	policy := h.ChunkRetry
+	if policy.MaxAttempts <= 0 {
+		policy = NewRetryPolicy(0)
+	}
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		err = h.downloadWithRanges(ctx, c.Start, c.End)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if !isRetryable(err) || attempt == policy.MaxAttempts {
+			break
+		}
+		wait := policy.delay(attempt, err)
+		fmt.Printf("Chunk %d-%d failed (attempt %d/%d): %v. retrying in %v\n",
+			c.Start, c.End, attempt, policy.MaxAttempts, err, wait)
+		if clock.Sleep(ctx, h.Clock, wait) != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
+)
+
+// a server that says it's busy for the first failures requests and then
+// sends the range like it should
+func flakyServer(t *testing.T, failures int32, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	content := make([]byte, 1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "file", epoch, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func testHandler(t *testing.T, url string, f *clock.Fake) *DownloadHandler {
+	t.Helper()
+	h := &DownloadHandler{
+		Client:     http.DefaultClient,
+		URL:        url,
+		FilePath:   filepath.Join(t.TempDir(), "file"),
+		CHUNK_SIZE: 1024,
+		State:      &DownloadState{TotalBytes: 1024},
+		Progress:   &ProgressTracker{},
+		Clock:      f,
+	}
+	h.Progress.SetClock(f)
+	return h
+}
+
+func TestRetryWaitsForRetryAfter(t *testing.T) {
+	srv, calls := flakyServer(t, 2, "7")
+	f := clock.NewFake(epoch)
+	h := testHandler(t, srv.URL, f)
+	h.ChunkRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	done := make(chan error)
+	go func() { done <- h.downloadChunkWithRetry(context.Background(), chunk{0, 1023}) }()
+	for i := 0; i < 2; i++ {
+		f.BlockUntil(1)
+		// not a moment before the server said
+		f.Advance(7*time.Second - time.Nanosecond)
+		select {
+		case err := <-done:
+			t.Fatalf("retried before Retry-After was over: %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		f.Advance(time.Nanosecond)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("server got %d requests, want 3", n)
+	}
+	if info, err := os.Stat(h.FilePath + ".part0"); err != nil || info.Size() != 1024 {
+		t.Errorf("part file not written: %v", err)
+	}
+}
+
+// the date is counted from the fake clock, not the one on the wall
+func TestRetryWaitsForRetryAfterDate(t *testing.T) {
+	srv, calls := flakyServer(t, 1, epoch.Add(30*time.Second).Format(http.TimeFormat))
+	f := clock.NewFake(epoch)
+	h := testHandler(t, srv.URL, f)
+	h.ChunkRetry = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	done := make(chan error)
+	go func() { done <- h.downloadChunkWithRetry(context.Background(), chunk{0, 1023}) }()
+	f.BlockUntil(1)
+	f.Advance(30*time.Second - time.Nanosecond)
+	select {
+	case err := <-done:
+		t.Fatalf("retried before the date in Retry-After: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	f.Advance(time.Nanosecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("server got %d requests, want 2", n)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	srv, calls := flakyServer(t, 100, "")
+	f := clock.NewFake(epoch)
+	h := testHandler(t, srv.URL, f)
+	h.ChunkRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	done := make(chan error)
+	go func() { done <- h.downloadChunkWithRetry(context.Background(), chunk{0, 1023}) }()
+	// backoff with jitter, the second wait is at most 2s
+	for i := 0; i < 2; i++ {
+		f.BlockUntil(1)
+		f.Advance(2 * time.Second)
+	}
+	err := <-done
+	if se, ok := err.(*statusError); !ok || se.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want the 503", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("server got %d requests, want 3", n)
+	}
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	srv, _ := flakyServer(t, 100, "60")
+	f := clock.NewFake(epoch)
+	h := testHandler(t, srv.URL, f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- h.downloadChunkWithRetry(ctx, chunk{0, 1023}) }()
+	f.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"soon", 0},
+		{epoch.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{epoch.Add(-time.Hour).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, epoch); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
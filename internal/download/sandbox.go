@@ -0,0 +1,79 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrOutsideDir = errors.New("file path is outside of the download directory")
+
+// makes sure p ends up inside dir, also after following every symlink on
+// the way. dir can be a symlink itself, only where it really is counts
+func CheckInDir(dir, p string) error {
+	outside := fmt.Errorf("%w: %s is not inside %s", ErrOutsideDir, p, dir)
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return outside
+	}
+	absPath, err := filepath.Abs(p)
+	if err != nil || !isInside(absDir, absPath) {
+		return outside
+	}
+	realDir, err := filepath.EvalSymlinks(absDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", dir, err)
+	}
+	realPath, err := resolveExisting(absPath)
+	if err != nil || !isInside(realDir, realPath) {
+		return outside
+	}
+	return nil
+}
+
+// p with every symlink resolved. the end of it doesn't have to exist yet
+// but a symlink pointing nowhere is an error, we can't know where it would go
+func resolveExisting(p string) (string, error) {
+	real, err := filepath.EvalSymlinks(p)
+	if err == nil {
+		return real, nil
+	}
+	if _, lerr := os.Lstat(p); lerr == nil || !os.IsNotExist(err) {
+		return "", err
+	}
+	parent, err := resolveExisting(filepath.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(p)), nil
+}
+
+// whether p is somewhere under dir. both have to be absolute
+func isInside(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil || rel == "." || rel == ".." || filepath.IsAbs(rel) {
+		return false
+	}
+	return !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// the directory the file has to stay in, whatever the server calls it
+func (h *DownloadHandler) SetRoot(dir string) {
+	h.State.Mutex.Lock()
+	h.root = dir
+	h.State.Mutex.Unlock()
+}
+
+// the file has to stay in its root. the name can come from the server and
+// anything there can be a symlink by now
+func (h *DownloadHandler) checkPath() error {
+	h.State.Mutex.Lock()
+	root, p := h.root, h.FilePath
+	h.State.Mutex.Unlock()
+	if root == "" {
+		root = filepath.Dir(p) // nobody told us, at least not out of where it is
+	}
+	return CheckInDir(root, p)
+}
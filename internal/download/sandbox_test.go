@@ -0,0 +1,88 @@
+package download
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckInDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "downloads")
+	outside := filepath.Join(root, "outside")
+	os.Mkdir(dir, 0755)
+	os.Mkdir(outside, 0755)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(outside, "secret"), nil, 0644)
+	os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "out.bin"))
+	os.Symlink(filepath.Join(dir, "sub"), filepath.Join(dir, "in"))
+	os.Symlink(outside, filepath.Join(dir, "escape"))
+	os.Symlink(filepath.Join(outside, "nothing"), filepath.Join(dir, "dangling.bin"))
+	os.Symlink(dir, filepath.Join(root, "linked"))
+
+	tests := []struct {
+		name string
+		dir  string
+		path string
+		ok   bool
+	}{
+		{"plain", dir, filepath.Join(dir, "file.bin"), true},
+		{"subdirectory", dir, filepath.Join(dir, "sub", "file.bin"), true},
+		{"dot dot", dir, filepath.Join(dir, "..", "outside", "file.bin"), false},
+		{"dot dot inside", dir, dir + "/sub/../file.bin", true},
+		{"absolute", dir, "/etc/passwd", false},
+		{"the directory", dir, dir, false},
+		{"prefix only", dir, dir + "-other/file.bin", false},
+		{"symlink out", dir, filepath.Join(dir, "out.bin"), false},
+		{"symlink in", dir, filepath.Join(dir, "in", "file.bin"), true},
+		{"through a symlink", dir, filepath.Join(dir, "escape", "file.bin"), false},
+		{"dangling symlink", dir, filepath.Join(dir, "dangling.bin"), false},
+		{"linked directory", filepath.Join(root, "linked"), filepath.Join(root, "linked", "file.bin"), true},
+	}
+	for _, tt := range tests {
+		err := CheckInDir(tt.dir, tt.path)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrOutsideDir) {
+			t.Errorf("%s: got %v, want ErrOutsideDir", tt.name, err)
+		}
+	}
+}
+
+// once the manager set the root, a path somewhere else fails, also one that
+// only gets out through a symlinked directory in between
+func TestCheckPathRoot(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "downloads")
+	outside := filepath.Join(root, "outside")
+	os.Mkdir(dir, 0755)
+	os.Mkdir(outside, 0755)
+	os.Symlink(outside, filepath.Join(dir, "escape"))
+
+	h := &DownloadHandler{State: &DownloadState{}}
+	h.SetRoot(dir)
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{filepath.Join(dir, "file.bin"), true},
+		{filepath.Join(outside, "file.bin"), false},
+		{filepath.Join(dir, "escape", "file.bin"), false},
+	}
+	for _, tt := range tests {
+		h.FilePath = tt.path
+		err := h.checkPath()
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrOutsideDir) {
+			t.Errorf("%s: got %v, want ErrOutsideDir", tt.path, err)
+		}
+		// and again right before the data is put there
+		if _, err := h.resolveCollision(nil); !tt.ok && !errors.Is(err, ErrOutsideDir) {
+			t.Errorf("%s: resolveCollision got %v, want ErrOutsideDir", tt.path, err)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package download
 
+import "strings"
+
 type State int
 
 const (
@@ -11,3 +13,30 @@ const (
 	Retrying
 	Done
 )
+
+var stateNames = []string{
+	"Pending",
+	"Downloading",
+	"Paused",
+	"Cancelled",
+	"Failed",
+	"Retrying",
+	"Done",
+}
+
+func (s State) String() string {
+	if 0 <= s && int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "Unknown"
+}
+
+// the opposite of String. case doesn't matter
+func ParseState(name string) (State, bool) {
+	for i, n := range stateNames {
+		if strings.EqualFold(n, name) {
+			return State(i), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,78 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// StorageMode decides where the workers put the bytes they download
+type StorageMode int
+
+const (
+	// every chunk goes to its own <file>.partN and they get combined at the end
+	PartFiles StorageMode = iota
+	// the target file is allocated up front and every worker writes its range
+	// in place with WriteAt. no combining needed, so half the disk usage and I/O
+	Preallocated
+)
+
+func (s StorageMode) String() string {
+	if s == Preallocated {
+		return "Preallocated"
+	}
+	return "Part Files"
+}
+
+// creates the target file (or reuses it) with the final size.
+// we truncate first so the file is at least sparse and then ask the os to
+// actually reserve the blocks if it knows how to
+func (h *DownloadHandler) preallocate(size int64) error {
+	file, err := os.OpenFile(h.FilePath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %v", h.FilePath, err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to resize file %s: %v", h.FilePath, err)
+	}
+	if err := fallocate(file, size); err != nil {
+		// not fatal. the sparse file works too, we just dont get the blocks reserved
+		fmt.Printf("Warning: couldn't reserve space for %s: %v\n", h.FilePath, err)
+	}
+	return nil
+}
+
+// used when resuming. if the preallocated file went missing or got resized
+// behind our back we can't trust the completed parts anymore so we start over
+func (h *DownloadHandler) ensurePreallocated() error {
+	info, err := os.Stat(h.FilePath)
+	if err == nil && info.Size() == h.State.TotalBytes {
+		return nil
+	}
+	fmt.Printf("Preallocated file %s is missing or has the wrong size, starting over\n", h.FilePath)
+	h.State.Mutex.Lock()
+	h.State.Completed = make([]bool, h.PartsCount)
+	h.State.IncompleteParts = nil
+	h.State.CurrentByte = 0
+	h.State.Mutex.Unlock()
+	return h.preallocate(h.State.TotalBytes)
+}
+
+// opens the place a chunk starting at `start` should be written to.
+// the returned file is the one that has to be synced and closed
+func (h *DownloadHandler) openChunkWriter(start int64, partFileName string) (*os.File, io.Writer, error) {
+	if h.Storage == Preallocated {
+		file, err := os.OpenFile(h.FilePath, os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open file %s: %v", h.FilePath, err)
+		}
+		return file, io.NewOffsetWriter(file, start), nil
+	}
+	file, err := os.OpenFile(partFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create part file %s: %v", partFileName, err)
+	}
+	return file, file, nil
+}
@@ -0,0 +1,167 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
+)
+
+// a download without ranges comes into this file and is renamed to the
+// real one when it's done. it starts with .part so everything that cleans
+// up or moves the part files takes it along
+const STREAM_SUFFIX = ".partial"
+
+func (h *DownloadHandler) streamPath() string {
+	return h.FilePath + STREAM_SUFFIX
+}
+
+// how much of a single stream download is already on disk
+func (h *DownloadHandler) streamedBytes() int64 {
+	info, err := os.Stat(h.streamPath())
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// true if the last run was a single stream that got somewhere
+func (h *DownloadHandler) hasStream() bool {
+	return h.PartsCount == 0 && h.streamedBytes() > 0
+}
+
+// the whole file in one stream. contentLength can be UNKNOWN_LENGTH, then we
+// just read until the server stops and only the bytes are known as progress
+func (h *DownloadHandler) downloadWithoutRanges(contentLength int64) error {
+	return h.downloadStream(0, contentLength)
+}
+
+// goes on with a single stream download after a pause or failure. if the
+// server does ranges by now we only ask for what is missing, otherwise
+// it starts over
+func (h *DownloadHandler) resumeStream() error {
+	old := h.Remote
+	supportsRange, contentLength, err := h.IsAcceptRangeSupported()
+	if err != nil {
+		return err
+	}
+	if h.Remote.changedFrom(old) {
+		return ErrRemoteChanged
+	}
+	have := h.streamedBytes()
+	if supportsRange && contentLength > 0 && have < contentLength {
+		fmt.Printf("Continuing %s from byte %d\n", h.FilePath, have)
+		return h.downloadStream(have, contentLength)
+	}
+	// nothing to go on from. forget it so it can't get mixed up with parts
+	if err := os.Remove(h.streamPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", h.streamPath(), err)
+	}
+	return h.startDownloading()
+}
+
+// downloads from offset to the end into the stream file. with an offset
+// the server has to send a range of the same file we already have the
+// beginning of
+func (h *DownloadHandler) downloadStream(offset int64, contentLength int64) error {
+	ctx := h.ctx // Resume replaces h.ctx so we hold on to the one of this run
+	stopped := make(chan struct{})
+	h.State.Mutex.Lock()
+	h.stopped = stopped
+	h.PartsCount = 0
+	h.State.Completed = nil
+	h.State.IncompleteParts = nil
+	h.State.CurrentByte = offset
+	h.State.TotalBytes = contentLength
+	h.State.Mutex.Unlock()
+	defer close(stopped)
+
+	h.Progress.Mutex.Lock()
+	h.Progress.LastBytes = offset // so the first speed isn't everything we already had
+	h.Progress.Mutex.Unlock()
+
+	req, err := h.newRequest(ctx, "GET")
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	ifRange := ""
+	if offset > 0 {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-", offset))
+		if ifRange = h.Remote.ifRange(); ifRange != "" {
+			req.Header.Add("If-Range", ifRange)
+		}
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ErrPaused
+		}
+		return fmt.Errorf("failed to execute request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return newStatusError(resp, clock.Or(h.Clock).Now())
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		if ifRange != "" {
+			return ErrRemoteChanged
+		}
+		// it sent everything after all. fine, we take it from the start
+		offset = 0
+		h.State.Mutex.Lock()
+		h.State.CurrentByte = 0
+		h.State.Mutex.Unlock()
+	}
+
+	file, err := os.OpenFile(h.streamPath(), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer file.Close()
+	// whatever is behind offset is from an older try
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate %s: %v", file.Name(), err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in %s: %v", file.Name(), err)
+	}
+
+	var totalRead int64
+	counting := &countingReader{reader: resp.Body, count: &totalRead, handler: h}
+	_, err = io.Copy(file, NewLimitedReader(ctx, counting, h.Bandwidth))
+	if err != nil {
+		if ctx.Err() != nil {
+			// what we have stays on disk for the next run
+			file.Sync()
+			fmt.Printf("Paused %s at byte %d\n", h.FilePath, offset+totalRead)
+			return ErrPaused
+		}
+		return fmt.Errorf("failed to download file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %v", err)
+	}
+	if contentLength >= 0 && offset+totalRead != contentLength {
+		return fmt.Errorf("server sent %d bytes, want %d", offset+totalRead, contentLength)
+	}
+	skip, err := h.resolveCollision([]string{h.streamPath()})
+	if err != nil {
+		return err
+	}
+	if skip {
+		removeAll([]string{h.streamPath()})
+	} else if err := os.Rename(h.streamPath(), h.FilePath); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", h.streamPath(), err)
+	}
+	// now we know how big it was
+	h.State.Mutex.Lock()
+	h.State.TotalBytes = offset + totalRead
+	h.State.Mutex.Unlock()
+	h.updateProgress()
+
+	return h.verifyChecksum()
+}
@@ -0,0 +1,202 @@
+package download
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+var ErrPinMismatch = errors.New("the public key of the server doesn't match any pin")
+
+// how the certificates of a server are checked and what we show it
+type TLSOptions struct {
+	CAFiles    []string `json:",omitempty"` // pem files with roots we trust on top of the system ones
+	ClientCert string   `json:",omitempty"` // pem certificate for servers that want one (mtls)
+	ClientKey  string   `json:",omitempty"` // its key. empty if it's in ClientCert too
+	Pins       []string `json:",omitempty"` // sha256//<base64> of a public key in the verified chain. one of them has to be there
+	// DANGEROUS: takes any certificate, anyone in between can read and change
+	// the downloads. pins are then only checked against the key of the
+	// server's own certificate, there is no chain to find a ca in
+	InsecureSkipVerify bool `json:",omitempty"`
+}
+
+// the tls settings of a queue. a host that matches one of Hosts uses only
+// the options of the first one that does, every other host the ones on top
+type TLSSettings struct {
+	TLSOptions
+	Hosts []HostTLS `json:",omitempty"`
+}
+
+type HostTLS struct {
+	Pattern string // like *.corp.example.com
+	TLSOptions
+}
+
+func (o TLSOptions) IsEmpty() bool {
+	return len(o.CAFiles) == 0 && o.ClientCert == "" && o.ClientKey == "" && len(o.Pins) == 0 && !o.InsecureSkipVerify
+}
+
+func (s TLSSettings) forHost(host string) TLSOptions {
+	for _, h := range s.Hosts {
+		if hostMatches(h.Pattern, host) {
+			return h.TLSOptions
+		}
+	}
+	return s.TLSOptions
+}
+
+// the pin of a certificate, the way Pins has them
+func PinOf(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256//" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func parsePin(pin string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(pin, "sha256//")
+	if !ok {
+		return nil, fmt.Errorf("bad pin %q, expected sha256//<base64>", pin)
+	}
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("bad pin %q, expected the base64 of a sha256", pin)
+	}
+	return sum, nil
+}
+
+// the files are read here, so a change to them counts once the manager
+// starts again. nil means the defaults are fine
+func (o TLSOptions) config() (*tls.Config, error) {
+	if o.IsEmpty() {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if len(o.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range o.CAFiles {
+			bts, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the ca file: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(bts) {
+				return nil, fmt.Errorf("no certificates in %s", file)
+			}
+		}
+		cfg.RootCAs = pool
+	}
+	if o.ClientKey != "" && o.ClientCert == "" {
+		return nil, fmt.Errorf("a client key needs a client certificate")
+	}
+	if o.ClientCert != "" {
+		key := o.ClientKey
+		if key == "" {
+			key = o.ClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if len(o.Pins) > 0 {
+		pins := make(map[[sha256.Size]byte]bool)
+		for _, pin := range o.Pins {
+			sum, err := parsePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins[[sha256.Size]byte(sum)] = true
+		}
+		// runs after the chain was verified, or instead of it when insecure.
+		// only the verified chains count, the server can send any extra
+		// certificate it likes along with them
+		insecure := o.InsecureSkipVerify
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			chains := cs.VerifiedChains
+			if insecure {
+				// nothing was verified. the only key that means anything is the
+				// one that signed the handshake, a copy of a pinned ca proves nothing
+				if len(cs.PeerCertificates) == 0 {
+					return ErrPinMismatch
+				}
+				chains = [][]*x509.Certificate{cs.PeerCertificates[:1]}
+			}
+			for _, chain := range chains {
+				for _, cert := range chain {
+					if pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+						return nil
+					}
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+	return cfg, nil
+}
+
+// also loads everything so a missing file is found when the queue is saved
+// and not when its first download starts
+func (s TLSSettings) Validate() error {
+	if _, err := s.config(); err != nil {
+		return err
+	}
+	for _, h := range s.Hosts {
+		if _, err := path.Match(h.Pattern, ""); err != nil || h.Pattern == "" {
+			return fmt.Errorf("bad host pattern %q", h.Pattern)
+		}
+		if _, err := h.config(); err != nil {
+			return fmt.Errorf("%s: %v", h.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// short, for the table. insecure is never left out
+func (o TLSOptions) String() string {
+	parts := make([]string, 0)
+	if o.InsecureSkipVerify {
+		parts = append(parts, "INSECURE")
+	}
+	if len(o.CAFiles) > 0 {
+		parts = append(parts, fmt.Sprintf("%d ca", len(o.CAFiles)))
+	}
+	if o.ClientCert != "" {
+		parts = append(parts, "client cert")
+	}
+	if len(o.Pins) > 0 {
+		parts = append(parts, fmt.Sprintf("%d pins", len(o.Pins)))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s TLSSettings) String() string {
+	str := s.TLSOptions.String()
+	for _, h := range s.Hosts {
+		str += "; " + h.Pattern + ": " + h.TLSOptions.String()
+	}
+	return str
+}
+
+// true if any host of s skips the verification
+func (s TLSSettings) Insecure() bool {
+	if s.InsecureSkipVerify {
+		return true
+	}
+	for _, h := range s.Hosts {
+		if h.InsecureSkipVerify {
+			return true
+		}
+	}
+	return false
+}
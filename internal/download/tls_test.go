@@ -0,0 +1,165 @@
+package download
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePin(t *testing.T) {
+	good := "sha256//" + strings.Repeat("A", 43) + "="
+	if _, err := parsePin(good); err != nil {
+		t.Errorf("%q: %v", good, err)
+	}
+	for _, bad := range []string{"", strings.Repeat("A", 43) + "=", "sha1//AAAA", "sha256//AAAA", "sha256//not base64!"} {
+		if _, err := parsePin(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestTLSForHost(t *testing.T) {
+	s := TLSSettings{
+		TLSOptions: TLSOptions{CAFiles: []string{"all.pem"}},
+		Hosts: []HostTLS{
+			{Pattern: "*.corp.example.com", TLSOptions: TLSOptions{CAFiles: []string{"corp.pem"}}},
+			{Pattern: "legacy.corp.example.com", TLSOptions: TLSOptions{InsecureSkipVerify: true}},
+		},
+	}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "all.pem"},
+		{"corp.example.com", "corp.pem"},
+		{"files.corp.example.com", "corp.pem"},
+		{"legacy.corp.example.com", "corp.pem"}, // the first match wins
+	}
+	for _, tt := range tests {
+		if got := s.forHost(tt.host).CAFiles; len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.host, got, tt.want)
+		}
+	}
+	if !s.Insecure() {
+		t.Errorf("an insecure host didn't make it insecure")
+	}
+	if got := s.String(); !strings.Contains(got, "legacy.corp.example.com: INSECURE") {
+		t.Errorf("the insecure host isn't loud enough: %q", got)
+	}
+}
+
+func TestTLSValidate(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not.pem")
+	os.WriteFile(notPEM, []byte("hello"), 0600)
+	bad := []TLSSettings{
+		{TLSOptions: TLSOptions{CAFiles: []string{filepath.Join(dir, "missing.pem")}}},
+		{TLSOptions: TLSOptions{CAFiles: []string{notPEM}}},
+		{TLSOptions: TLSOptions{ClientKey: notPEM}},
+		{TLSOptions: TLSOptions{ClientCert: notPEM}},
+		{TLSOptions: TLSOptions{Pins: []string{"sha256//short"}}},
+		{Hosts: []HostTLS{{Pattern: "[", TLSOptions: TLSOptions{InsecureSkipVerify: true}}}},
+		{Hosts: []HostTLS{{Pattern: "example.com", TLSOptions: TLSOptions{CAFiles: []string{notPEM}}}}},
+	}
+	for _, s := range bad {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v: no error", s)
+		}
+	}
+	if err := (TLSSettings{}).Validate(); err != nil {
+		t.Errorf("the zero value: %v", err)
+	}
+}
+
+// a certificate signed by parent, or self signed if parent is nil
+func newTestCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	} else {
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// the server sends a valid chain that has nothing to do with the pin and
+// the pinned certificate as an extra. only the chain we verified counts
+func TestPinsOnlyVerifiedChain(t *testing.T) {
+	dir := t.TempDir()
+	root, rootKey := newTestCert(t, "trusted root", nil, nil)
+	leaf, leafKey := newTestCert(t, "leaf", root, rootKey)
+	pinned, _ := newTestCert(t, "pinned", nil, nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0600)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.Raw, pinned.Raw},
+		PrivateKey:  leafKey,
+	}}}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(o TLSOptions) error {
+		cfg, err := o.config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(TLSOptions{CAFiles: []string{caFile}, Pins: []string{PinOf(pinned)}}); !errors.Is(err, ErrPinMismatch) {
+		t.Errorf("the extra certificate passed the pin: %v", err)
+	}
+	if err := get(TLSOptions{CAFiles: []string{caFile}, Pins: []string{PinOf(root)}}); err != nil {
+		t.Errorf("the root of the verified chain didn't: %v", err)
+	}
+	// without verification anyone can send a copy of the pinned certificate,
+	// only the key of the leaf counts
+	if err := get(TLSOptions{InsecureSkipVerify: true, Pins: []string{PinOf(pinned)}}); !errors.Is(err, ErrPinMismatch) {
+		t.Errorf("insecure with a sent certificate passed the pin: %v", err)
+	}
+	if err := get(TLSOptions{InsecureSkipVerify: true, Pins: []string{PinOf(leaf)}}); err != nil {
+		t.Errorf("insecure with the pin of the leaf: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var ErrRemoteChanged = errors.New("remote file changed since the download started")
+
+// what to do when the file on the server isn't the one we started downloading
+type ChangePolicy int
+
+const (
+	RestartOnChange ChangePolicy = iota // throw away what we have and download the new one
+	FailOnChange                        // stop and let the user decide
+)
+
+func (p ChangePolicy) String() string {
+	if p == FailOnChange {
+		return "Fail"
+	}
+	return "Restart"
+}
+
+// the things a server tells us that identify a version of a file
+type RemoteValidator struct {
+	ETag         string
+	LastModified string
+	Size         int64
+}
+
+func validatorFromResponse(resp *http.Response) RemoteValidator {
+	return RemoteValidator{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Size:         resp.ContentLength,
+	}
+}
+
+// true if the two describe different versions of the file.
+// we can only compare what both of them know
+func (v RemoteValidator) changedFrom(old RemoteValidator) bool {
+	if v.ETag != "" && old.ETag != "" && v.ETag != old.ETag {
+		return true
+	}
+	if v.LastModified != "" && old.LastModified != "" && v.LastModified != old.LastModified {
+		return true
+	}
+	return v.Size > 0 && old.Size > 0 && v.Size != old.Size
+}
+
+// the value for the If-Range header. weak etags are not allowed there
+// so we fall back to the modification date. empty means don't send it
+func (v RemoteValidator) ifRange() string {
+	if v.ETag != "" && !strings.HasPrefix(v.ETag, "W/") {
+		return v.ETag
+	}
+	return v.LastModified
+}
+
+// asks the server again before going on with the parts we have and
+// errors with ErrRemoteChanged if it isn't the same file anymore
+func (h *DownloadHandler) checkRemoteUnchanged() error {
+	old := h.Remote
+	supportsRange, _, err := h.IsAcceptRangeSupported()
+	if err != nil {
+		return err
+	}
+	if h.Remote.changedFrom(old) {
+		return ErrRemoteChanged
+	}
+	if !supportsRange {
+		return fmt.Errorf("%w: server doesn't accept ranges anymore", ErrRemoteChanged)
+	}
+	return nil
+}
+
+// forgets every part we have and removes them from the disk
+func (h *DownloadHandler) discardProgress() {
+	if h.Storage == Preallocated {
+		if err := os.Remove(h.FilePath); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Warning: failed to remove %s: %v\n", h.FilePath, err)
+		}
+	} else {
+		for _, file := range PartFilesOf(h.FilePath) {
+			if err := os.Remove(file); err != nil {
+				fmt.Printf("Warning: failed to remove part file %s: %v\n", file, err)
+			}
+		}
+	}
+	h.State.Mutex.Lock()
+	h.State.Completed = nil
+	h.State.IncompleteParts = nil
+	h.State.CurrentByte = 0
+	h.State.Mutex.Unlock()
+	h.PartsCount = 0
+}
+
+// runs a download function and if it finds out the remote file changed
+// either starts over (once) or gives up, depending on the policy
+func (h *DownloadHandler) restartOnChange(run func() error) error {
+	err := run()
+	if !errors.Is(err, ErrRemoteChanged) {
+		return err
+	}
+	if h.OnRemoteChange == FailOnChange {
+		h.remoteChanged = true // so a retry knows it has to start over
+		return err
+	}
+	fmt.Printf("%s: %v. starting over\n", h.URL, err)
+	h.discardProgress()
+	h.forgetRemote()
+	return h.startDownloading()
+}
+
+// forgets everything we learned from the old version of the file, its digest
+// too. the new one gets checked against what it says about itself
+func (h *DownloadHandler) forgetRemote() {
+	h.Remote = RemoteValidator{}
+	h.Advertised = Checksum{}
+}
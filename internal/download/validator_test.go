@@ -0,0 +1,25 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// starting over only throws away our own parts, even with brackets in the name
+func TestDiscardProgressBrackets(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a[1].bin.part0", "a[1].bin.part1", "a1.bin.part0"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	h := &DownloadHandler{FilePath: filepath.Join(dir, "a[1].bin"), State: &DownloadState{}}
+	h.discardProgress()
+	for _, name := range []string{"a[1].bin.part0", "a[1].bin.part1"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s is still there: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a1.bin.part0")); err != nil {
+		t.Errorf("the part of another download was removed: %v", err)
+	}
+}
@@ -1,17 +1,18 @@
 package download
 
 import (
+	"context"
 	"fmt"
     "sync"
 )
 
-func (h *DownloadHandler) worker(id int, jobs <-chan chunk, errChan chan<- error, pauseAck chan<- bool, wg *sync.WaitGroup) {
+func (h *DownloadHandler) worker(ctx context.Context, id int, jobs <-chan chunk, errChan chan<- error, pauseAck chan<- bool, wg *sync.WaitGroup) {
     defer wg.Done()
 
     // we will iterae on jobs/chunks on channel
     for chunk := range jobs {
         select {
-			case <-h.ctx.Done(): // Handle cancellation/pause
+			case <-ctx.Done(): // Handle cancellation/pause
 				h.State.Mutex.Lock()
             	h.State.IncompleteParts = append(h.State.IncompleteParts, chunk)
             	h.State.Mutex.Unlock()
@@ -38,8 +39,7 @@ func (h *DownloadHandler) worker(id int, jobs <-chan chunk, errChan chan<- error
 			}
 			h.State.Mutex.Unlock()
 
-            if err := h.downloadWithRanges(chunk.Start, chunk.End); err != nil {
-                fmt.Printf("Worker %d: Failed chunk %d-%d: %v\n", id, chunk.Start, chunk.End, err)
+            if err := h.downloadChunkWithRetry(ctx, chunk); err != nil {
                 h.State.Mutex.Lock()
                 h.State.IncompleteParts = append(h.State.IncompleteParts, chunk) // Requeue failed chunk
                 // Ensure the part is not marked as completed
@@ -47,16 +47,21 @@ func (h *DownloadHandler) worker(id int, jobs <-chan chunk, errChan chan<- error
                     h.State.Completed[partIndex] = false
                 }
                 h.State.Mutex.Unlock()
-                errChan <- fmt.Errorf("worker %d failed: %v", id, err)
+                if ctx.Err() != nil {
+                    // we got interrupted in the middle of the chunk. not a failure
+                    fmt.Printf("Worker %d paused at chunk %d-%d\n", id, chunk.Start, chunk.End)
+                    pauseAck <- true
+                    return
+                }
+                fmt.Printf("Worker %d: Failed chunk %d-%d: %v\n", id, chunk.Start, chunk.End, err)
+                errChan <- fmt.Errorf("worker %d failed: %w", id, err)
                 return // exit on error
             }
 
             fmt.Printf("Worker %d: Successfully downloaded chunk %d-%d\n", id, chunk.Start, chunk.End)
             h.State.Mutex.Lock()
-            if int(partIndex) < len(h.State.Completed) && !h.State.Completed[partIndex] { // Only increment if not completed
+            if int(partIndex) < len(h.State.Completed) {
                 h.State.Completed[partIndex] = true
-                chunkSize := int64(chunk.End - chunk.Start + 1)
-                h.State.CurrentByte += chunkSize
             }
             h.State.Mutex.Unlock()
         }
@@ -64,29 +69,113 @@ func (h *DownloadHandler) worker(id int, jobs <-chan chunk, errChan chan<- error
 	fmt.Printf("Worker %d: Finished task\n", id)
 }
 
-func (h *DownloadHandler) distributeJobs(jobs chan<- chunk, contentLength int) {
-    currentByte := h.State.CurrentByte
-    for currentByte < int64(contentLength) {
-        select {
-        case <-h.ctx.Done():
-            return // Exit on pause without closing jobs
-		default:
-        	// we will add up current byte with chunk_size to get the end
-        	end := currentByte + int64(h.CHUNK_SIZE)
-        	if end > int64(contentLength) {
-            	end = int64(contentLength)
-        	}
-
-        	// defining the chunk
-        	chunk := chunk{Start: currentByte, End: end - 1}
+// sends every part that isn't completed yet. parts finish out of order
+// so we can't just continue from CurrentByte
+func (h *DownloadHandler) distributeJobs(ctx context.Context, jobs chan<- chunk) {
+	defer close(jobs)
+	for i := int64(0); i < h.PartsCount; i++ {
+		h.State.Mutex.Lock()
+		completed := int(i) < len(h.State.Completed) && h.State.Completed[i]
+		h.State.Mutex.Unlock()
+		if completed {
+			continue
+		}
+		start := i * h.CHUNK_SIZE
+		end := start + h.CHUNK_SIZE
+		if end > h.State.TotalBytes {
+			end = h.State.TotalBytes
+		}
+		select {
+		case <-ctx.Done():
+			return // Exit on pause, the workers are leaving too
+		case jobs <- chunk{Start: start, End: end - 1}:
+			fmt.Printf("Dispatched chunk %d-%d\n", start, end-1)
+		}
+	}
+}
 
-			jobs <- chunk
-			fmt.Printf("Dispatched chunk %d-%d\n", chunk.Start, chunk.End)
-			currentByte = end
-		}   
-    }
-	if currentByte >= int64(contentLength) {
-        close(jobs)
-    }
+// true when every part is downloaded
+func (h *DownloadHandler) allPartsCompleted() bool {
+	h.State.Mutex.Lock()
+	defer h.State.Mutex.Unlock()
+	if int64(len(h.State.Completed)) != h.PartsCount {
+		return false
+	}
+	for _, done := range h.State.Completed {
+		if !done {
+			return false
+		}
+	}
+	return true
 }
 
+// runs the workers over the parts that are left and puts the file together
+// when they're done. used for starting, resuming and retrying
+func (h *DownloadHandler) runWorkers() error {
+	parent := h.ctx // Resume replaces h.ctx so we hold on to the one of this run
+	ctx, stop := context.WithCancel(parent)
+	defer stop()
+	stopped := make(chan struct{})
+	h.State.Mutex.Lock()
+	h.stopped = stopped
+	h.State.Mutex.Unlock()
+	defer close(stopped)
+
+	// jobs: it's a channel used to send chunks to worker "task to download a specific piece (or "chunk")""
+	jobs := make(chan chunk, h.WORKERS_COUNT)    // sends chunk information to workers
+	errChan := make(chan error, h.WORKERS_COUNT)
+	finished := make(chan struct{}) // closed when every worker is gone
+	pauseAck := make(chan bool, h.WORKERS_COUNT) // channel to acknowledge worker pause completion
+
+	var wg sync.WaitGroup
+	for i := 0; i < h.WORKERS_COUNT; i++ {
+		wg.Add(1)
+		go h.worker(ctx, i, jobs, errChan, pauseAck, &wg)
+	}
+
+	go h.distributeJobs(ctx, jobs)
+
+	// waiting for workers to be done
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	var err error
+	select {
+	case <-finished:
+	case err = <-errChan:
+		// a chunk ran out of retries. no point in letting the others go on
+		stop()
+		<-finished
+	}
+	if err != nil {
+		return err
+	}
+	if parent.Err() != nil {
+		return ErrPaused
+	}
+	if !h.allPartsCompleted() {
+		return fmt.Errorf("workers stopped but some parts are still missing")
+	}
+
+	if h.Storage == PartFiles {
+		parts := make([]string, h.PartsCount)
+		for i := range parts {
+			parts[i] = fmt.Sprintf("%s.part%d", h.FilePath, i)
+		}
+		skip, err := h.resolveCollision(parts)
+		if err != nil {
+			return err
+		}
+		if skip {
+			removeAll(parts)
+			return h.verifyChecksum()
+		}
+	}
+	fmt.Println("Calling combineParts")
+	if err := h.combineParts(h.State.TotalBytes); err != nil {
+		return err
+	}
+	return h.verifyChecksum()
+}
@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/placeholder14032/download-manager/internal/download"
+)
+
+const (
+	CREDENTIALS_FILE = "credentials"  // host patterns with their credentials, written by the user
+	SECRETS_FILE     = "secrets.json" // the credentials of single downloads, next to the state
+)
+
+// $XDG_CONFIG_HOME/download-manager/credentials, which defaults to
+// ~/.config/download-manager/credentials
+func DefaultCredentialsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return CREDENTIALS_FILE
+	}
+	return filepath.Join(dir, "download-manager", CREDENTIALS_FILE)
+}
+
+// $NETRC or ~/.netrc, like curl
+func DefaultNetrcFile() string {
+	if file := os.Getenv("NETRC"); file != "" {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".netrc"
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+func (m *Manager) credentialsFile() string {
+	if m.CredentialsFile != "" {
+		return m.CredentialsFile
+	}
+	return DefaultCredentialsFile()
+}
+
+func (m *Manager) netrcFile() string {
+	if m.NetrcFile != "" {
+		return m.NetrcFile
+	}
+	return DefaultNetrcFile()
+}
+
+// the secrets are never in the state file, they have a file of their own
+// that only we can read
+func (m *Manager) secretsFile() string {
+	return filepath.Join(filepath.Dir(m.stateFile()), SECRETS_FILE)
+}
+
+// gives the download its credentials and the ones by host. the files are
+// read every time so changes to them count from the next start on
+func (m *Manager) authorize(dl *download.Download) {
+	hosts, err := download.LoadHostCredentials(m.credentialsFile(), m.netrcFile())
+	if err != nil {
+		fmt.Println("Warning: ignoring the credentials by host:", err)
+	}
+	dl.Handler.SetCredentials(dl.Auth, hosts)
+}
+
+// what secrets.json has in it
+type savedSecrets struct {
+	Downloads map[int64]download.Credentials `json:",omitempty"` // by download id
+	Proxies   map[int64]string               `json:",omitempty"` // proxy urls with a password in them, by queue id
+}
+
+// writes the credentials of every download that has some and the proxies
+// with passwords, or removes the file if there are none
+func (m *Manager) writeSecrets() error {
+	secrets := savedSecrets{
+		Downloads: make(map[int64]download.Credentials),
+		Proxies:   make(map[int64]string),
+	}
+	for i := range m.qs {
+		if proxy := m.qs[i].Proxy; proxy.Redacted().URL != proxy.URL {
+			secrets.Proxies[m.qs[i].ID] = proxy.URL
+		}
+		for _, dl := range m.qs[i].DownloadLists {
+			if !dl.Auth.IsEmpty() {
+				secrets.Downloads[dl.ID] = dl.Auth
+			}
+		}
+	}
+	if len(secrets.Downloads) == 0 && len(secrets.Proxies) == 0 {
+		if err := os.Remove(m.secretsFile()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	bts, err := json.MarshalIndent(secrets, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode the secrets: %v", err)
+	}
+	return writeFileAtomic(m.secretsFile(), bts) // 0600 like every temp file
+}
+
+// gives the loaded downloads their credentials and the queues their proxy
+// passwords back
+func (m *Manager) loadSecrets() error {
+	bts, err := os.ReadFile(m.secretsFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var secrets savedSecrets
+	if err := json.Unmarshal(bts, &secrets); err != nil {
+		return fmt.Errorf("failed to read %s: %v", m.secretsFile(), err)
+	}
+	for i := range m.qs {
+		if url, ok := secrets.Proxies[m.qs[i].ID]; ok {
+			m.qs[i].Proxy.URL = url
+		}
+		for _, dl := range m.qs[i].DownloadLists {
+			dl.Auth = secrets.Downloads[dl.ID]
+		}
+	}
+	return nil
+}
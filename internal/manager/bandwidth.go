@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/download"
+	"github.com/placeholder14032/download-manager/internal/queue"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+const (
+	NEGATIVE_LIMIT = "a bandwidth limit can't be negative: %d"
+	UNKNOWN_SCOPE = "unknown bandwidth scope: %s"
+	NO_DOWNLOAD_SCHEDULE = "downloads don't have schedules, only queues and the global limit do"
+)
+
+// the bucket every download reads through in the end
+func (m *Manager) globalBucket() *download.Bucket {
+	if m.bandwidth == nil {
+		m.bandwidth = download.NewRootBucket(queue.ActiveLimit(m.bandwidthSchedule, m.now(), m.maxBandwidth), m.clock())
+	}
+	return m.bandwidth
+}
+
+// the bucket shared by the downloads of m.qs[i]. made the first time one
+// of them runs
+func (m *Manager) queueBucket(i int) *download.Bucket {
+	if m.queueBuckets == nil {
+		m.queueBuckets = make(map[int64]*download.Bucket)
+	}
+	q := &m.qs[i]
+	b, ok := m.queueBuckets[q.ID]
+	if !ok {
+		b = download.NewBucket(queue.ActiveLimit(q.BandwidthSchedule, m.now().In(q.Location()), q.MaxBandwidth), m.globalBucket())
+		m.queueBuckets[q.ID] = b
+	}
+	return b
+}
+
+// gives the download its own bucket below the one of its queue. has to
+// happen before its workers start. a paused one keeps the bucket it had,
+// the workers of the last run might still be reading through it
+func (m *Manager) limitDownload(dl *download.Download, i int) {
+	qb := m.queueBucket(i)
+	if b := dl.Handler.Bandwidth; b != nil && b.Parent() == qb {
+		b.SetRate(dl.MaxBandwidth)
+		return
+	}
+	dl.Handler.Bandwidth = download.NewBucket(dl.MaxBandwidth, qb)
+}
+
+// the running downloads notice right away. nothing gets restarted
+func (m *Manager) setBandwidth(body util.BodySetBandwidth) error {
+	if body.Limit < 0 {
+		return fmt.Errorf(NEGATIVE_LIMIT, body.Limit)
+	}
+	switch body.Scope {
+	case util.ScopeGlobal:
+		m.maxBandwidth = body.Limit
+	case util.ScopeQueue:
+		i := m.findQueueIndex(body.ID)
+		if i == -1 {
+			return notFoundError(CANT_FIND_QUEUE_ERROR, body.ID)
+		}
+		m.qs[i].MaxBandwidth = body.Limit
+	case util.ScopeDownload:
+		i, j := m.findDownloadQueueIndex(body.ID)
+		if i == -1 || j == -1 {
+			return notFoundError(CANT_FIND_DL_ERROR, body.ID)
+		}
+		dl := m.qs[i].DownloadLists[j] // not a copy
+		dl.MaxBandwidth = body.Limit
+		dl.Handler.Bandwidth.SetRate(body.Limit)
+	default:
+		return fmt.Errorf(UNKNOWN_SCOPE, body.Scope)
+	}
+	// a schedule that is on right now still wins over the new limit
+	m.applyBandwidth(m.now())
+	return nil
+}
+
+func (m *Manager) setBandwidthSchedule(body util.BodySetBandwidthSchedule) error {
+	for _, r := range body.Rules {
+		if r.Limit < 0 {
+			return fmt.Errorf(NEGATIVE_LIMIT, r.Limit)
+		}
+	}
+	switch body.Scope {
+	case util.ScopeGlobal:
+		m.bandwidthSchedule = body.Rules
+	case util.ScopeQueue:
+		i := m.findQueueIndex(body.ID)
+		if i == -1 {
+			return notFoundError(CANT_FIND_QUEUE_ERROR, body.ID)
+		}
+		m.qs[i].BandwidthSchedule = body.Rules
+	case util.ScopeDownload:
+		return fmt.Errorf(NO_DOWNLOAD_SCHEDULE)
+	default:
+		return fmt.Errorf(UNKNOWN_SCOPE, body.Scope)
+	}
+	m.applyBandwidth(m.now())
+	return nil
+}
+
+// sets the buckets to whatever the schedules say for now. called every
+// minute from the main loop so the running downloads follow the schedule
+// without being paused
+func (m *Manager) applyBandwidth(now time.Time) {
+	m.globalBucket().SetRate(queue.ActiveLimit(m.bandwidthSchedule, now, m.maxBandwidth))
+	for i := range m.qs {
+		q := &m.qs[i]
+		if _, ok := m.queueBuckets[q.ID]; !ok {
+			continue // nothing of it ran yet. it gets the right limit when it does
+		}
+		m.queueBucket(i).SetRate(queue.ActiveLimit(q.BandwidthSchedule, now.In(q.Location()), q.MaxBandwidth))
+	}
+}
@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"github.com/placeholder14032/download-manager/internal/download"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+const SUBSCRIBER_BUFFER = 64
+
+// returns a channel that gets every event the manager publishes
+// (starts, pauses, resumes, finishes, failures and progress ticks)
+// and a function to stop listening. a subscriber that doesn't keep up
+// loses events instead of blocking the manager
+func (m *Manager) Subscribe() (<-chan util.Event, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.subs == nil {
+		m.subs = make(map[int]chan util.Event)
+	}
+	id := m.lastSubID
+	m.lastSubID++
+	ch := make(chan util.Event, SUBSCRIBER_BUFFER)
+	m.subs[id] = ch
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.subs[id]; ok {
+			delete(m.subs, id)
+			close(ch)
+		}
+	}
+}
+
+func (m *Manager) hasSubscribers() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.subs) > 0
+}
+
+// sends the event with the current state of the download to everybody listening
+func (m *Manager) publish(t util.EventType, dl *download.Download, reason string) {
+	downloaded, size := dl.GetBytes()
+	e := util.Event{
+		Type: t,
+		DownloadID: dl.ID,
+		Reason: reason,
+		Status: dl.Status,
+		Progress: dl.GetProgress(),
+		Speed: dl.GetSpeed(),
+		Downloaded: downloaded,
+		Size: size,
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.subs {
+		select {
+		case ch <- e:
+		default: // too slow, skip it
+		}
+	}
+}
+
+// a progress tick for every download that is running
+func (m *Manager) publishProgress() {
+	if !m.hasSubscribers() {
+		return
+	}
+	for i := range m.qs {
+		for j := range m.qs[i].DownloadLists {
+			dl := m.qs[i].DownloadLists[j]
+			if dl.Status == download.Downloading {
+				m.publish(util.Progress, dl, "")
+			}
+		}
+	}
+}
+
+// nobody gets anything after this. the channels are closed so the
+// subscribers know
+func (m *Manager) closeSubscribers() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, ch := range m.subs {
+		close(ch)
+		delete(m.subs, id)
+	}
+}
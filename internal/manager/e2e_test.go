@@ -0,0 +1,952 @@
+package manager_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/controller"
+	"github.com/placeholder14032/download-manager/internal/download"
+	"github.com/placeholder14032/download-manager/internal/manager"
+	"github.com/placeholder14032/download-manager/internal/testorigin"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// how long a download in these tests may take before we give up on it
+const E2E_TIMEOUT = 30 * time.Second
+
+// a manager that only lives for one test, with one queue in a temp dir
+// and a fake origin to download from
+type env struct {
+	t      *testing.T
+	origin *testorigin.Origin
+	dir    string
+	config string // where the credentials file and the netrc are, empty until a test writes them
+	qid    int64
+	events <-chan util.Event
+	seen   []util.Event // everything read from events so far
+}
+
+func newEnv(t *testing.T, q util.QueueBody) *env {
+	t.Helper()
+	origin := testorigin.New()
+	t.Cleanup(origin.Close)
+
+	reqs := make(chan util.Request)
+	resps := make(chan util.Response)
+	config := t.TempDir()
+	m := &manager.Manager{
+		Ephemeral:       true,
+		CredentialsFile: filepath.Join(config, manager.CREDENTIALS_FILE),
+		NetrcFile:       filepath.Join(config, ".netrc"),
+	}
+	events, _ := m.Subscribe()
+	go m.Start(reqs, resps)
+	controller.SetSender(controller.NewChannelSender(reqs, resps))
+	t.Cleanup(m.Shutdown) // also closes events
+
+	e := &env{t: t, origin: origin, dir: t.TempDir(), config: config, events: events}
+	q.Directory = e.dir
+	if q.MaxSimul == 0 {
+		q.MaxSimul = 4
+	}
+	qid, err := controller.AddQueueBody(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.qid = qid
+	return e
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+// adds the download for url and starts it
+func (e *env) start(url string) int64 {
+	e.t.Helper()
+	id, err := controller.AddDownload(url, e.qid, "", "")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if err := controller.ModDownload(util.StartDownload, id); err != nil {
+		e.t.Fatal(err)
+	}
+	return id
+}
+
+// reads events until one of the types comes for the download
+func (e *env) waitFor(id int64, types ...util.EventType) util.Event {
+	e.t.Helper()
+	timeout := time.After(E2E_TIMEOUT)
+	for {
+		select {
+		case ev, ok := <-e.events:
+			if !ok {
+				e.t.Fatal("the manager stopped")
+			}
+			e.seen = append(e.seen, ev)
+			if ev.DownloadID != id {
+				continue
+			}
+			for _, t := range types {
+				if ev.Type == t {
+					return ev
+				}
+			}
+		case <-timeout:
+			e.t.Fatalf("download %d didn't get any of %v in %v", id, types, E2E_TIMEOUT)
+		}
+	}
+}
+
+// the order of the events (without progress ticks) the download got so far
+func (e *env) history(id int64) []util.EventType {
+	var types []util.EventType
+	for _, ev := range e.seen {
+		if ev.DownloadID == id && ev.Type != util.Progress {
+			types = append(types, ev.Type)
+		}
+	}
+	return types
+}
+
+func (e *env) download(id int64) util.DownloadBody {
+	e.t.Helper()
+	for _, d := range controller.GetAllDownloads() {
+		if d.ID == id {
+			return d
+		}
+	}
+	e.t.Fatalf("download %d is gone", id)
+	return util.DownloadBody{}
+}
+
+// waits for the download to finish and checks it has want in it
+func (e *env) expectFile(id int64, want []byte) util.DownloadBody {
+	e.t.Helper()
+	ev := e.waitFor(id, util.Finished, util.Failed)
+	if ev.Type != util.Finished {
+		e.t.Fatalf("download %d failed: %s", id, ev.Reason)
+	}
+	d := e.download(id)
+	if d.Status != download.Done {
+		e.t.Errorf("status is %v, want %v", d.Status, download.Done)
+	}
+	got, err := os.ReadFile(d.FilePath)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		e.t.Fatalf("got %d bytes that aren't the file (%d bytes)", len(got), len(want))
+	}
+	return d
+}
+
+func equalTypes(a, b []util.EventType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestE2ERanges(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(3 << 20)
+	id := e.start(e.origin.Add("/file.bin", &testorigin.File{Data: data}))
+	e.expectFile(id, data)
+
+	want := []util.EventType{util.Started, util.Finished}
+	if got := e.history(id); !equalTypes(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+	ranged := 0
+	for _, r := range e.origin.RequestsFor("/file.bin") {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			ranged++
+		}
+	}
+	if ranged < 2 {
+		t.Errorf("only %d range requests, the file should have been split up", ranged)
+	}
+}
+
+func TestE2ENoRanges(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(1 << 20)
+	id := e.start(e.origin.Add("/plain.bin", &testorigin.File{Data: data, NoRanges: true}))
+	e.expectFile(id, data)
+}
+
+func TestE2EWrongLength(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(64 << 10)
+	id := e.start(e.origin.Add("/short.bin", &testorigin.File{Data: data, LengthDelta: 1000}))
+	ev := e.waitFor(id, util.Finished, util.Failed)
+	if ev.Type != util.Failed {
+		t.Fatal("a body shorter than its Content-Length counted as finished")
+	}
+	if d := e.download(id); d.Status != download.Failed || d.FailReason == "" {
+		t.Errorf("status %v with reason %q, want failed with a reason", d.Status, d.FailReason)
+	}
+}
+
+func TestE2EDisconnects(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(3 << 20)
+	id := e.start(e.origin.Add("/flaky.bin", &testorigin.File{Data: data, Drops: 3}))
+	e.expectFile(id, data)
+	// the cut chunks were asked for again, no need to retry the whole thing
+	for _, typ := range e.history(id) {
+		if typ == util.Failed {
+			t.Error("the download failed on the way instead of retrying the chunks")
+		}
+	}
+}
+
+func TestE2ERetryAfter(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			e := newEnv(t, util.QueueBody{})
+			data := randomData(256 << 10)
+			url := e.origin.Add("/busy.bin", &testorigin.File{Data: data, Busy: 1, BusyStatus: status, RetryAfter: "1"})
+			begin := time.Now()
+			id := e.start(url)
+			e.expectFile(id, data)
+			if took := time.Since(begin); took < time.Second {
+				t.Errorf("finished after %v, the server asked to wait 1s", took)
+			}
+		})
+	}
+}
+
+func TestE2EPauseResume(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(1 << 20)
+	id := e.start(e.origin.Add("/slow.bin", &testorigin.File{Data: data, Delay: 10 * time.Millisecond}))
+	e.waitFor(id, util.Progress)
+
+	if err := controller.ModDownload(util.PauseDownload, id); err != nil {
+		t.Fatal(err)
+	}
+	e.waitFor(id, util.Pausing)
+	if d := e.download(id); d.Status != download.Paused {
+		t.Fatalf("status is %v after pausing", d.Status)
+	}
+	before := e.origin.Requests("/slow.bin")
+	time.Sleep(100 * time.Millisecond)
+	if after := e.origin.Requests("/slow.bin"); after != before {
+		t.Errorf("%d requests while paused", after-before)
+	}
+
+	if err := controller.ModDownload(util.ResumeDownload, id); err != nil {
+		t.Fatal(err)
+	}
+	e.expectFile(id, data)
+	want := []util.EventType{util.Started, util.Pausing, util.Resuming, util.Finished}
+	if got := e.history(id); !equalTypes(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+}
+
+// the file changes while the download is paused. resuming has to notice
+func TestE2ERemoteChanged(t *testing.T) {
+	data := randomData(1 << 20)
+	changed := randomData(1<<20 + 1)
+	changeWhilePaused := func(e *env, bodyMD5 bool) int64 {
+		id := e.start(e.origin.Add("/moving.bin", &testorigin.File{Data: data, BodyMD5: bodyMD5, Delay: 10 * time.Millisecond}))
+		e.waitFor(id, util.Progress)
+		if err := controller.ModDownload(util.PauseDownload, id); err != nil {
+			t.Fatal(err)
+		}
+		e.waitFor(id, util.Pausing)
+		e.origin.Change("/moving.bin", changed)
+		if err := controller.ModDownload(util.ResumeDownload, id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	t.Run("restart", func(t *testing.T) {
+		e := newEnv(t, util.QueueBody{OnRemoteChange: download.RestartOnChange})
+		e.expectFile(changeWhilePaused(e, false), changed)
+	})
+	t.Run("restart with a new digest", func(t *testing.T) {
+		// the digest of the old version must not be held against the new one
+		e := newEnv(t, util.QueueBody{OnRemoteChange: download.RestartOnChange})
+		dl := e.expectFile(changeWhilePaused(e, true), changed)
+		sum := md5.Sum(changed)
+		if want := "md5:" + hex.EncodeToString(sum[:]); dl.Checksum != want {
+			t.Errorf("verified against %q, want %q", dl.Checksum, want)
+		}
+	})
+	t.Run("fail", func(t *testing.T) {
+		e := newEnv(t, util.QueueBody{OnRemoteChange: download.FailOnChange})
+		ev := e.waitFor(changeWhilePaused(e, false), util.Finished, util.Failed)
+		if ev.Type != util.Failed {
+			t.Fatal("finished even though the file changed")
+		}
+	})
+}
+
+func TestE2ERedirect(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(1 << 20)
+	e.origin.Add("/files/v2/real.bin", &testorigin.File{Data: data})
+	e.origin.Redirect("/mirror", "/files/v2/real.bin")
+	id := e.start(e.origin.Redirect("/latest", "/mirror"))
+	d := e.expectFile(id, data)
+	// named after where it ended up
+	if want := filepath.Join(e.dir, "real.bin"); d.FilePath != want {
+		t.Errorf("saved as %s, want %s", d.FilePath, want)
+	}
+}
+
+// counts the GETs for path that asked for more than the first byte
+func rangedGets(o *testorigin.Origin, path string) int {
+	n := 0
+	for _, r := range o.RequestsFor(path) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" && r.Header.Get("Range") != "bytes=0-0" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestE2EHeadBlocked(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(3 << 20)
+	id := e.start(e.origin.Add("/nohead.bin", &testorigin.File{Data: data, NoHead: true}))
+	d := e.expectFile(id, data)
+	if n := rangedGets(e.origin, "/nohead.bin"); n < 2 {
+		t.Errorf("only %d range requests, the probe should have found out about ranges", n)
+	}
+	if d.Size != int64(len(data)) || d.Downloaded != int64(len(data)) {
+		t.Errorf("%d of %d bytes, want %d of %d", d.Downloaded, d.Size, len(data), len(data))
+	}
+}
+
+func TestE2EHiddenRanges(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(3 << 20)
+	id := e.start(e.origin.Add("/hidden.bin", &testorigin.File{Data: data, HideRanges: true}))
+	e.expectFile(id, data)
+	if n := rangedGets(e.origin, "/hidden.bin"); n < 2 {
+		t.Errorf("only %d range requests, the probe should have found out about ranges", n)
+	}
+}
+
+func TestE2EUnknownLength(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(1 << 20)
+	id := e.start(e.origin.Add("/stream.bin", &testorigin.File{Data: data, NoLength: true, NoHead: true, Delay: 10 * time.Millisecond}))
+
+	ev := e.waitFor(id, util.Progress)
+	if ev.Size != download.UNKNOWN_LENGTH || ev.Progress != 0 {
+		t.Errorf("progress event with size %d and %v%%, want an unknown size and 0%%", ev.Size, ev.Progress)
+	}
+	d := e.expectFile(id, data)
+	if d.Size != int64(len(data)) || d.Progress != 100 {
+		t.Errorf("finished with size %d at %v%%, want %d at 100%%", d.Size, d.Progress, len(data))
+	}
+}
+
+// pauses a running download and checks that it really stopped
+func (e *env) pause(id int64, path string) {
+	e.t.Helper()
+	if err := controller.ModDownload(util.PauseDownload, id); err != nil {
+		e.t.Fatal(err)
+	}
+	e.waitFor(id, util.Pausing)
+	time.Sleep(50 * time.Millisecond) // the last read might still be on its way
+	d := e.download(id)
+	before := e.origin.Requests(path)
+	time.Sleep(100 * time.Millisecond)
+	if after := e.origin.Requests(path); after != before {
+		e.t.Errorf("%d requests while paused", after-before)
+	}
+	if now := e.download(id); now.Downloaded != d.Downloaded {
+		e.t.Errorf("went on from %d to %d bytes while paused", d.Downloaded, now.Downloaded)
+	}
+}
+
+func TestE2EStreamPauseResume(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(1 << 20)
+	id := e.start(e.origin.Add("/stream.bin", &testorigin.File{Data: data, NoRanges: true, Delay: 10 * time.Millisecond}))
+
+	ev := e.waitFor(id, util.Progress)
+	if ev.Downloaded <= 0 || ev.Progress <= 0 || ev.Size != int64(len(data)) {
+		t.Errorf("progress of %d bytes of %d (%v%%), want it going", ev.Downloaded, ev.Size, ev.Progress)
+	}
+	e.pause(id, "/stream.bin")
+
+	if err := controller.ModDownload(util.ResumeDownload, id); err != nil {
+		t.Fatal(err)
+	}
+	e.expectFile(id, data)
+	reqs := e.origin.RequestsFor("/stream.bin")
+	if last := reqs[len(reqs)-1]; last.Header.Get("Range") != "" {
+		t.Errorf("asked for %s from a server without ranges", last.Header.Get("Range"))
+	}
+}
+
+func TestE2EStreamContinuesWithRanges(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	data := randomData(1 << 20)
+	id := e.start(e.origin.Add("/later.bin", &testorigin.File{Data: data, NoRanges: true, Delay: 10 * time.Millisecond}))
+	e.waitFor(id, util.Progress)
+	e.pause(id, "/later.bin")
+	have := e.download(id).Downloaded
+
+	// the server learned about ranges in the meantime
+	e.origin.Set("/later.bin", func(f *testorigin.File) { f.NoRanges = false })
+	if err := controller.ModDownload(util.ResumeDownload, id); err != nil {
+		t.Fatal(err)
+	}
+	e.expectFile(id, data)
+	reqs := e.origin.RequestsFor("/later.bin")
+	last := reqs[len(reqs)-1]
+	if want := fmt.Sprintf("bytes=%d-", have); last.Header.Get("Range") != want {
+		t.Errorf("went on with Range %q, want %q", last.Header.Get("Range"), want)
+	}
+}
+
+func TestE2EFileNames(t *testing.T) {
+	data := randomData(64 << 10)
+	tests := []struct {
+		name        string
+		file        string // the path on the origin
+		url         string // how we ask for it
+		disposition string
+		userName    string
+		want        string
+	}{
+		{"url", "/files/report.pdf", "/files/report.pdf", "", "", "report.pdf"},
+		{"query", "/get", "/get?id=42&token=x", "", "", "get"},
+		{"escaped", "/my file#1.txt", "/my%20file%231.txt", "", "", "my file#1.txt"},
+		{"disposition", "/get", "/get?id=1", `attachment; filename="setup v2.exe"`, "", "setup v2.exe"},
+		{"extended", "/get", "/get?id=2", `attachment; filename="fallback.txt"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`, "", "résumé.pdf"},
+		{"not a path", "/get", "/get?id=3", `attachment; filename="..\\..\\evil:name?.txt"`, "", "evil_name_.txt"},
+		{"user", "/get", "/get?id=4", `attachment; filename="server.bin"`, "mine.bin", "mine.bin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t, util.QueueBody{})
+			e.origin.Add(tt.file, &testorigin.File{Data: data, Disposition: tt.disposition})
+			id, err := controller.AddDownload(e.origin.URL+tt.url, e.qid, tt.userName, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := controller.ModDownload(util.StartDownload, id); err != nil {
+				t.Fatal(err)
+			}
+			d := e.expectFile(id, data)
+			if want := filepath.Join(e.dir, tt.want); d.FilePath != want {
+				t.Errorf("saved as %s, want %s", d.FilePath, want)
+			}
+		})
+	}
+}
+
+// two downloads that only find out their name when they start can't both
+// have it, whatever the policy
+func TestE2ESameServerName(t *testing.T) {
+	for _, policy := range []download.CollisionPolicy{download.RenameOnCollision, download.OverwriteOnCollision} {
+		t.Run(policy.String(), func(t *testing.T) {
+			e := newEnv(t, util.QueueBody{OnCollision: policy})
+			datas := [][]byte{randomData(1 << 20), randomData(1 << 20)}
+			var ids []int64
+			for i, data := range datas {
+				url := e.origin.Add(fmt.Sprintf("/get%d", i), &testorigin.File{Data: data, Disposition: `attachment; filename="setup.exe"`, Delay: 10 * time.Millisecond, HeadDelay: 200 * time.Millisecond})
+				id, err := controller.AddDownload(url, e.qid, "", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+			}
+			for _, id := range ids {
+				if err := controller.ModDownload(util.StartDownload, id); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// even while they run. with the same path they take turns writing the same parts
+			for _, id := range ids {
+				e.waitFor(id, util.Progress)
+			}
+			if a, b := e.download(ids[0]).FilePath, e.download(ids[1]).FilePath; a == b {
+				t.Fatalf("both are saving to %s", a)
+			}
+			paths := make(map[string]bool)
+			for i, id := range ids {
+				if !e.finished(id) {
+					e.waitFor(id, util.Finished, util.Failed)
+				}
+				d := e.download(id)
+				got, err := os.ReadFile(d.FilePath)
+				if err != nil || !bytes.Equal(got, datas[i]) {
+					t.Fatalf("download %d at %s doesn't have its file: %v", id, d.FilePath, err)
+				}
+				paths[d.FilePath] = true
+			}
+			for _, name := range []string{"setup.exe", "setup (1).exe"} {
+				if !paths[filepath.Join(e.dir, name)] {
+					t.Errorf("nothing saved as %s, got %v", name, paths)
+				}
+			}
+		})
+	}
+}
+
+// whether the download finished in the events seen so far
+func (e *env) finished(id int64) bool {
+	for _, ev := range e.seen {
+		if ev.DownloadID == id && ev.Type == util.Finished {
+			return true
+		}
+	}
+	return false
+}
+
+func TestE2ECollisionRename(t *testing.T) {
+	e := newEnv(t, util.QueueBody{MaxSimul: 1})
+	data := randomData(64 << 10)
+	url := e.origin.Add("/setup.exe", &testorigin.File{Data: data})
+	mine := []byte("not the download")
+	os.WriteFile(filepath.Join(e.dir, "setup.exe"), mine, 0644)
+
+	// both get their names when they are added. the queue starts the second
+	// when the first is done
+	var ids []int64
+	for range 2 {
+		id, err := controller.AddDownload(url, e.qid, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := controller.ModDownload(util.StartDownload, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	for n, id := range ids {
+		d := e.expectFile(id, data)
+		if want := filepath.Join(e.dir, fmt.Sprintf("setup (%d).exe", n+1)); d.FilePath != want {
+			t.Errorf("saved as %s, want %s", d.FilePath, want)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(e.dir, "setup.exe")); !bytes.Equal(got, mine) {
+		t.Errorf("the file that was there got changed")
+	}
+}
+
+func TestE2ECollisionPolicies(t *testing.T) {
+	data := randomData(64 << 10)
+	tests := []struct {
+		name     string
+		policy   download.CollisionPolicy
+		there    []byte
+		wantName string // empty if it fails
+		wantData []byte // what ends up at setup.exe
+	}{
+		{"overwrite", download.OverwriteOnCollision, []byte("old"), "setup.exe", data},
+		{"skip same", download.SkipIfIdentical, data, "setup.exe", data},
+		{"skip other", download.SkipIfIdentical, []byte("old"), "setup (1).exe", []byte("old")},
+		{"fail", download.FailOnCollision, []byte("old"), "", []byte("old")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t, util.QueueBody{OnCollision: tt.policy})
+			url := e.origin.Add("/setup.exe", &testorigin.File{Data: data})
+			there := filepath.Join(e.dir, "setup.exe")
+			os.WriteFile(there, tt.there, 0644)
+
+			id, err := controller.AddDownload(url, e.qid, "", "")
+			if tt.wantName == "" {
+				if err == nil {
+					t.Fatalf("added download %d over a file", id)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := controller.ModDownload(util.StartDownload, id); err != nil {
+					t.Fatal(err)
+				}
+				d := e.expectFile(id, data)
+				if want := filepath.Join(e.dir, tt.wantName); d.FilePath != want {
+					t.Errorf("saved as %s, want %s", d.FilePath, want)
+				}
+			}
+			if got, _ := os.ReadFile(there); !bytes.Equal(got, tt.wantData) {
+				t.Errorf("setup.exe has %d bytes, want %d", len(got), len(tt.wantData))
+			}
+			if parts, _ := filepath.Glob(there + ".part*"); len(parts) != 0 {
+				t.Errorf("left behind %v", parts)
+			}
+		})
+	}
+}
+
+// the file shows up while the download is running
+func TestE2ECollisionAtCompletion(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		policy download.CollisionPolicy
+		file   testorigin.File
+	}{
+		{"rename", download.RenameOnCollision, testorigin.File{}},
+		{"rename stream", download.RenameOnCollision, testorigin.File{NoRanges: true}},
+		{"fail", download.FailOnCollision, testorigin.File{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t, util.QueueBody{OnCollision: tt.policy})
+			data := randomData(1 << 20)
+			f := tt.file
+			f.Data, f.Delay = data, 10*time.Millisecond
+			id := e.start(e.origin.Add("/late.bin", &f))
+			e.waitFor(id, util.Progress)
+			there := filepath.Join(e.dir, "late.bin")
+			mine := []byte("got here first")
+			os.WriteFile(there, mine, 0644)
+
+			if tt.policy == download.FailOnCollision {
+				if ev := e.waitFor(id, util.Finished, util.Failed); ev.Type != util.Failed {
+					t.Fatalf("finished over a file")
+				}
+			} else {
+				d := e.expectFile(id, data)
+				if want := filepath.Join(e.dir, "late (1).bin"); d.FilePath != want {
+					t.Errorf("saved as %s, want %s", d.FilePath, want)
+				}
+			}
+			if got, _ := os.ReadFile(there); !bytes.Equal(got, mine) {
+				t.Errorf("the file that was there got changed")
+			}
+		})
+	}
+}
+
+func TestE2ESamePathRefused(t *testing.T) {
+	e := newEnv(t, util.QueueBody{OnCollision: download.OverwriteOnCollision})
+	url := e.origin.Add("/same.bin", &testorigin.File{Data: randomData(1024)})
+	if _, err := controller.AddDownload(url, e.qid, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := controller.AddDownload(url, e.qid, "", ""); err == nil {
+		t.Errorf("download %d got the same path as a pending one", id)
+	}
+}
+
+func TestE2ESymlinkEscape(t *testing.T) {
+	e := newEnv(t, util.QueueBody{OnCollision: download.OverwriteOnCollision})
+	outside := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(outside, []byte("keep me"), 0644)
+
+	// there when it's added
+	os.Symlink(outside, filepath.Join(e.dir, "early.bin"))
+	url := e.origin.Add("/early.bin", &testorigin.File{Data: randomData(1024)})
+	if _, err := controller.AddDownload(url, e.qid, "", ""); err == nil || !strings.Contains(err.Error(), download.ErrOutsideDir.Error()) {
+		t.Errorf("added a download through a symlink: %v", err)
+	}
+
+	// shows up before it starts
+	url = e.origin.Add("/late.bin", &testorigin.File{Data: randomData(1024)})
+	id, err := controller.AddDownload(url, e.qid, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink(outside, filepath.Join(e.dir, "late.bin"))
+	if err := controller.ModDownload(util.StartDownload, id); err != nil {
+		t.Fatal(err)
+	}
+	ev := e.waitFor(id, util.Finished, util.Failed)
+	if ev.Type != util.Failed || !strings.Contains(ev.Reason, download.ErrOutsideDir.Error()) {
+		t.Errorf("got %v (%s), want it to fail", ev.Type, ev.Reason)
+	}
+	if got, _ := os.ReadFile(outside); string(got) != "keep me" {
+		t.Errorf("wrote through the symlink")
+	}
+}
+
+// every request sends the headers, cookies and user agent of the queue and the download
+func TestE2ERequestOptions(t *testing.T) {
+	for _, file := range []testorigin.File{{}, {NoRanges: true}, {NoHead: true}} {
+		e := newEnv(t, util.QueueBody{Request: download.RequestOptions{
+			Headers:   http.Header{"X-Team": {"builds"}, "Referer": {"https://queue.example.com/"}},
+			UserAgent: "portal-fetcher/2",
+		}})
+		data := randomData(3 << 20)
+		file.Data = data
+		url := e.origin.Add("/artifact.zip", &file)
+		id, err := controller.AddDownloadBody(util.BodyAddDownload{
+			URL:     url,
+			QueueID: e.qid,
+			Request: download.RequestOptions{
+				Headers: http.Header{"Referer": {"https://portal.example.com/"}},
+				Cookies: []download.Cookie{
+					{Domain: "127.0.0.1", Path: "/", Name: "session", Value: "s3cret"},
+					{Domain: "127.0.0.1", Path: "/other", Name: "elsewhere", Value: "no"},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := controller.ModDownload(util.StartDownload, id); err != nil {
+			t.Fatal(err)
+		}
+		e.expectFile(id, data)
+
+		reqs := e.origin.RequestsFor("/artifact.zip")
+		if len(reqs) < 2 {
+			t.Fatalf("only %d requests", len(reqs))
+		}
+		for _, r := range reqs {
+			what := r.Method + " " + r.Header.Get("Range")
+			if got := r.Header.Get("User-Agent"); got != "portal-fetcher/2" {
+				t.Errorf("%s: User-Agent %q", what, got)
+			}
+			if got := r.Header.Get("X-Team"); got != "builds" {
+				t.Errorf("%s: X-Team %q", what, got)
+			}
+			if got := r.Header.Get("Referer"); got != "https://portal.example.com/" {
+				t.Errorf("%s: Referer %q", what, got)
+			}
+			if got := r.Header.Get("Cookie"); got != "session=s3cret" {
+				t.Errorf("%s: Cookie %q", what, got)
+			}
+		}
+	}
+}
+
+// the credentials of the download, then the credentials file, then the netrc
+func TestE2EAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		auth        download.Credentials
+		credentials string
+		netrc       string
+		want        string
+	}{
+		{name: "own basic", auth: download.Credentials{Username: "alice", Password: "hunter2"},
+			netrc: "machine 127.0.0.1 login bob password wrong\n", want: "Basic YWxpY2U6aHVudGVyMg=="},
+		{name: "own bearer", auth: download.Credentials{Token: "t0ken"}, want: "Bearer t0ken"},
+		{name: "credentials file", credentials: "127.0.0.* bearer from-file\n",
+			netrc: "default login bob password wrong\n", want: "Bearer from-file"},
+		{name: "netrc", netrc: "machine 127.0.0.1 login bob password pw\n", want: "Basic Ym9iOnB3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t, util.QueueBody{})
+			os.WriteFile(filepath.Join(e.config, manager.CREDENTIALS_FILE), []byte(tt.credentials), 0600)
+			os.WriteFile(filepath.Join(e.config, ".netrc"), []byte(tt.netrc), 0600)
+			data := randomData(1 << 20)
+			url := e.origin.Add("/private.bin", &testorigin.File{Data: data, Auth: tt.want})
+			id, err := controller.AddDownloadBody(util.BodyAddDownload{URL: url, QueueID: e.qid, Auth: tt.auth})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := controller.ModDownload(util.StartDownload, id); err != nil {
+				t.Fatal(err)
+			}
+			dl := e.expectFile(id, data)
+			if dl.Auth != tt.auth.String() {
+				t.Errorf("shown as %q, want %q", dl.Auth, tt.auth.String())
+			}
+		})
+	}
+}
+
+// the proxy of the queue is used for every request, except for the hosts
+// that skip it
+func TestE2EProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxy   *testorigin.Proxy
+		noProxy []string
+		used    bool
+	}{
+		{name: "http", proxy: testorigin.NewHTTPProxy("alice", "hunter2"), used: true},
+		{name: "socks5", proxy: testorigin.NewSOCKS5Proxy("alice", "hunter2"), used: true},
+		{name: "socks5 without login", proxy: testorigin.NewSOCKS5Proxy("", ""), used: true},
+		{name: "no proxy", proxy: testorigin.NewHTTPProxy("", ""), noProxy: []string{"127.0.0.0/8"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.proxy.Close()
+			e := newEnv(t, util.QueueBody{Proxy: download.ProxySettings{
+				Mode:    download.CustomProxy,
+				URL:     tt.proxy.URL,
+				NoProxy: tt.noProxy,
+			}})
+			data := randomData(3 << 20)
+			e.origin.Add("/via.bin", &testorigin.File{Data: data})
+			e.expectFile(e.start(e.origin.URL+"/via.bin"), data)
+
+			host := strings.TrimPrefix(e.origin.URL, "http://")
+			hosts := tt.proxy.Hosts()
+			if !tt.used {
+				if len(hosts) != 0 {
+					t.Errorf("went through the proxy: %v", hosts)
+				}
+				return
+			}
+			if len(hosts) == 0 {
+				t.Fatalf("didn't go through the proxy")
+			}
+			for _, h := range hosts {
+				if h != host {
+					t.Errorf("the proxy was asked for %s", h)
+				}
+			}
+		})
+	}
+}
+
+func TestE2EBadProxy(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	bad := util.QueueBody{Directory: e.dir, MaxSimul: 1, Proxy: download.ProxySettings{Mode: download.CustomProxy, URL: "ftp://proxy:21"}}
+	if _, err := controller.AddQueueBody(bad); err == nil {
+		t.Errorf("added a queue with an ftp proxy")
+	}
+}
+
+// certificates of a private ca, client certificates, pins and the insecure
+// switch, for the whole queue or by host
+func TestE2ETLS(t *testing.T) {
+	certs := t.TempDir()
+	clientCert, clientKey, clientCAs := testorigin.NewClientCert(certs)
+	wrongPin := "sha256//" + strings.Repeat("A", 43) + "="
+
+	tests := []struct {
+		name   string
+		mtls   bool // the origin wants a client certificate
+		proxy  bool // through an http proxy
+		tls    func(ca, pin string) download.TLSSettings
+		reason string // what it fails with. empty if it works
+	}{
+		{name: "untrusted", tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{}
+		}, reason: "certificate"},
+		{name: "ca", tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{TLSOptions: download.TLSOptions{CAFiles: []string{ca}}}
+		}},
+		{name: "ca for the host", tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{Hosts: []download.HostTLS{{Pattern: "127.0.0.1", TLSOptions: download.TLSOptions{CAFiles: []string{ca}}}}}
+		}},
+		{name: "ca for another host", tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{Hosts: []download.HostTLS{{Pattern: "*.example.com", TLSOptions: download.TLSOptions{CAFiles: []string{ca}}}}}
+		}, reason: "certificate"},
+		{name: "insecure", tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{TLSOptions: download.TLSOptions{InsecureSkipVerify: true}}
+		}},
+		{name: "pin", tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{TLSOptions: download.TLSOptions{CAFiles: []string{ca}, Pins: []string{wrongPin, pin}}}
+		}},
+		{name: "wrong pin", tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{TLSOptions: download.TLSOptions{CAFiles: []string{ca}, Pins: []string{wrongPin}}}
+		}, reason: download.ErrPinMismatch.Error()},
+		{name: "insecure with a wrong pin", tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{TLSOptions: download.TLSOptions{InsecureSkipVerify: true, Pins: []string{wrongPin}}}
+		}, reason: download.ErrPinMismatch.Error()},
+		{name: "client certificate", mtls: true, tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{TLSOptions: download.TLSOptions{CAFiles: []string{ca}, ClientCert: clientCert, ClientKey: clientKey}}
+		}},
+		{name: "no client certificate", mtls: true, tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{TLSOptions: download.TLSOptions{CAFiles: []string{ca}}}
+		}, reason: "certificate"},
+		{name: "connect proxy", proxy: true, tls: func(ca, pin string) download.TLSSettings {
+			return download.TLSSettings{TLSOptions: download.TLSOptions{CAFiles: []string{ca}}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pool *x509.CertPool
+			if tt.mtls {
+				pool = clientCAs
+			}
+			origin := testorigin.NewTLS(pool)
+			defer origin.Close()
+			q := util.QueueBody{TLS: tt.tls(origin.WriteCA(t.TempDir()), download.PinOf(origin.Certificate()))}
+			var proxy *testorigin.Proxy
+			if tt.proxy {
+				proxy = testorigin.NewHTTPProxy("", "")
+				defer proxy.Close()
+				q.Proxy = download.ProxySettings{Mode: download.CustomProxy, URL: proxy.URL}
+			}
+			e := newEnv(t, q)
+			data := randomData(1 << 20)
+			url := origin.Add("/secure.bin", &testorigin.File{Data: data})
+			id := e.start(url)
+			if tt.reason == "" {
+				e.expectFile(id, data)
+			} else {
+				ev := e.waitFor(id, util.Finished, util.Failed)
+				if ev.Type != util.Failed || !strings.Contains(ev.Reason, tt.reason) {
+					t.Errorf("got %v (%s), want it to fail with %q", ev.Type, ev.Reason, tt.reason)
+				}
+			}
+			if tt.proxy {
+				host := strings.TrimPrefix(origin.URL, "https://")
+				if hosts := proxy.Hosts(); len(hosts) == 0 || hosts[0] != host {
+					t.Errorf("the proxy was asked for %v, want %s", hosts, host)
+				}
+			}
+		})
+	}
+}
+
+func TestE2EBadTLS(t *testing.T) {
+	e := newEnv(t, util.QueueBody{})
+	bad := util.QueueBody{Directory: e.dir, MaxSimul: 1, TLS: download.TLSSettings{
+		TLSOptions: download.TLSOptions{CAFiles: []string{filepath.Join(e.dir, "missing.pem")}},
+	}}
+	if _, err := controller.AddQueueBody(bad); err == nil {
+		t.Errorf("added a queue with a ca file that isn't there")
+	}
+}
+
+// a Content-MD5 only counts for the file if the body was the whole file,
+// the one of the range probe is the md5 of a single byte
+func TestE2EContentMD5(t *testing.T) {
+	data := randomData(3 << 20)
+	sum := md5.Sum(data)
+	whole := "md5:" + hex.EncodeToString(sum[:])
+	tests := []struct {
+		name string
+		file testorigin.File
+		want string // what the file was verified against
+	}{
+		{name: "head", file: testorigin.File{}, want: whole},
+		{name: "range probe", file: testorigin.File{NoHead: true}, want: ""},
+		{name: "whole body probe", file: testorigin.File{NoHead: true, NoRanges: true}, want: whole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t, util.QueueBody{})
+			tt.file.Data = data
+			tt.file.BodyMD5 = true
+			url := e.origin.Add("/md5.bin", &tt.file)
+			dl := e.expectFile(e.start(url), data)
+			if dl.Checksum != tt.want {
+				t.Errorf("verified against %q, want %q", dl.Checksum, tt.want)
+			}
+		})
+	}
+}
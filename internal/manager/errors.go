@@ -0,0 +1,36 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// an error that knows what kind of failure it is so the response can tell
+// the client whether it asked for something missing or something that
+// doesn't fit the current state. plain errors count as bad requests
+type requestError struct {
+	kind util.FailureKind
+	msg  string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func notFoundError(format string, a ...any) error {
+	return &requestError{kind: util.NotFound, msg: fmt.Sprintf(format, a...)}
+}
+
+func conflictError(format string, a ...any) error {
+	return &requestError{kind: util.Conflict, msg: fmt.Sprintf(format, a...)}
+}
+
+func failureKind(err error) util.FailureKind {
+	var re *requestError
+	if errors.As(err, &re) {
+		return re.kind
+	}
+	return util.BadRequest
+}
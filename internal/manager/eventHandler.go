@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -20,60 +21,72 @@ func (m *Manager) tryRun(cand *download.Download) bool {
 	return false
 }
 
-func (m *Manager) runNext(i, j int) {
-	ln := len(m.qs[i].DownloadLists)
-	for k := j+1; k < ln; k++ {
-		cand := m.qs[i].DownloadLists[k]
-		if m.tryRun(&cand){
-			return
-		}
+// fills the free spots of the queue with what comes first in its order
+func (m *Manager) runNext(i int) {
+	q := &m.qs[i]
+	if q.Disabled {
+		return
 	}
-	for k := 0; k < j; k++ {
-		cand := m.qs[i].DownloadLists[k]
-		if m.tryRun(&cand){
+	for _, k := range q.Order() {
+		if !q.IsSafeToRunDL() {
 			return
 		}
+		m.tryRun(q.DownloadLists[k])
 	}
 }
 
-func (m *Manager) handleFailed(dl *download.Download, i, j int) {
-	cleanUp(dl.FilePath)
-	if dl.RetryCount < dl.MaxRetries {
+func (m *Manager) handleFailed(dl *download.Download, i int, err error) {
+	// every chunk already had its own retries before we got here.
+	// we don't throw away the parts that made it, the retry goes on from them.
+	// if the file changed on the server (and the queue wants to fail then)
+	// trying again just finds the same thing so only the user can retry it.
+	// same for a file that is in the way and the queue doesn't want to touch
+	// and for a path that leads out of the directory
+	if dl.RetryCount < dl.MaxRetries && !errors.Is(err, download.ErrRemoteChanged) &&
+		!errors.Is(err, download.ErrFileExists) && !errors.Is(err, download.ErrOutsideDir) {
 		dl.RetryCount++
-		m.cancelDownload(dl.ID) // making sure everybody is dead
-		m.retryDownload(dl.ID) // should work after cancel
+		dl.Status = download.Downloading
+		m.prepareRun(dl, i)
+		go getDownloadRetried(dl, m.events)
 	} else {
 		dl.Status = download.Failed
 		if m.qs[i].IsSafeToRunDL() {
-			m.runNext(i, j)
+			m.runNext(i)
 		}
 	}
 }
 
-func (m *Manager) handleFinished(dl *download.Download, i, j int) {
+func (m *Manager) handleFinished(dl *download.Download, i int) {
 	dl.Status = download.Done
+	dl.FailReason = ""
+	dl.VerifiedChecksum = dl.Handler.VerifiedChecksum
 	if m.qs[i].IsSafeToRunDL() {
-		m.runNext(i, j)
+		m.runNext(i)
 	}
 }
 
 func (m *Manager) handleEvent(e util.Event) {
 	i, j := m.findDownloadQueueIndex(e.DownloadID)
 	if i == -1 || j == -1 {
-		fmt.Fprintf(os.Stderr, "Can't find download with id %d", e.DownloadID)
+		fmt.Fprintf(os.Stderr, "Can't find download with id %d\n", e.DownloadID)
+		return
 	}
-	dl := &m.qs[i].DownloadLists[j] // not a copy but a pointer to the real one
+	dl := m.qs[i].DownloadLists[j] // not a copy but a pointer to the real one
+	dl.FilePath = dl.GetFilePath() // it might have gotten its name from the server
 	switch e.Type {
 	case util.Pausing:
 		dl.Status = download.Paused
 	case util.Resuming:
 		dl.Status = download.Downloading
 	case util.Failed:
-		m.handleFailed(dl, i, j) // we have to clean up after failure
+		fmt.Fprintf(os.Stderr, "download %d failed: %s\n", dl.ID, e.Reason)
+		dl.FailReason = e.Reason
+		m.handleFailed(dl, i, e.Err)
 	case util.Finished:
-		m.handleFinished(dl, i, j)
+		m.handleFinished(dl, i)
 	default:
 		panic(fmt.Sprintf("unexpected util.EventType: %#v", e.Type))
 	}
+	m.publish(e.Type, dl, e.Reason)
 }
 
@@ -1,14 +1,24 @@
 package manager
 
 import (
+	"fmt"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/placeholder14032/download-manager/internal/clock"
+	"github.com/placeholder14032/download-manager/internal/download"
 	"github.com/placeholder14032/download-manager/internal/queue"
 	"github.com/placeholder14032/download-manager/internal/util"
 )
 
 type Manager struct {
+	StateFile string // where the state is saved. DefaultStateFile() if empty
+	Ephemeral bool // doesn't load or save anything. for one-off managers
+	Clock clock.Clock // what the schedules, timers and limits go by. the real one if nil, tests can set their own
+	CredentialsFile string // credentials by host pattern. DefaultCredentialsFile() if empty
+	NetrcFile string // the fallback for hosts without credentials. DefaultNetrcFile() if empty
+
 	mu      sync.Mutex // used to protect the following fields
 	// useless mutex probably because almost everything is single threaded
 	// and the others have their own mutexes
@@ -18,6 +28,25 @@ type Manager struct {
 	events  chan util.Event
 	req chan util.Request
 	resps chan util.Response
+	// subscribers of published events. protected by mu because they
+	// subscribe from other goroutines
+	subs map[int]chan util.Event
+	lastSubID int
+	quit chan chan struct{} // see Shutdown
+	saveTimer clock.Timer // set while a save is pending. only used by the main loop
+	maxBandwidth int64 // global limit in bytes per second. 0 means none
+	bandwidthSchedule []queue.BandwidthRule // other global limits at certain times
+	bandwidth *download.Bucket // see globalBucket
+	queueBuckets map[int64]*download.Bucket // by queue id. see queueBucket
+	claims chan pathClaim // names the servers gave downloads. see claimRemotePath
+}
+
+func (m *Manager) clock() clock.Clock {
+	return clock.Or(m.Clock)
+}
+
+func (m *Manager) now() time.Time {
+	return m.clock().Now()
 }
 
 func (m *Manager) init() {
@@ -25,6 +54,7 @@ func (m *Manager) init() {
 	m.lastUID = 1
 	m.lastQID = 1
 	m.events = make(chan util.Event, 10) // making buffer size bigger just to be safe
+	m.claims = make(chan pathClaim)
 }
 
 func (m *Manager) Start(req chan util.Request, resps chan util.Response) {
@@ -32,20 +62,66 @@ func (m *Manager) Start(req chan util.Request, resps chan util.Response) {
 	m.init()
 	m.req = req
 	m.resps = resps
-	// start downloading unpaused downloads
 	// load json
-	m.LoadJson()
+	if err := m.LoadJson(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	// start downloading unpaused downloads
+	m.resumeInterrupted()
+	// the windows might have changed while we were away
+	m.checkQueueTimes(m.now())
 	// creating a timer to check stuff on a frequent basis
-	minTimer := time.NewTicker(time.Minute) // ticks every minute
+	minTimer := m.clock().NewTicker(time.Minute) // ticks every minute
+	progressTimer := m.clock().NewTicker(time.Second) // for the progress events
+	quit := m.quitChan()
+	periodicSave := m.clock().NewTicker(PERIODIC_SAVE)
 	// starting the main loop handling events and occasionally checking the whole state of things
 	for {
 		select {
 		case e := <- m.events:
 			m.handleEvent(e)
+			m.markDirty()
+		case c := <- m.claims:
+			m.resolveClaim(c)
+			m.markDirty()
 		case r := <- req:
 			m.answerRequest(r)
-		case <- minTimer.C:
-			m.checkQueueTimes()
+			if r.Type != util.GetDownloads && r.Type != util.GetQueues {
+				m.markDirty()
+			}
+		case <- minTimer.C():
+			m.checkQueueTimes(m.now())
+			m.applyBandwidth(m.now())
+		case <- m.saveDue():
+			m.save()
+		case <- periodicSave.C():
+			// the parts that got done since the last save
+			if m.hasRunningDownloads() {
+				m.markDirty()
+			}
+		case <- progressTimer.C():
+			m.publishProgress()
+		case done := <- quit:
+			m.shutdown()
+			close(done)
+			return
 		}
 	}
 }
+
+// pauses every running download, saves everything and stops the main loop.
+// blocks until that's done. nothing answers requests after this
+func (m *Manager) Shutdown() {
+	done := make(chan struct{})
+	m.quitChan() <- done
+	<-done
+}
+
+func (m *Manager) quitChan() chan chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.quit == nil {
+		m.quit = make(chan chan struct{})
+	}
+	return m.quit
+}
@@ -1,10 +1,13 @@
 package manager
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/placeholder14032/download-manager/internal/download"
@@ -14,11 +17,16 @@ import (
 
 const (
 	CANT_FIND_DL_ERROR = "can't find download with id: %d"
+	CANT_FIND_QUEUE_ERROR = "can't find queue with id: %d"
 	DOWNLOAD_IS_NOT_IN_STATE = "download with id %d is not in state: %s"
 	DOWNLOAD_IS_RUNNING = "download with id %d is still running"
 	DOWNLOADS_ARE_RUNNING = "downloads are running in queueu: %d: can not modify"
 	QUEUE_IS_FULL = "Queue with id %d is full and cant run anymore download until the others are finished"
 	DIRECTORY_DOESNT_EXIST = "directory `%s` doesn't exist choose another one"
+	UNKNOWN_MOVE = "unknown move: %s"
+	FILE_EXISTS = "there is already a file at %s"
+	PATH_IS_TAKEN = "download with id %d already saves to %s"
+	UNKNOWN_TIME_ZONE = "unknown time zone: %s"
 )
 
 func (m *Manager) findQueueIndex(qID int64) int { // maybe can be used to clean up some dublicate code
@@ -53,14 +61,101 @@ func checkParDirExists(filePath string) bool {
 	return checkDirExists(path.Dir(filePath))
 }
 
-func determineFilePath(directory string, url string) string {
+// the name is the one the user gave or else the last piece of the url path.
+// the server can still pick another one when the download starts unless the user gave it
+func determineFilePath(directory string, url string, name string) string {
 	// joins the directory with the filename
 	// if the directory doesn't have the last slash (/) it will usse the parent
 	// because it is seen as a file in that case
-	return path.Join(directory, path.Base(url))
+	// the name never has a separator in it so this can't leave directory.
+	// addDownload checks anyway, with the symlinks
+	if name = download.SanitizeFileName(name); name == "" {
+		name = download.FileNameFromURL(url)
+	}
+	return filepath.Join(directory, name)
 	// changed from Path.Dir(Directory) because it might cause problems with omitting the last folder
 }
 
+// the download other than except that is going to write to p. done and
+// cancelled ones are through with it, what they left is just a file on disk
+func (m *Manager) pathOwner(p string, except int64) *download.Download {
+	for i := range m.qs {
+		for _, dl := range m.qs[i].DownloadLists {
+			if dl.ID == except || dl.Status == download.Done || dl.Status == download.Cancelled {
+				continue
+			}
+			if dl.GetFilePath() == p {
+				return dl
+			}
+		}
+	}
+	return nil
+}
+
+// where a new download saves to so it doesn't get in the way of other
+// downloads or files. overwrite and skip only decide when it's done
+func (m *Manager) claimFilePath(p string, policy download.CollisionPolicy) (string, error) {
+	if policy == download.RenameOnCollision {
+		return download.FreePath(p, func(p string) bool {
+			return m.pathOwner(p, -1) != nil
+		}), nil
+	}
+	if owner := m.pathOwner(p, -1); owner != nil {
+		return "", conflictError(PATH_IS_TAKEN, owner.ID, p)
+	}
+	if policy == download.FailOnCollision && download.PathInUse(p) {
+		return "", conflictError(FILE_EXISTS, p)
+	}
+	return p, nil
+}
+
+// a download that wants to save where the server said
+type pathClaim struct {
+	dl     *download.Download
+	path   string
+	policy download.CollisionPolicy
+	done   chan struct{}
+}
+
+// runs on the download's own goroutine. the main loop decides so that two
+// downloads that start together with the same name don't both get it
+func (m *Manager) claimRemotePath(ctx context.Context, dl *download.Download, p string, policy download.CollisionPolicy) error {
+	c := pathClaim{dl: dl, path: p, policy: policy, done: make(chan struct{})}
+	select {
+	case m.claims <- c:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// on the main loop. the download is moved before anyone can ask again
+func (m *Manager) resolveClaim(c pathClaim) {
+	p := download.RemotePath(c.path, c.policy, func(p string) bool {
+		return m.pathOwner(p, c.dl.ID) != nil
+	})
+	c.dl.Handler.SetFilePath(p)
+	close(c.done)
+}
+
+// two running downloads with the same path would write over each others parts
+func (m *Manager) checkPathFree(dl *download.Download) error {
+	p := dl.GetFilePath()
+	for i := range m.qs {
+		for _, other := range m.qs[i].DownloadLists {
+			if other.ID != dl.ID && checkRunningDL(*other) && other.GetFilePath() == p {
+				return conflictError(PATH_IS_TAKEN, other.ID, p)
+			}
+		}
+	}
+	return nil
+}
+
 // returns the name if it's not empty. otherwise creates an unempty name for it
 func chooseQueueName(name string, id int64) string {
 	if name != "" {
@@ -69,14 +164,19 @@ func chooseQueueName(name string, id int64) string {
 	return fmt.Sprintf("queue [%d]", id)
 }
 
-func createDownload(dlID int64, url string, filePath string, maxRetry int64) download.Download {
+// the download takes its settings from the queue it is created in
+func createDownload(dlID int64, url string, filePath string, q *queue.Queue) download.Download {
 	return download.Download {
 		ID: dlID,
 		URL: url,
 		FilePath: filePath,
-		MaxRetries: maxRetry,
+		MaxRetries: q.MaxRetries,
+		ChunkRetries: q.ChunkRetries,
 		Status: download.Pending,
 		RetryCount: 0,
+		Storage: q.Storage,
+		OnRemoteChange: q.OnRemoteChange,
+		OnCollision: q.OnCollision,
 	}
 }
 
@@ -86,23 +186,45 @@ func convertToStaticQueue(q *queue.Queue) util.QueueBody {
 	return util.QueueBody{
 		ID: q.ID,
 		Directory: q.SaveDir,
+		Name: q.Name,
 		MaxSimul: q.MaxConcurrent,
 		MaxBandWidth: q.MaxBandwidth,
+		BandwidthSchedule: q.BandwidthSchedule,
 		MaxRetries: q.MaxRetries,
+		ChunkRetries: q.ChunkRetries,
 		HasTimeConstraint: q.HasTimeConstraint,
 		TimeRange: q.TimeRange,
+		Windows: q.Windows,
+		TimeZone: q.TimeZone,
+		StartAt: q.StartAt,
+		StopAt: q.StopAt,
+		Storage: q.Storage,
+		OnRemoteChange: q.OnRemoteChange,
+		OnCollision: q.OnCollision,
+		Request: q.Request,
+		Proxy: q.Proxy.Redacted(),
+		TLS: q.TLS,
 	}
 }
 
-func convertToStaticDownload(d *download.Download, q_name string) util.DownloadBody {
+func convertToStaticDownload(d *download.Download, q *queue.Queue) util.DownloadBody {
+	downloaded, size := d.GetBytes()
 	return util.DownloadBody{
 		ID: d.ID,
 		URL: d.URL,
-		FilePath: d.FilePath,
+		FilePath: d.GetFilePath(),
 		Status: d.Status,
 		Progress: d.GetProgress(),
 		Speed: d.GetSpeed(),
-		QueueName: q_name,
+		QueueID: q.ID,
+		QueueName: q.Name,
+		Checksum: d.VerifiedChecksum,
+		FailReason: d.FailReason,
+		Priority: d.Priority,
+		MaxBandwidth: d.MaxBandwidth,
+		Downloaded: downloaded,
+		Size: size,
+		Auth: d.Auth.String(),
 	}
 }
 
@@ -113,31 +235,62 @@ func checkRunningDL(d download.Download) bool {
 func checkRunningDLsInQueue(q queue.Queue) bool {
 	// checks if any downloads are running to stop queue modification
 	for _, dl := range q.DownloadLists {
-		if checkRunningDL(dl) {
+		if checkRunningDL(*dl) {
 			return true 
 		}
 	}
 	return false
 }
 
-func getDownloadStarted(dl *download.Download, echan chan util.Event) {
-	dl.Status = download.Downloading
-	err := dl.Handler.StartDownloading()
+// runs one of the handlers downloading functions and reports how it went.
+// a pause is not reported because whoever paused it already knows
+func runDownload(dl *download.Download, echan chan util.Event, run func() error) {
+	err := run()
+	if errors.Is(err, download.ErrPaused) {
+		return
+	}
 	if err == nil {
 		echan <- util.Event{Type: util.Finished, DownloadID: dl.ID}
 	} else {
-		echan <- util.Event{Type: util.Failed, DownloadID: dl.ID}
+		echan <- util.Event{Type: util.Failed, DownloadID: dl.ID, Reason: err.Error(), Err: err}
 	}
 	// this writing to channel will block the current goroutine
 	// but it's okay because the handler is running in the parent one
 	// and is not blocked
 }
 
-func cleanUp(path string) {
+// the status is set by the main loop before these are started. they
+// run on their own goroutine so they can't touch the download
+func getDownloadStarted(dl *download.Download, echan chan util.Event) {
+	runDownload(dl, echan, dl.Handler.StartDownloading)
+}
+
+// continues a paused download from the parts it already has
+func getDownloadResumed(dl *download.Download, echan chan util.Event) {
+	runDownload(dl, echan, dl.Handler.Resume)
+}
+
+// continues a failed download from the parts it already has
+func getDownloadRetried(dl *download.Download, echan chan util.Event) {
+	runDownload(dl, echan, dl.Handler.Retry)
+}
+
+func cleanUp(dl *download.Download) {
+	if dl.Status == download.Paused {
+		return // a paused download still needs whatever it has on disk to resume
+	}
+	dl.FilePath = dl.GetFilePath()
+	if dl.Storage == download.Preallocated {
+		// the partially written file is the only thing there is
+		if err := os.Remove(dl.FilePath); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Warning: failed to remove partial file %s: %v\n", dl.FilePath, err)
+		}
+		return
+	}
 	// works like the part combiner
-	partFiles, err := filepath.Glob(fmt.Sprintf("%s.part*", path))
+	partFiles, err := filepath.Glob(fmt.Sprintf("%s.part*", dl.FilePath))
 	if err != nil {
-		fmt.Println("cant find parts to clean up for ", path)
+		fmt.Println("cant find parts to clean up for ", dl.FilePath)
 		return
 	}
 	for _, file := range partFiles {
@@ -147,39 +300,81 @@ func cleanUp(path string) {
 	}
 }
 
-func checkTimeInRange(start, end, check time.Time) bool {
-	return check.After(start) && check.Before(end)
-}
-
-func checkNowInRange(start, end time.Time) bool {
-	now, _ := time.Parse(time.TimeOnly, time.Now().Format(time.TimeOnly))
-	return checkTimeInRange(start, end, now)
-}
-
-func (m *Manager) addDownload(qID int64, url string) error {
-	i := m.findQueueIndex(qID)
+// returns the id of the new download
+func (m *Manager) addDownload(body util.BodyAddDownload) (int64, error) {
+	i := m.findQueueIndex(body.QueueID)
 	if i == -1 {
-		return fmt.Errorf("Bad queue id: %d", qID)
+		return 0, notFoundError(CANT_FIND_QUEUE_ERROR, body.QueueID)
 	}
-	dl := createDownload(m.lastUID, url, determineFilePath(m.qs[i].SaveDir, url), m.qs[i].MaxRetries)
+	sum, err := download.ParseChecksum(body.Checksum)
+	if err != nil {
+		return 0, err
+	}
+	filePath, err := m.claimFilePath(determineFilePath(m.qs[i].SaveDir, body.URL, body.FileName), m.qs[i].OnCollision)
+	if err != nil {
+		return 0, err
+	}
+	if err := download.CheckInDir(m.qs[i].SaveDir, filePath); err != nil {
+		return 0, err
+	}
+	dl := createDownload(m.lastUID, body.URL, filePath, &m.qs[i])
+	dl.FixedName = download.SanitizeFileName(body.FileName) != ""
+	dl.Checksum = sum
+	dl.Priority = body.Priority
+	dl.Request = body.Request
+	dl.Auth = body.Auth
+	dl.Position = dl.ID // ids only go up so new ones end up last
 	download.CreateDefaultHandler(&dl)
 	m.lastUID++
-	m.qs[i].DownloadLists = append(m.qs[i].DownloadLists, dl)
-	return nil
+	m.qs[i].DownloadLists = append(m.qs[i].DownloadLists, &dl)
+	return dl.ID, nil
+}
+
+// everything a download needs from us right before its workers start
+func (m *Manager) prepareRun(dl *download.Download, i int) {
+	m.limitDownload(dl, i)
+	dl.Handler.SetRequestOptions(m.qs[i].Request.With(dl.Request))
+	dl.Handler.SetProxy(m.qs[i].Proxy)
+	dl.Handler.SetTLS(m.qs[i].TLS)
+	// the server can rename the file but it stays in the queue's directory.
+	// or where it is, if the queue went somewhere else without it
+	root := m.qs[i].SaveDir
+	if p := dl.GetFilePath(); download.CheckInDir(root, p) != nil {
+		root = filepath.Dir(p)
+	}
+	dl.Handler.SetRoot(root)
+	m.authorize(dl)
+	if dl.Handler.Clock != m.clock() {
+		// only the first time. later the workers of the last run might be reading it
+		dl.Handler.Clock = m.clock()
+	}
+	if dl.Handler.ClaimPath == nil {
+		// same, a paused run can still be on its way out
+		dl.Handler.ClaimPath = func(ctx context.Context, p string, policy download.CollisionPolicy) error {
+			return m.claimRemotePath(ctx, dl, p, policy)
+		}
+	}
+	dl.Handler.Progress.SetClock(m.clock())
 }
 
 func (m *Manager) startDownload(dlID int64) error {
 	i, j := m.findDownloadQueueIndex(dlID)
 	if i == -1 || j == -1 {
-		return fmt.Errorf(CANT_FIND_DL_ERROR, dlID)
+		return notFoundError(CANT_FIND_DL_ERROR, dlID)
 	}
-	dl := &m.qs[i].DownloadLists[j] // pointer to the real download
+	dl := m.qs[i].DownloadLists[j] // pointer to the real download
 	if dl.Status != download.Pending {
-		return fmt.Errorf(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Pending")
+		return conflictError(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Pending")
 	}
 	if !m.qs[i].IsSafeToRunDL() {
-		return fmt.Errorf(QUEUE_IS_FULL, m.qs[i].ID)
+		return conflictError(QUEUE_IS_FULL, m.qs[i].ID)
 	}
+	if err := m.checkPathFree(dl); err != nil {
+		return err
+	}
+	dl.Status = download.Downloading
+	m.publish(util.Started, dl, "")
+	m.prepareRun(dl, i)
 	go getDownloadStarted(dl, m.events)
 	return nil
 }
@@ -187,71 +382,202 @@ func (m *Manager) startDownload(dlID int64) error {
 func (m *Manager) pauseDownload(dlID int64) error {
 	i, j := m.findDownloadQueueIndex(dlID)
 	if i == -1 || j == -1 {
-		return fmt.Errorf(CANT_FIND_DL_ERROR, dlID)
+		return notFoundError(CANT_FIND_DL_ERROR, dlID)
 	}
-	dl := &m.qs[i].DownloadLists[j] // pointer to the real download
+	dl := m.qs[i].DownloadLists[j] // pointer to the real download
 	if dl.Status != download.Downloading {
-		return fmt.Errorf(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Downloading")
+		return conflictError(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Downloading")
 	}
 	dl.Handler.Pause()
 	dl.Status = download.Paused
+	m.publish(util.Pausing, dl, "")
 	return nil
 }
 
 func (m *Manager) resumeDownload(dlID int64) error {
 	i, j := m.findDownloadQueueIndex(dlID)
 	if i == -1 || j == -1 {
-		return fmt.Errorf(CANT_FIND_DL_ERROR, dlID)
+		return notFoundError(CANT_FIND_DL_ERROR, dlID)
 	}
-	dl := &m.qs[i].DownloadLists[j] // pointer to the real download
+	dl := m.qs[i].DownloadLists[j] // pointer to the real download
 	if dl.Status != download.Paused {
-		return fmt.Errorf(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Downloading")
+		return conflictError(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Downloading")
 	}
 	if !m.qs[i].IsSafeToRunDL() {
-		return fmt.Errorf(QUEUE_IS_FULL, m.qs[i].ID)
+		return conflictError(QUEUE_IS_FULL, m.qs[i].ID)
 	}
-	err := dl.Handler.Resume() // returns error or nil
-	if err == nil {
-		dl.Status = download.Downloading
-	} else {
-		fmt.Println("cant resume", err)
-		dl.Status = download.Failed
+	if err := m.checkPathFree(dl); err != nil {
+		return err
 	}
-	return err
+	dl.Status = download.Downloading
+	m.publish(util.Resuming, dl, "")
+	m.prepareRun(dl, i)
+	go getDownloadResumed(dl, m.events) // failures come back as events like with starting
 	return nil
 }
 
 func (m *Manager) retryDownload(dlID int64) error {
 	i, j := m.findDownloadQueueIndex(dlID)
 	if i == -1 || j == -1 {
-		return fmt.Errorf(CANT_FIND_DL_ERROR, dlID)
+		return notFoundError(CANT_FIND_DL_ERROR, dlID)
 	}
-	dl := &m.qs[i].DownloadLists[j] // not a copy
+	dl := m.qs[i].DownloadLists[j] // not a copy
 	if dl.Status != download.Cancelled && dl.Status != download.Failed {
-		return fmt.Errorf(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Cancelled or Failed")
+		return conflictError(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Cancelled or Failed")
 	}
 	if !m.qs[i].IsSafeToRunDL() {
-		return fmt.Errorf(QUEUE_IS_FULL, m.qs[i].ID)
+		return conflictError(QUEUE_IS_FULL, m.qs[i].ID)
+	}
+	if err := m.checkPathFree(dl); err != nil {
+		return err
+	}
+	if dl.Status == download.Failed {
+		// the parts of a failed download are still good. go on from them
+		dl.Status = download.Retrying
+		m.publish(util.Started, dl, "")
+		m.prepareRun(dl, i)
+		dl.Status = download.Downloading
+		go getDownloadRetried(dl, m.events)
+		return nil
 	}
 	dl.Status = download.Retrying // temporary status to stop other threads from meddling with this one even though there might not be any other threads probably
 	dl.Handler.Pause() // effectively this should kill all the workers because. also if there are non just ignore the returned error
-	cleanUp(dl.FilePath) // cleans residual part files
+	cleanUp(dl) // cleans residual part files
 	download.CreateDefaultHandler(dl)
+	m.publish(util.Started, dl, "")
+	m.prepareRun(dl, i)
+	dl.Status = download.Downloading
 	go getDownloadStarted(dl, m.events)
 	return nil
 }
 
+// moves the download inside the order of its queue. it takes the priority
+// of the one it passes, otherwise it would just sort back to where it was
+func (m *Manager) reorderDownload(dlID int64, move util.Move) error {
+	i, j := m.findDownloadQueueIndex(dlID)
+	if i == -1 || j == -1 {
+		return notFoundError(CANT_FIND_DL_ERROR, dlID)
+	}
+	q := &m.qs[i]
+	order := q.Order()
+	from := slices.Index(order, j)
+	to := from
+	switch move {
+	case util.MoveUp:
+		to = max(from-1, 0)
+	case util.MoveDown:
+		to = min(from+1, len(order)-1)
+	case util.MoveTop:
+		to = 0
+	case util.MoveBottom:
+		to = len(order) - 1
+	default:
+		return fmt.Errorf(UNKNOWN_MOVE, move)
+	}
+	if to == from {
+		return nil
+	}
+	dl := q.DownloadLists[j] // not a copy
+	dl.Priority = q.DownloadLists[order[to]].Priority
+	order = slices.Insert(slices.Delete(order, from, from+1), to, j)
+	// only the positions change, the downloads stay where they are in memory
+	// because the running ones are pointed at
+	for pos, k := range order {
+		q.DownloadLists[k].Position = int64(pos)
+	}
+	return nil
+}
+
+func (m *Manager) setPriority(dlID int64, priority int64) error {
+	i, j := m.findDownloadQueueIndex(dlID)
+	if i == -1 || j == -1 {
+		return notFoundError(CANT_FIND_DL_ERROR, dlID)
+	}
+	m.qs[i].DownloadLists[j].Priority = priority
+	return nil
+}
+
+// puts the download at the end of another queue and gives it the settings
+// of that queue. running ones have to be paused first. with moveFiles
+// whatever is on disk goes along to the directory of the new queue,
+// otherwise the download keeps writing where it did before
+func (m *Manager) moveDownload(dlID int64, qID int64, moveFiles bool) error {
+	i, j := m.findDownloadQueueIndex(dlID)
+	if i == -1 || j == -1 {
+		return notFoundError(CANT_FIND_DL_ERROR, dlID)
+	}
+	to := m.findQueueIndex(qID)
+	if to == -1 {
+		return notFoundError(CANT_FIND_QUEUE_ERROR, qID)
+	}
+	dl := m.qs[i].DownloadLists[j] // not a copy
+	switch dl.Status {
+	case download.Pending, download.Paused, download.Failed, download.Done:
+	default:
+		return conflictError(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Pending, Paused, Failed or Done")
+	}
+	if to == i {
+		return nil
+	}
+	target := &m.qs[to]
+
+	// nothing is on disk yet for a pending one so it can always go to the new directory
+	if moveFiles || dl.Status == download.Pending {
+		dl.Handler.Wait() // the workers of a paused one might still be writing
+		dl.FilePath = dl.GetFilePath()
+		newPath := filepath.Join(target.SaveDir, filepath.Base(dl.FilePath))
+		if owner := m.pathOwner(newPath, dl.ID); owner != nil {
+			return conflictError(PATH_IS_TAKEN, owner.ID, newPath)
+		}
+		if err := download.CheckInDir(target.SaveDir, newPath); err != nil {
+			return err
+		}
+		if newPath != dl.FilePath {
+			if err := relocateFiles(dl, newPath); err != nil {
+				return err
+			}
+			dl.FilePath = newPath
+			dl.Handler.FilePath = newPath
+		}
+	}
+
+	dl.MaxRetries = target.MaxRetries
+	dl.ChunkRetries = target.ChunkRetries
+	dl.Handler.ChunkRetry = download.NewRetryPolicy(dl.ChunkRetries)
+	dl.OnRemoteChange = target.OnRemoteChange
+	dl.Handler.OnRemoteChange = dl.OnRemoteChange
+	dl.OnCollision = target.OnCollision
+	dl.Handler.OnCollision = dl.OnCollision
+	if dl.Status == download.Pending {
+		// the others already have their data laid out one way or the other
+		dl.Storage = target.Storage
+		dl.Handler.Storage = dl.Storage
+	}
+	if dl.Status == download.Failed {
+		dl.RetryCount = 0 // a new queue, a new set of retries
+	}
+
+	dl.Position = 0
+	for _, other := range target.DownloadLists {
+		dl.Position = max(dl.Position, other.Position+1)
+	}
+	m.qs[i].DownloadLists = util.Remove(m.qs[i].DownloadLists, j)
+	target.DownloadLists = append(target.DownloadLists, dl)
+	return nil
+}
+
 func (m *Manager) cancelDownload(dlID int64) error {
 	i, j := m.findDownloadQueueIndex(dlID)
 	if i == -1 || j == -1 {
-		return fmt.Errorf(CANT_FIND_DL_ERROR, dlID)
+		return notFoundError(CANT_FIND_DL_ERROR, dlID)
 	}
-	dl := &m.qs[i].DownloadLists[j] // not a copy
+	dl := m.qs[i].DownloadLists[j] // not a copy
 	if dl.Status != download.Downloading {
-		return fmt.Errorf(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Downloading")
+		return conflictError(DOWNLOAD_IS_NOT_IN_STATE, dlID, "Downloading")
 	}
 	dl.Handler.Pause()
-	cleanUp(dl.FilePath)
+	dl.Handler.Wait() // so the workers don't write anything after we clean up
+	cleanUp(dl)
 	download.CreateDefaultHandler(dl)
 	dl.Status = download.Cancelled
 	return nil
@@ -260,11 +586,11 @@ func (m *Manager) cancelDownload(dlID int64) error {
 func (m *Manager) deleteDownload(dlID int64) error {
 	i, j := m.findDownloadQueueIndex(dlID)
 	if i == -1 || j == -1 {
-		return fmt.Errorf(CANT_FIND_DL_ERROR, dlID)
+		return notFoundError(CANT_FIND_DL_ERROR, dlID)
 	}
-	dl := &m.qs[i].DownloadLists[j] // not a copy
+	dl := m.qs[i].DownloadLists[j] // not a copy
 	if checkRunningDL(*dl) {
-		return fmt.Errorf(DOWNLOAD_IS_RUNNING, dl.ID)
+		return conflictError(DOWNLOAD_IS_RUNNING, dl.ID)
 	}
 	m.qs[i].DownloadLists = util.Remove(m.qs[i].DownloadLists, j)
 	return nil
@@ -272,60 +598,141 @@ func (m *Manager) deleteDownload(dlID int64) error {
 
 // gets the settings from a body
 // the id will be ignored so it should probably be -1
-func (m *Manager) addQueue(body util.QueueBody) error {
+// returns the id of the new queue
+func (m *Manager) addQueue(body util.QueueBody) (int64, error) {
 	if !checkDirExists(body.Directory) {
-		return fmt.Errorf(DIRECTORY_DOESNT_EXIST, body.Directory)
+		return 0, fmt.Errorf(DIRECTORY_DOESNT_EXIST, body.Directory)
+	}
+	if err := checkTimeZone(body.TimeZone); err != nil {
+		return 0, err
+	}
+	if err := body.Proxy.Validate(); err != nil {
+		return 0, err
+	}
+	if err := body.TLS.Validate(); err != nil {
+		return 0, err
 	}
 	q := queue.Queue{
 		ID: m.lastQID,
 		Name: chooseQueueName(body.Name, m.lastQID),
-		DownloadLists: make([]download.Download, 0),
+		DownloadLists: make([]*download.Download, 0),
 		SaveDir: body.Directory,
 		MaxConcurrent: body.MaxSimul,
 		MaxBandwidth: body.MaxBandWidth,
+		BandwidthSchedule: body.BandwidthSchedule,
 		MaxRetries: body.MaxRetries,
+		ChunkRetries: body.ChunkRetries,
 		HasTimeConstraint: body.HasTimeConstraint,
 		TimeRange: body.TimeRange,
+		Windows: body.Windows,
+		TimeZone: body.TimeZone,
+		StartAt: body.StartAt,
+		StopAt: body.StopAt,
+		Storage: body.Storage,
+		OnRemoteChange: body.OnRemoteChange,
+		OnCollision: body.OnCollision,
+		Request: body.Request,
+		Proxy: body.Proxy,
+		TLS: body.TLS,
 		Disabled: false,
 	}
 	m.lastQID++
 	m.qs = append(m.qs, q)
-	return nil
+	m.checkQueueTimes(m.now())
+	return q.ID, nil
 }
 
 func (m *Manager) editQueue(body util.QueueBody) error {
 	if !checkDirExists(body.Directory) {
 		return fmt.Errorf(DIRECTORY_DOESNT_EXIST, body.Directory)
 	}
+	if err := checkTimeZone(body.TimeZone); err != nil {
+		return err
+	}
+	if err := body.Proxy.Validate(); err != nil {
+		return err
+	}
+	if err := body.TLS.Validate(); err != nil {
+		return err
+	}
 	qid := body.ID;
 	i := m.findQueueIndex(qid)
+	if i == -1 {
+		return notFoundError(CANT_FIND_QUEUE_ERROR, qid)
+	}
 	if checkRunningDLsInQueue(m.qs[i]) {
-		return fmt.Errorf(DOWNLOADS_ARE_RUNNING, qid)
+		return conflictError(DOWNLOADS_ARE_RUNNING, qid)
+	}
+	if body.Proxy.URL == m.qs[i].Proxy.Redacted().URL {
+		// it's what we showed them, the password is still the old one
+		body.Proxy.URL = m.qs[i].Proxy.URL
 	}
 	m.qs[i].SaveDir = body.Directory
 	m.qs[i].Name = body.Name
 	m.qs[i].MaxConcurrent = body.MaxSimul
 	m.qs[i].MaxBandwidth = body.MaxBandWidth
+	m.qs[i].BandwidthSchedule = body.BandwidthSchedule
 	m.qs[i].MaxRetries = body.MaxRetries
+	m.qs[i].ChunkRetries = body.ChunkRetries
 	m.qs[i].HasTimeConstraint = body.HasTimeConstraint
 	m.qs[i].TimeRange = body.TimeRange
+	m.qs[i].Windows = body.Windows
+	m.qs[i].TimeZone = body.TimeZone
+	m.qs[i].StartAt = body.StartAt
+	m.qs[i].StopAt = body.StopAt
+	m.qs[i].Storage = body.Storage // only affects downloads added from now on
+	m.qs[i].OnRemoteChange = body.OnRemoteChange
+	m.qs[i].OnCollision = body.OnCollision
+	m.qs[i].Request = body.Request // the running downloads get it the next time they start
+	m.qs[i].Proxy = body.Proxy // same
+	m.qs[i].TLS = body.TLS
+	m.applyBandwidth(m.now())
+	m.checkQueueTimes(m.now())
 	return nil
 }
 
 func (m *Manager) delQueue(body util.QueueBody) error {
 	qid := body.ID;
 	i := m.findQueueIndex(qid)
+	if i == -1 {
+		return notFoundError(CANT_FIND_QUEUE_ERROR, qid)
+	}
 	if checkRunningDLsInQueue(m.qs[i]) {
-		return fmt.Errorf(DOWNLOADS_ARE_RUNNING, qid)
+		return conflictError(DOWNLOADS_ARE_RUNNING, qid)
 	}
 	m.qs = util.Remove(m.qs, i)
+	delete(m.queueBuckets, qid)
 	return nil
 }
 
+// runs on the main loop when shutting down. the running downloads are
+// stopped but saved as running so they go on by themselves next time
+func (m *Manager) shutdown() {
+	for i := range m.qs {
+		for j := range m.qs[i].DownloadLists {
+			dl := m.qs[i].DownloadLists[j]
+			if dl.Status != download.Downloading && dl.Status != download.Retrying {
+				continue
+			}
+			dl.Handler.Pause()
+			dl.Handler.Wait()
+		}
+	}
+	if m.saveTimer != nil {
+		m.saveTimer.Stop()
+	}
+	m.save()
+	m.closeSubscribers()
+}
+
 func (m *Manager) answerBadRequest(msg string) {
+	m.answerFailure(util.BadRequest, msg)
+}
+
+func (m *Manager) answerFailure(kind util.FailureKind, msg string) {
 	resp := util.Response {
 		Type: util.FAIL,
-		Body: util.FailureMessage{Message: msg},
+		Body: util.FailureMessage{Message: msg, Kind: kind},
 	}
 	m.resps <- resp
 }
@@ -335,48 +742,48 @@ func (m *Manager) answerOKRequest() {
 	m.resps <- resp
 }
 
+// like answerOKRequest but sends something back
+func (m *Manager) answerOKWithBody(body any) {
+	resp := util.Response{Type: util.OK, Body: body}
+	m.resps <- resp
+}
+
 func (m *Manager) disableQueue(idx int) {
+	m.markDirty()
 	m.qs[idx].Disabled = true
-	fmt.Println("disabling queue", m.qs[idx].ID, time.Now())
+	fmt.Println("disabling queue", m.qs[idx].ID, m.now())
 	for _, dl := range m.qs[idx].DownloadLists {
 		m.pauseDownload(dl.ID) // this is O(n^2) but at this point I dont really care
 	}
 }
 
 func (m *Manager) enableQueue(idx int) {
+	m.markDirty()
 	m.qs[idx].Disabled = false
-	q := &m.qs[idx]
-	fmt.Println("enabling queue", q.ID, time.Now())
-	ln := len(q.DownloadLists)
-	mx := q.MaxConcurrent
-	if mx == 0 {
-		mx = int64(ln)
-	}
-	ecnt := int64(0)
-	for i := 0; ecnt < mx && i < ln; i++ {
-		dl := &q.DownloadLists[i]
-		if dl.Status == download.Pending {
-			m.startDownload(dl.ID)
-		}
-		if dl.Status == download.Paused {
-			m.resumeDownload(dl.ID)
-		}
-	}
+	fmt.Println("enabling queue", m.qs[idx].ID, m.now())
+	m.runNext(idx)
 }
 
-func (m *Manager) checkQueueTimes() {
+// turns the queues on and off by their windows and start/stop times
+func (m *Manager) checkQueueTimes(now time.Time) {
 	for i := range m.qs {
 		q := &m.qs[i]
-		if !q.HasTimeConstraint {
+		if !q.HasSchedule() {
 			continue
 		}
-		inRange := checkNowInRange(q.TimeRange.Start, q.TimeRange.End)
-		//
-		if inRange && q.Disabled {
+		open := q.IsOpen(now)
+		if open && q.Disabled {
 			m.enableQueue(i)
-		} else if !inRange && !q.Disabled {
+		} else if !open && !q.Disabled {
 			m.disableQueue(i)
 		}
 	}
 }
 
+func checkTimeZone(name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf(UNKNOWN_TIME_ZONE, name)
+	}
+	return nil
+}
+
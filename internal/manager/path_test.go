@@ -0,0 +1,39 @@
+package manager
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/placeholder14032/download-manager/internal/download"
+)
+
+// nothing from the url or the user gets the file out of the directory
+func TestDetermineFilePathStaysInside(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		url  string
+		name string
+	}{
+		{"http://example.com/a/../../../etc/passwd", ""},
+		{"http://example.com/..%2F..%2Fetc%2Fpasswd", ""},
+		{"http://example.com/%2e%2e%2f%2e%2e%2fevil", ""},
+		{"http://example.com/..%5C..%5Cevil.exe", ""},
+		{"http://example.com/..", ""},
+		{"http://example.com/%2e%2e", ""},
+		{"http://example.com/file", "../../evil"},
+		{"http://example.com/file", "/etc/passwd"},
+		{"http://example.com/file", `..\..\evil`},
+		{"http://example.com/file", "C:/Windows/evil.dll"},
+		{"http://example.com/file", ".."},
+		{"http://example.com/file", "%2e%2e%2fevil"},
+	}
+	for _, tt := range tests {
+		p := determineFilePath(dir, tt.url, tt.name)
+		if filepath.Dir(p) != dir {
+			t.Errorf("%q, %q: saved in %s", tt.url, tt.name, filepath.Dir(p))
+		}
+		if err := download.CheckInDir(dir, p); err != nil {
+			t.Errorf("%q, %q: %v", tt.url, tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/placeholder14032/download-manager/internal/download"
+)
+
+// moves whatever the download has on disk so it sits at newPath instead of
+// dl.FilePath: the finished file, the preallocated one or the part files
+func relocateFiles(dl *download.Download, newPath string) error {
+	if !checkParDirExists(newPath) {
+		return fmt.Errorf(DIRECTORY_DOESNT_EXIST, filepath.Dir(newPath))
+	}
+	moves := make(map[string]string)
+	if _, err := os.Stat(dl.FilePath); err == nil {
+		moves[dl.FilePath] = newPath
+	}
+	for _, part := range download.PartFilesOf(dl.FilePath) {
+		moves[part] = newPath + strings.TrimPrefix(part, dl.FilePath)
+	}
+
+	// someone else's file is never overwritten
+	for _, to := range moves {
+		if _, err := os.Stat(to); err == nil {
+			return conflictError(FILE_EXISTS, to)
+		}
+	}
+
+	done := make(map[string]string)
+	for from, to := range moves {
+		if err := moveFile(from, to); err != nil {
+			// put back what already moved so the download stays usable where it was
+			for back, there := range done {
+				moveFile(there, back)
+			}
+			return fmt.Errorf("failed to move %s to %s: %v", from, to, err)
+		}
+		done[from] = to
+	}
+	return nil
+}
+
+// a rename, or a copy when the two are on different file systems
+func moveFile(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
@@ -0,0 +1,32 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/placeholder14032/download-manager/internal/download"
+)
+
+// a name with brackets is just a name, not a pattern that takes the parts
+// of other downloads along
+func TestRelocateBrackets(t *testing.T) {
+	from, to := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a[1].bin.part0", "a[1].bin.part1", "a1.bin.part0", "b[.bin.part0"} {
+		os.WriteFile(filepath.Join(from, name), []byte(name), 0644)
+	}
+	for _, name := range []string{"a[1].bin", "b[.bin"} {
+		dl := &download.Download{FilePath: filepath.Join(from, name)}
+		if err := relocateFiles(dl, filepath.Join(to, name)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"a[1].bin.part0", "a[1].bin.part1", "b[.bin.part0"} {
+		if _, err := os.Stat(filepath.Join(to, name)); err != nil {
+			t.Errorf("%s wasn't moved: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(from, "a1.bin.part0")); err != nil {
+		t.Errorf("the part of another download was moved: %v", err)
+	}
+}
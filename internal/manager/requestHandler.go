@@ -14,7 +14,7 @@ func (m *Manager) answerERR(err error) {
 	if err == nil {
 		m.answerOKRequest()
 	} else {
-		m.answerBadRequest(err.Error())
+		m.answerFailure(failureKind(err), err.Error())
 	}
 }
 
@@ -24,8 +24,14 @@ func (m *Manager) answerAddDL(r util.Request) {
 		m.answerBadRequest(fmt.Sprintf(BAD_REQ_BODY_TYPE, "Add Download", "BodyAddDownload"))
 		return
 	}
-	err := m.addDownload(body.QueueID, body.URL)
-	m.answerERR(err)
+	id, err := m.addDownload(body)
+	if err != nil {
+		m.answerERR(err)
+		return
+	}
+	// sending back the new download so the caller knows its id
+	i, j := m.findDownloadQueueIndex(id)
+	m.answerOKWithBody(convertToStaticDownload(m.qs[i].DownloadLists[j], &m.qs[i]))
 }
 
 func (m *Manager) answerStartDL(r util.Request) {
@@ -114,8 +120,8 @@ func (m *Manager) answerGetDLS(r util.Request) {
 	}
 	i := 0
 	for _, q := range m.qs {
-		for _, d := range q.DownloadLists {
-			body.Downloads[i] = convertToStaticDownload(&d, q.Name)
+		for _, k := range q.Order() {
+			body.Downloads[i] = convertToStaticDownload(q.DownloadLists[k], &q)
 			i++
 		}
 	}
@@ -132,8 +138,12 @@ func (m *Manager) answerAddQ(r util.Request) {
 		m.answerBadRequest(fmt.Sprintf(BAD_REQ_BODY_TYPE, "AddQueue", "QueueBody"))
 		return
 	}
-	err := m.addQueue(body)
-	m.answerERR(err)
+	id, err := m.addQueue(body)
+	if err != nil {
+		m.answerERR(err)
+		return
+	}
+	m.answerOKWithBody(convertToStaticQueue(&m.qs[m.findQueueIndex(id)]))
 }
 
 func (m *Manager) answerEditQ(r util.Request) {
@@ -156,6 +166,56 @@ func (m *Manager) answerDelQ(r util.Request) {
 	m.answerERR(err)
 }
 
+func (m *Manager) answerReorderDL(r util.Request) {
+	body, ok := r.Body.(util.BodyReorderDownload)
+	if !ok {
+		m.answerBadRequest(fmt.Sprintf(BAD_REQ_BODY_TYPE, "Reorder Download", "BodyReorderDownload"))
+		return
+	}
+	err := m.reorderDownload(body.ID, body.Move)
+	m.answerERR(err)
+}
+
+func (m *Manager) answerSetPriority(r util.Request) {
+	body, ok := r.Body.(util.BodySetPriority)
+	if !ok {
+		m.answerBadRequest(fmt.Sprintf(BAD_REQ_BODY_TYPE, "Set Priority", "BodySetPriority"))
+		return
+	}
+	err := m.setPriority(body.ID, body.Priority)
+	m.answerERR(err)
+}
+
+func (m *Manager) answerMoveDL(r util.Request) {
+	body, ok := r.Body.(util.BodyMoveDownload)
+	if !ok {
+		m.answerBadRequest(fmt.Sprintf(BAD_REQ_BODY_TYPE, "Move Download", "BodyMoveDownload"))
+		return
+	}
+	err := m.moveDownload(body.ID, body.QueueID, body.MoveFiles)
+	m.answerERR(err)
+}
+
+func (m *Manager) answerSetBandwidth(r util.Request) {
+	body, ok := r.Body.(util.BodySetBandwidth)
+	if !ok {
+		m.answerBadRequest(fmt.Sprintf(BAD_REQ_BODY_TYPE, "Set Bandwidth", "BodySetBandwidth"))
+		return
+	}
+	err := m.setBandwidth(body)
+	m.answerERR(err)
+}
+
+func (m *Manager) answerSetBandwidthSchedule(r util.Request) {
+	body, ok := r.Body.(util.BodySetBandwidthSchedule)
+	if !ok {
+		m.answerBadRequest(fmt.Sprintf(BAD_REQ_BODY_TYPE, "Set Bandwidth Schedule", "BodySetBandwidthSchedule"))
+		return
+	}
+	err := m.setBandwidthSchedule(body)
+	m.answerERR(err)
+}
+
 func (m *Manager) answerRequest(r util.Request) {
 	switch r.Type {
 	case util.AddDownload:
@@ -184,6 +244,17 @@ func (m *Manager) answerRequest(r util.Request) {
 		m.answerGetDLS(r)
 	case util.GetQueues:
 		m.answerGetQueues(r)
+	//
+	case util.ReorderDownload:
+		m.answerReorderDL(r)
+	case util.SetPriority:
+		m.answerSetPriority(r)
+	case util.MoveDownload:
+		m.answerMoveDL(r)
+	case util.SetBandwidth:
+		m.answerSetBandwidth(r)
+	case util.SetBandwidthSchedule:
+		m.answerSetBandwidthSchedule(r)
 	default:
 		panic(fmt.Sprintf("unexpected util.RequestType: %#v", r.Type))
 	}
@@ -0,0 +1,160 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/placeholder14032/download-manager/internal/clock"
+	"github.com/placeholder14032/download-manager/internal/queue"
+	"github.com/placeholder14032/download-manager/internal/util"
+)
+
+// a friday, noon
+var epoch = time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+
+func newTestManager(t *testing.T) (*Manager, *clock.Fake) {
+	t.Helper()
+	f := clock.NewFake(epoch)
+	m := &Manager{Ephemeral: true, Clock: f}
+	m.init()
+	return m, f
+}
+
+func mustWindow(t *testing.T, s string) queue.Window {
+	t.Helper()
+	w, err := queue.ParseWindow(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func mustAddQueue(t *testing.T, m *Manager, body util.QueueBody) int {
+	t.Helper()
+	body.Directory = t.TempDir()
+	id, err := m.addQueue(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.findQueueIndex(id)
+}
+
+// moves the clock and does what the main loop does every minute
+func tick(m *Manager, f *clock.Fake, d time.Duration) {
+	f.Advance(d)
+	m.checkQueueTimes(m.now())
+	m.applyBandwidth(m.now())
+}
+
+func TestQueueWindowOpensAndCloses(t *testing.T) {
+	m, f := newTestManager(t)
+	i := mustAddQueue(t, m, util.QueueBody{Windows: []queue.Window{mustWindow(t, "mon-fri 09:00-17:00")}})
+	if m.qs[i].Disabled {
+		t.Fatal("queue is off inside its window")
+	}
+	tick(m, f, 5*time.Hour) // friday 17:00
+	if !m.qs[i].Disabled {
+		t.Fatal("queue is still on after its window")
+	}
+	tick(m, f, 24*time.Hour) // saturday
+	if !m.qs[i].Disabled {
+		t.Fatal("queue came on during the weekend")
+	}
+	tick(m, f, 40*time.Hour) // monday 09:00
+	if m.qs[i].Disabled {
+		t.Fatal("queue didn't come back on monday")
+	}
+}
+
+func TestQueueOvernightWindow(t *testing.T) {
+	m, f := newTestManager(t)
+	i := mustAddQueue(t, m, util.QueueBody{Windows: []queue.Window{mustWindow(t, "22:00-06:00")}})
+	if !m.qs[i].Disabled {
+		t.Fatal("queue is on outside its window")
+	}
+	tick(m, f, 10*time.Hour) // 22:00
+	if m.qs[i].Disabled {
+		t.Fatal("queue didn't come on at 22:00")
+	}
+	tick(m, f, 7*time.Hour+59*time.Minute) // 05:59 the next day
+	if m.qs[i].Disabled {
+		t.Fatal("queue went off before 06:00")
+	}
+	tick(m, f, time.Minute)
+	if !m.qs[i].Disabled {
+		t.Fatal("queue is still on at 06:00")
+	}
+}
+
+func TestQueueStartStopAt(t *testing.T) {
+	m, f := newTestManager(t)
+	i := mustAddQueue(t, m, util.QueueBody{
+		StartAt: epoch.Add(time.Hour),
+		StopAt:  epoch.Add(3 * time.Hour),
+	})
+	if !m.qs[i].Disabled {
+		t.Fatal("queue is on before its start time")
+	}
+	tick(m, f, time.Hour)
+	if m.qs[i].Disabled {
+		t.Fatal("queue didn't start")
+	}
+	tick(m, f, 2*time.Hour)
+	if !m.qs[i].Disabled {
+		t.Fatal("queue didn't stop")
+	}
+}
+
+func TestQueueTimeZone(t *testing.T) {
+	m, f := newTestManager(t)
+	// noon in utc is 21:00 in tokyo
+	i := mustAddQueue(t, m, util.QueueBody{
+		Windows:  []queue.Window{mustWindow(t, "09:00-17:00")},
+		TimeZone: "Asia/Tokyo",
+	})
+	if !m.qs[i].Disabled {
+		t.Fatal("queue is on outside its window in its own time zone")
+	}
+	tick(m, f, 12*time.Hour) // 09:00 in tokyo
+	if m.qs[i].Disabled {
+		t.Fatal("queue didn't come on at 09:00 in tokyo")
+	}
+
+	if _, err := m.addQueue(util.QueueBody{Directory: t.TempDir(), TimeZone: "Nowhere/Special"}); err == nil {
+		t.Error("added a queue with an unknown time zone")
+	}
+}
+
+func TestBandwidthSchedule(t *testing.T) {
+	m, f := newTestManager(t)
+	if err := m.setBandwidth(util.BodySetBandwidth{Scope: util.ScopeGlobal, Limit: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	err := m.setBandwidthSchedule(util.BodySetBandwidthSchedule{
+		Scope: util.ScopeGlobal,
+		Rules: []queue.BandwidthRule{{Window: mustWindow(t, "12:00-13:00"), Limit: 64 << 10}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := mustAddQueue(t, m, util.QueueBody{
+		MaxBandWidth:      512 << 10,
+		BandwidthSchedule: []queue.BandwidthRule{{Window: mustWindow(t, "sat-sun 00:00-00:00"), Limit: 0}},
+	})
+	qb := m.queueBucket(i)
+
+	if got := m.globalBucket().Rate(); got != 64<<10 {
+		t.Errorf("global limit inside the rule is %d, want %d", got, 64<<10)
+	}
+	if got := qb.Rate(); got != 512<<10 {
+		t.Errorf("queue limit on a friday is %d, want %d", got, 512<<10)
+	}
+	tick(m, f, time.Hour)
+	if got := m.globalBucket().Rate(); got != 1<<20 {
+		t.Errorf("global limit after the rule is %d, want %d", got, 1<<20)
+	}
+	tick(m, f, 12*time.Hour) // saturday, the queue is unlimited
+	if got := qb.Rate(); got != 0 {
+		t.Errorf("queue limit on the weekend is %d, want none", got)
+	}
+}
@@ -1,49 +1,263 @@
 package manager
 
 import (
-	//"encoding/json"
-
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/placeholder14032/download-manager/internal/download"
 	"github.com/placeholder14032/download-manager/internal/queue"
 )
 
-const SAVE_FILE = "save.json"
+const (
+	STATE_FILE       = "state.json"
+	LEGACY_SAVE_FILE = "save.json" // where we used to save, in the working directory
+	SCHEMA_VERSION   = 2
+	SAVE_DELAY       = time.Second      // changes that come in within this are saved together
+	PERIODIC_SAVE    = 30 * time.Second // while something is downloading so a crash loses little
+)
+
+const (
+	NEWER_SCHEMA_ERROR = "the state file has schema version %d but we only know up to %d"
+)
 
 type saveFile struct {
+	Version  int // SCHEMA_VERSION when it was written. missing (0) in old save.json files
 	LastDLID int64
-	LastQID int64
-	Queues []queue.Queue
+	LastQID  int64
+	MaxBandwidth int64 `json:",omitempty"` // the global limit
+	BandwidthSchedule []queue.BandwidthRule `json:",omitempty"`
+	Queues   []queue.Queue
 }
 
-func (m *Manager) WriteJson() {
-	file, err := os.Create(SAVE_FILE)
-	if err != nil {
-		return
+// $XDG_STATE_HOME/download-manager, which defaults to ~/.local/state/download-manager
+func StateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "." // nowhere better to go
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "download-manager")
+}
+
+func DefaultStateFile() string {
+	return filepath.Join(StateDir(), STATE_FILE)
+}
+
+func (m *Manager) stateFile() string {
+	if m.StateFile != "" {
+		return m.StateFile
+	}
+	return DefaultStateFile()
+}
+
+// saves everything. the file is replaced in one go so a crash in the
+// middle leaves the previous version and never half of a file
+func (m *Manager) WriteJson() error {
+	if m.Ephemeral {
+		return nil
+	}
+	// the proxy passwords go to the secrets
+	queues := make([]queue.Queue, len(m.qs))
+	copy(queues, m.qs)
+	for i := range queues {
+		queues[i].Proxy = queues[i].Proxy.Redacted()
 	}
-	defer file.Close()
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "\t")
 	data := saveFile{
+		Version:  SCHEMA_VERSION,
 		LastDLID: m.lastUID,
-		LastQID: m.lastQID,
-		Queues: m.qs,
+		LastQID:  m.lastQID,
+		MaxBandwidth: m.maxBandwidth,
+		BandwidthSchedule: m.bandwidthSchedule,
+		Queues:   queues,
 	}
-	encoder.Encode(data)
+	bts, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode the state: %v", err)
+	}
+	if err := writeFileAtomic(m.stateFile(), bts); err != nil {
+		return err
+	}
+	return m.writeSecrets()
 }
 
-func (m *Manager) LoadJson() {
-	file, err := os.Open(SAVE_FILE)
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
-		return
+		return err
+	}
+	// only does something if we didn't make it to the rename
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// the rename itself only survives a crash once the directory is synced
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// loads the saved state. a missing file is not an error, there is just
+// nothing yet. an old save.json in the working directory is picked up once
+// and moved over to the new place
+func (m *Manager) LoadJson() error {
+	if m.Ephemeral {
+		return nil
+	}
+	path := m.stateFile()
+	bts, err := os.ReadFile(path)
+	legacy := false
+	if os.IsNotExist(err) {
+		bts, err = os.ReadFile(LEGACY_SAVE_FILE)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		legacy = true
 	}
-	defer file.Close()
-	decoder := json.NewDecoder(file)
+	if err != nil {
+		return err
+	}
+
 	var data saveFile
-	decoder.Decode(&data)
+	if err := json.Unmarshal(bts, &data); err != nil {
+		// keep it around for whoever wants to fix it by hand and start over
+		if !legacy {
+			os.Rename(path, path+".corrupt")
+		}
+		return fmt.Errorf("failed to read %s, starting with an empty state: %v", path, err)
+	}
+	if err := migrate(&data); err != nil {
+		// writing over a file from a newer version would lose whatever it has
+		m.Ephemeral = true
+		return fmt.Errorf("%v. not saving anything this time", err)
+	}
 	m.lastQID = data.LastQID
 	m.lastUID = data.LastDLID
-	m.qs = data.Queues
+	m.maxBandwidth = data.MaxBandwidth
+	m.bandwidthSchedule = data.BandwidthSchedule
+	if data.Queues != nil {
+		m.qs = data.Queues
+	}
+	if err := m.loadSecrets(); err != nil {
+		fmt.Fprintln(os.Stderr, err) // the downloads are still worth having
+	}
+	if legacy {
+		fmt.Fprintf(os.Stderr, "moved the state from %s to %s\n", LEGACY_SAVE_FILE, path)
+		return m.WriteJson()
+	}
+	return nil
+}
+
+// brings an older file up to SCHEMA_VERSION one version at a time
+func migrate(data *saveFile) error {
+	if data.Version > SCHEMA_VERSION {
+		return fmt.Errorf(NEWER_SCHEMA_ERROR, data.Version, SCHEMA_VERSION)
+	}
+	for data.Version < SCHEMA_VERSION {
+		switch data.Version {
+		case 0:
+			// save.json didn't always have the counters right. make sure
+			// new ids don't collide with what is already there
+			for _, q := range data.Queues {
+				data.LastQID = max(data.LastQID, q.ID+1)
+				for _, dl := range q.DownloadLists {
+					data.LastDLID = max(data.LastDLID, dl.ID+1)
+				}
+			}
+			data.LastQID = max(data.LastQID, 1)
+			data.LastDLID = max(data.LastDLID, 1)
+		case 1:
+			// downloads got an order. they keep the one they were added in
+			for i := range data.Queues {
+				for j := range data.Queues[i].DownloadLists {
+					dl := data.Queues[i].DownloadLists[j]
+					dl.Position = dl.ID
+				}
+			}
+		}
+		data.Version++
+	}
+	return nil
+}
+
+// the downloads that were running when we stopped (or crashed) go on from
+// the parts they have, as far as their queues allow it. the rest of them
+// wait as paused
+func (m *Manager) resumeInterrupted() {
+	for i := range m.qs {
+		q := &m.qs[i]
+		interrupted := make([]*download.Download, 0)
+		for _, j := range q.Order() {
+			dl := q.DownloadLists[j]
+			if dl.Status == download.Downloading || dl.Status == download.Retrying {
+				dl.Status = download.Paused
+				interrupted = append(interrupted, dl)
+			}
+		}
+		for _, dl := range interrupted {
+			if q.Disabled || !q.IsOpen(m.now()) || !q.IsSafeToRunDL() {
+				continue
+			}
+			dl.Status = download.Downloading
+			m.prepareRun(dl, i)
+			go getDownloadRetried(dl, m.events)
+		}
+	}
 }
 
+// asks for a save soon. everything that changes within SAVE_DELAY
+// ends up in the same write
+func (m *Manager) markDirty() {
+	if m.saveTimer != nil {
+		return // one is already on its way
+	}
+	m.saveTimer = m.clock().NewTimer(SAVE_DELAY)
+}
+
+// nil (blocks forever in a select) when nothing needs saving
+func (m *Manager) saveDue() <-chan time.Time {
+	if m.saveTimer == nil {
+		return nil
+	}
+	return m.saveTimer.C()
+}
+
+func (m *Manager) save() {
+	m.saveTimer = nil
+	if err := m.WriteJson(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save the state: %v\n", err)
+	}
+}
+
+func (m *Manager) hasRunningDownloads() bool {
+	for _, q := range m.qs {
+		for _, dl := range q.DownloadLists {
+			if dl.Status == download.Downloading || dl.Status == download.Retrying {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package manager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a queue with two downloads the way every version so far saved it
+const (
+	OLD_QUEUE = `{"ID": 3, "Name": "old", "SaveDir": "/tmp", "MaxConcurrent": 2, "DownloadLists": [
+		{"ID": 5, "URL": "http://example.com/a.bin", "FilePath": "/tmp/a.bin", "Status": 2},
+		{"ID": 9, "URL": "http://example.com/b.bin", "FilePath": "/tmp/b.bin", "Status": 0}
+	]}`
+)
+
+// every kind of file LoadJson can find, and what has to be left of it
+func TestLoadJson(t *testing.T) {
+	tests := []struct {
+		name     string
+		legacy   string // save.json in the working directory
+		state    string // state.json
+		err      string // part of the error, empty if there shouldn't be one
+		queues   int
+		lastQID  int64
+		lastDLID int64
+		position map[int64]int64 // by download id
+		// what is on disk afterwards
+		stateVersion int // of state.json, 0 if it mustn't be there
+		corrupt      bool
+		ephemeral    bool
+	}{
+		{
+			name:   "nothing",
+			queues: 0, lastQID: 1, lastDLID: 1,
+		},
+		{
+			// no version and counters that are behind
+			name:   "legacy save.json",
+			legacy: `{"LastDLID": 2, "LastQID": 0, "Queues": [` + OLD_QUEUE + `]}`,
+			queues: 1, lastQID: 4, lastDLID: 10,
+			position:     map[int64]int64{5: 5, 9: 9},
+			stateVersion: SCHEMA_VERSION,
+		},
+		{
+			name:   "version 1",
+			state:  `{"Version": 1, "LastDLID": 12, "LastQID": 7, "Queues": [` + OLD_QUEUE + `]}`,
+			queues: 1, lastQID: 7, lastDLID: 12,
+			position:     map[int64]int64{5: 5, 9: 9},
+			stateVersion: 1, // only written again on the next save
+		},
+		{
+			name:   "state.json wins over save.json",
+			legacy: `{"Queues": [` + OLD_QUEUE + `]}`,
+			state:  `{"Version": 2, "LastDLID": 1, "LastQID": 1, "Queues": []}`,
+			queues: 0, lastQID: 1, lastDLID: 1,
+			stateVersion: SCHEMA_VERSION,
+		},
+		{
+			name:   "truncated",
+			state:  `{"Version": 2, "LastDLID": 12, "Queues": [` + OLD_QUEUE,
+			err:    "starting with an empty state",
+			queues: 0, lastQID: 1, lastDLID: 1,
+			corrupt: true,
+		},
+		{
+			name:   "not json",
+			state:  "\x00\x00\x00",
+			err:    "starting with an empty state",
+			queues: 0, lastQID: 1, lastDLID: 1,
+			corrupt: true,
+		},
+		{
+			name:   "newer version",
+			state:  `{"Version": 99, "LastDLID": 12, "LastQID": 7, "Queues": [` + OLD_QUEUE + `]}`,
+			err:    "schema version 99",
+			queues: 0, lastQID: 1, lastDLID: 1,
+			stateVersion: 99,
+			ephemeral:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			chdir(t, dir) // save.json is looked for in the working directory
+			stateFile := filepath.Join(dir, "state", STATE_FILE)
+			if tt.legacy != "" {
+				os.WriteFile(filepath.Join(dir, LEGACY_SAVE_FILE), []byte(tt.legacy), 0644)
+			}
+			if tt.state != "" {
+				os.MkdirAll(filepath.Dir(stateFile), 0700)
+				os.WriteFile(stateFile, []byte(tt.state), 0600)
+			}
+
+			m := &Manager{StateFile: stateFile}
+			m.init()
+			err := m.LoadJson()
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got %v, want an error with %q", err, tt.err)
+			}
+
+			if len(m.qs) != tt.queues || m.lastQID != tt.lastQID || m.lastUID != tt.lastDLID {
+				t.Errorf("%d queues, next ids %d and %d, want %d queues, %d and %d", len(m.qs), m.lastQID, m.lastUID, tt.queues, tt.lastQID, tt.lastDLID)
+			}
+			for _, q := range m.qs {
+				for _, dl := range q.DownloadLists {
+					if want, ok := tt.position[dl.ID]; ok && dl.Position != want {
+						t.Errorf("download %d is at %d, want %d", dl.ID, dl.Position, want)
+					}
+				}
+			}
+			if m.Ephemeral != tt.ephemeral {
+				t.Errorf("ephemeral is %v, want %v", m.Ephemeral, tt.ephemeral)
+			}
+
+			bts, err := os.ReadFile(stateFile)
+			if tt.stateVersion == 0 && err == nil {
+				t.Error("state.json is there")
+			}
+			if tt.stateVersion != 0 {
+				var saved saveFile
+				if err := json.Unmarshal(bts, &saved); err != nil {
+					t.Fatalf("state.json: %v", err)
+				}
+				if saved.Version != tt.stateVersion {
+					t.Errorf("state.json has version %d, want %d", saved.Version, tt.stateVersion)
+				}
+			}
+			corrupt, err := os.ReadFile(stateFile + ".corrupt")
+			if tt.corrupt && string(corrupt) != tt.state {
+				t.Errorf("state.json.corrupt has %q, want what state.json had: %v", corrupt, err)
+			}
+			if !tt.corrupt && err == nil {
+				t.Error("state.json.corrupt is there")
+			}
+			if tt.legacy != "" {
+				if _, err := os.Stat(filepath.Join(dir, LEGACY_SAVE_FILE)); err != nil {
+					t.Errorf("save.json is gone: %v", err)
+				}
+			}
+
+			// a newer file is never written over, whatever happens later
+			if tt.ephemeral {
+				m.WriteJson()
+				if after, _ := os.ReadFile(stateFile); string(after) != tt.state {
+					t.Error("the file of the newer version was written over")
+				}
+			}
+		})
+	}
+}
+
+// what was migrated comes back the same from the new file
+func TestLoadJsonMigratedTwice(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	os.WriteFile(filepath.Join(dir, LEGACY_SAVE_FILE), []byte(`{"Queues": [`+OLD_QUEUE+`]}`), 0644)
+	stateFile := filepath.Join(dir, "state", STATE_FILE)
+	first := &Manager{StateFile: stateFile}
+	first.init()
+	if err := first.LoadJson(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, LEGACY_SAVE_FILE))
+
+	again := &Manager{StateFile: stateFile}
+	again.init()
+	if err := again.LoadJson(); err != nil {
+		t.Fatal(err)
+	}
+	if len(again.qs) != 1 || len(again.qs[0].DownloadLists) != 2 {
+		t.Fatalf("got %d queues back", len(again.qs))
+	}
+	q := again.qs[0]
+	if q.ID != 3 || q.Name != "old" || q.MaxConcurrent != 2 {
+		t.Errorf("queue came back as %+v", q)
+	}
+	for i, want := range []struct {
+		id  int64
+		url string
+	}{{5, "http://example.com/a.bin"}, {9, "http://example.com/b.bin"}} {
+		dl := q.DownloadLists[i]
+		if dl.ID != want.id || dl.URL != want.url || dl.Position != want.id {
+			t.Errorf("download %d came back as id %d, %s at %d", i, dl.ID, dl.URL, dl.Position)
+		}
+	}
+	if again.lastQID != 4 || again.lastUID != 10 {
+		t.Errorf("next ids %d and %d, want 4 and 10", again.lastQID, again.lastUID)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "new", "dir")
+	path := filepath.Join(dir, STATE_FILE)
+	for _, data := range []string{"first", "second"} {
+		if err := writeFileAtomic(path, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(path); string(got) != data {
+			t.Errorf("got %q, want %q", got, data)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("the file is %o, want 600", perm)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+
+	// it can't be written, what was there stays
+	blocker := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(blocker, []byte("mine"), 0644)
+	if err := writeFileAtomic(filepath.Join(blocker, STATE_FILE), []byte("x")); err == nil {
+		t.Error("wrote into a file as if it was a directory")
+	}
+	if got, _ := os.ReadFile(blocker); string(got) != "mine" {
+		t.Errorf("the file in the way has %q now", got)
+	}
+}
+
+// runs the rest of the test in dir. go 1.23 has no t.Chdir yet
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
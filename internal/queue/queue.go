@@ -1,6 +1,8 @@
 package queue
 
 import (
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/placeholder14032/download-manager/internal/download"
@@ -11,16 +13,36 @@ type TimeRange struct {
 	End time.Time
 }
 
+// the window of every day between the times of day of Start and End
+func (r TimeRange) Window() Window {
+	return Window{
+		Start: ClockTime(r.Start.Hour()*60 + r.Start.Minute()),
+		End: ClockTime(r.End.Hour()*60 + r.End.Minute()),
+	}
+}
+
 type Queue struct{
 	ID int64
 	Name string
-	DownloadLists []download.Download
+	DownloadLists []*download.Download // pointers so a running download stays put when the list changes
 	SaveDir string
 	MaxConcurrent int64
 	MaxBandwidth int64
+	BandwidthSchedule []BandwidthRule `json:",omitempty"` // other limits at certain times. the first one that is on wins over MaxBandwidth
 	MaxRetries int64
+	ChunkRetries int64 // how many times a single chunk is retried. 0 means the default
 	HasTimeConstraint bool
-	TimeRange TimeRange
+	TimeRange TimeRange // the old single window. still works next to Windows
+	Windows []Window `json:",omitempty"` // it only runs while one of these is on
+	TimeZone string `json:",omitempty"` // of the windows and the bandwidth schedule. empty is the local one
+	StartAt time.Time // doesn't run before this. zero means no such thing
+	StopAt time.Time // doesn't run after this. zero means no such thing
+	Storage download.StorageMode // how new downloads of this queue keep their data on disk
+	OnRemoteChange download.ChangePolicy // restart or fail when a file changes on the server mid download
+	OnCollision download.CollisionPolicy // rename, overwrite, skip or fail when a file is already there
+	Request download.RequestOptions `json:",omitempty"` // headers, cookies and user agent of every download in it
+	Proxy download.ProxySettings `json:",omitempty"` // how its downloads get to the servers
+	TLS download.TLSSettings `json:",omitempty"` // extra cas, client certificates and pins, for all hosts or by host
 	// state management
 	Disabled bool // for time management
 }
@@ -39,14 +61,74 @@ func (q *Queue) IsSafeToRunDL() bool {
 	return q.countActiveDownloads() < int(q.MaxConcurrent)
 }
 
+// indices of DownloadLists in the order they run: higher priority first
+// and by position among the same priority
+func (q *Queue) Order() []int {
+	order := make([]int, len(q.DownloadLists))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		da, db := q.DownloadLists[order[a]], q.DownloadLists[order[b]]
+		if da.Priority != db.Priority {
+			return da.Priority > db.Priority
+		}
+		return da.Position < db.Position
+	})
+	return order
+}
+
+// the time zone of the schedule. an unknown one falls back to the local
+// zone, they are checked when the queue is added or edited
+func (q *Queue) Location() *time.Location {
+	if q.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// true if anything decides when the queue runs
+func (q *Queue) HasSchedule() bool {
+	return q.HasTimeConstraint || len(q.Windows) > 0 || !q.StartAt.IsZero() || !q.StopAt.IsZero()
+}
+
+// whether the queue is allowed to run at now
+func (q *Queue) IsOpen(now time.Time) bool {
+	if !q.StartAt.IsZero() && now.Before(q.StartAt) {
+		return false
+	}
+	if !q.StopAt.IsZero() && !now.Before(q.StopAt) {
+		return false
+	}
+	windows := q.Windows
+	if q.HasTimeConstraint {
+		windows = append(slices.Clone(windows), q.TimeRange.Window())
+	}
+	if len(windows) == 0 {
+		return true
+	}
+	local := now.In(q.Location())
+	for _, w := range windows {
+		if w.Contains(local) {
+			return true
+		}
+	}
+	return false
+}
+
 func (q *Queue) Init(ID int64) {
 	q.ID = ID
-	q.DownloadLists = make([]download.Download, 0)
+	q.DownloadLists = make([]*download.Download, 0)
 	q.SaveDir = "~/Downloads/" // default. TODO change to sane defaults
 	q.MaxConcurrent = 1
 	q.HasTimeConstraint = false
 	q.TimeRange = TimeRange{time.Time{}, time.Time{}}
 	q.MaxRetries = 1
+	q.Storage = download.PartFiles
 	q.Disabled = false
 }
 
@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const MINUTES_PER_DAY = 24 * 60
+
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// a time of day in minutes after midnight. written as HH:MM
+type ClockTime int
+
+func ParseClockTime(s string) (ClockTime, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time of day %q, expected HH:MM", s)
+	}
+	return ClockTime(t.Hour()*60 + t.Minute()), nil
+}
+
+func (c ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", int(c)/60, int(c)%60)
+}
+
+func (c ClockTime) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *ClockTime) UnmarshalText(text []byte) error {
+	parsed, err := ParseClockTime(string(text))
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// a part of the week: the days it starts on and the time of day it's on.
+// an End before Start goes past midnight, so "fri 22:00-06:00" ends saturday
+// morning. Start == End is the whole day
+type Window struct {
+	Days  []time.Weekday // empty means every day
+	Start ClockTime
+	End   ClockTime
+}
+
+func (w Window) hasDay(d time.Weekday) bool {
+	return len(w.Days) == 0 || slices.Contains(w.Days, d)
+}
+
+func (w Window) Contains(t time.Time) bool {
+	now := ClockTime(t.Hour()*60 + t.Minute())
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	switch {
+	case w.Start == w.End:
+		return w.hasDay(today)
+	case w.Start < w.End:
+		return w.hasDay(today) && w.Start <= now && now < w.End
+	default:
+		// the evening of a day it starts on or the morning after one
+		return (w.hasDay(today) && now >= w.Start) || (w.hasDay(yesterday) && now < w.End)
+	}
+}
+
+// "09:00-17:00", "mon-fri 09:00-17:00" or "sat,sun 22:00-06:00"
+func ParseWindow(s string) (Window, error) {
+	var w Window
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("bad window %q, expected [DAYS] HH:MM-HH:MM", s)
+	}
+	if len(fields) == 2 {
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return w, err
+		}
+		w.Days = days
+	}
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return w, fmt.Errorf("bad window %q, expected [DAYS] HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.Start, err = ParseClockTime(start); err != nil {
+		return w, err
+	}
+	if w.End, err = ParseClockTime(end); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+// "mon", "mon,wed" or "mon-fri". ranges can go around the weekend like "fri-mon"
+func parseDays(s string) ([]time.Weekday, error) {
+	days := make([]time.Weekday, 0)
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := parseDay(from)
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = parseDay(to); !ok {
+				return nil, fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			if !slices.Contains(days, d) {
+				days = append(days, d)
+			}
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseDay(s string) (time.Weekday, bool) {
+	i := slices.Index(dayNames, s)
+	if i == -1 && len(s) > 3 {
+		i = slices.Index(dayNames, s[:3]) // monday, tues...
+	}
+	return time.Weekday(i), i != -1
+}
+
+func (w Window) String() string {
+	times := w.Start.String() + "-" + w.End.String()
+	if len(w.Days) == 0 {
+		return times
+	}
+	names := make([]string, len(w.Days))
+	for i, d := range w.Days {
+		names[i] = dayNames[d]
+	}
+	return strings.Join(names, ",") + " " + times
+}
+
+// windows are written the way ParseWindow reads them
+func (w Window) MarshalText() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+func (w *Window) UnmarshalText(text []byte) error {
+	parsed, err := ParseWindow(string(text))
+	if err != nil {
+		return err
+	}
+	*w = parsed
+	return nil
+}
+
+// a bandwidth limit that is only on during a window
+type BandwidthRule struct {
+	Window Window
+	Limit  int64 // bytes per second. 0 means no limit at all while it's on
+}
+
+// the limit of the first rule that is on at t, otherwise base
+func ActiveLimit(rules []BandwidthRule, t time.Time, base int64) int64 {
+	for _, r := range rules {
+		if r.Window.Contains(t) {
+			return r.Limit
+		}
+	}
+	return base
+}
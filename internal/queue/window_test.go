@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// 2024-05-03 is a friday
+func at(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func mustWindow(t *testing.T, s string) Window {
+	t.Helper()
+	w, err := ParseWindow(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWindowContains(t *testing.T) {
+	tests := []struct {
+		window string
+		at     string
+		want   bool
+	}{
+		{"09:00-17:00", "2024-05-03 08:59", false},
+		{"09:00-17:00", "2024-05-03 09:00", true},
+		{"09:00-17:00", "2024-05-03 16:59", true},
+		{"09:00-17:00", "2024-05-03 17:00", false},
+		{"mon-fri 09:00-17:00", "2024-05-03 12:00", true},
+		{"mon-fri 09:00-17:00", "2024-05-04 12:00", false},
+		// past midnight
+		{"22:00-06:00", "2024-05-03 21:59", false},
+		{"22:00-06:00", "2024-05-03 22:00", true},
+		{"22:00-06:00", "2024-05-04 03:00", true},
+		{"22:00-06:00", "2024-05-04 06:00", false},
+		// the morning belongs to the day it started on
+		{"fri 22:00-06:00", "2024-05-04 05:00", true},
+		{"fri 22:00-06:00", "2024-05-03 05:00", false},
+		{"sat 22:00-06:00", "2024-05-04 05:00", false},
+		// the whole day
+		{"sat,sun 00:00-00:00", "2024-05-04 13:37", true},
+		{"sat,sun 00:00-00:00", "2024-05-03 23:59", false},
+		// around the weekend
+		{"fri-mon 10:00-11:00", "2024-05-06 10:30", true},
+		{"fri-mon 10:00-11:00", "2024-05-07 10:30", false},
+	}
+	for _, tt := range tests {
+		w := mustWindow(t, tt.window)
+		if got := w.Contains(at(tt.at)); got != tt.want {
+			t.Errorf("%q contains %s: got %v, want %v", tt.window, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	for _, s := range []string{"", "9-5", "25:00-06:00", "someday 10:00-11:00", "mon fri 10:00-11:00"} {
+		if _, err := ParseWindow(s); err == nil {
+			t.Errorf("ParseWindow(%q) should fail", s)
+		}
+	}
+}
+
+func TestWindowText(t *testing.T) {
+	w := mustWindow(t, "Monday-wed,sat 22:00-06:30")
+	text, _ := w.MarshalText()
+	if string(text) != "mon,tue,wed,sat 22:00-06:30" {
+		t.Fatalf("got %q", text)
+	}
+	var back Window
+	if err := back.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if back.String() != w.String() {
+		t.Errorf("got %q back, want %q", back, w)
+	}
+}
+
+func TestQueueIsOpen(t *testing.T) {
+	q := Queue{Windows: []Window{mustWindow(t, "mon-fri 22:00-06:00")}}
+	if !q.IsOpen(at("2024-05-03 23:00")) || q.IsOpen(at("2024-05-03 12:00")) {
+		t.Error("windows aren't followed")
+	}
+
+	q.StartAt = at("2024-05-10 00:00")
+	if q.IsOpen(at("2024-05-03 23:00")) {
+		t.Error("open before StartAt")
+	}
+	q.StartAt = time.Time{}
+	q.StopAt = at("2024-05-04 01:00")
+	if !q.IsOpen(at("2024-05-04 00:59")) || q.IsOpen(at("2024-05-04 01:00")) {
+		t.Error("StopAt isn't followed")
+	}
+}
+
+func TestQueueTimeZone(t *testing.T) {
+	q := Queue{Windows: []Window{mustWindow(t, "09:00-10:00")}, TimeZone: "Asia/Tokyo"}
+	// 09:30 in tokyo is 00:30 utc
+	if !q.IsOpen(at("2024-05-03 00:30")) {
+		t.Error("the window should be open at 09:30 in tokyo")
+	}
+	if q.IsOpen(at("2024-05-03 09:30")) {
+		t.Error("the window shouldn't go by utc")
+	}
+}
+
+func TestOldTimeRange(t *testing.T) {
+	start, _ := time.Parse("15:04", "22:00")
+	end, _ := time.Parse("15:04", "06:00")
+	q := Queue{HasTimeConstraint: true, TimeRange: TimeRange{Start: start, End: end}}
+	if !q.IsOpen(at("2024-05-04 02:00")) {
+		t.Error("an old window past midnight should be open at night")
+	}
+}
+
+func TestActiveLimit(t *testing.T) {
+	rules := []BandwidthRule{
+		{Window: mustWindow(t, "mon-fri 09:00-17:00"), Limit: 100},
+		{Window: mustWindow(t, "00:00-00:00"), Limit: 200},
+	}
+	if got := ActiveLimit(rules, at("2024-05-03 10:00"), 5); got != 100 {
+		t.Errorf("got %d, want the first rule", got)
+	}
+	if got := ActiveLimit(rules, at("2024-05-04 10:00"), 5); got != 200 {
+		t.Errorf("got %d, want the second rule", got)
+	}
+	if got := ActiveLimit(nil, at("2024-05-04 10:00"), 5); got != 5 {
+		t.Errorf("got %d, want the base", got)
+	}
+}
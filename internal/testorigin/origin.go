@@ -0,0 +1,302 @@
+// a fake server for the tests to download from. every file on it can
+// misbehave in its own way so the tests can see what the downloads do
+// when a real server does the same
+package testorigin
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the size of the pieces a slow body is sent in
+const SLOW_PIECE = 4 * 1024
+
+// a file on the origin and how it behaves. the zero value (besides Data)
+// is a well behaved server with ranges, an etag and a correct length
+type File struct {
+	Data []byte
+
+	NoRanges    bool  // no Accept-Ranges and Range headers are ignored
+	LengthDelta int64 // added to the Content-Length we claim. the body stays the same
+	NoLength    bool  // no Content-Length at all, the body is chunked. no ranges either
+	NoHead      bool  // HEAD is answered with 405
+	HideRanges  bool  // ranges work but Accept-Ranges is never sent
+
+	Disposition string // sent as Content-Disposition if not empty
+	Auth        string // the Authorization every request has to have. a 401 without it
+	BodyMD5     bool   // Content-MD5 of what the body has, for a range only that range
+
+	// the first Drops GET responses are cut off somewhere in the middle,
+	// as if the connection died
+	Drops int
+	// waited after every SLOW_PIECE of a body
+	Delay time.Duration
+	// waited before a HEAD is answered, so probes of several downloads overlap
+	HeadDelay time.Duration
+
+	// the first Busy GET requests are answered with BusyStatus (503 if 0)
+	// and RetryAfter if it isn't empty. HEAD requests always go through
+	Busy       int
+	BusyStatus int
+	RetryAfter string
+
+	etag    string
+	version int
+}
+
+// a running origin. close it when done
+type Origin struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	files     map[string]*File
+	redirects map[string]string
+	requests  map[string][]*http.Request // by path, only the headers are of any use
+	rand      *rand.Rand
+}
+
+func New() *Origin {
+	o := newOrigin()
+	o.Server = httptest.NewServer(http.HandlerFunc(o.serve))
+	return o
+}
+
+// like New but over https with a certificate nobody trusts. with clientCAs
+// it only talks to clients with a certificate signed by one of them
+func NewTLS(clientCAs *x509.CertPool) *Origin {
+	o := newOrigin()
+	o.Server = httptest.NewUnstartedServer(http.HandlerFunc(o.serve))
+	if clientCAs != nil {
+		o.Server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	}
+	o.StartTLS()
+	return o
+}
+
+func newOrigin() *Origin {
+	return &Origin{
+		files:     make(map[string]*File),
+		redirects: make(map[string]string),
+		requests:  make(map[string][]*http.Request),
+		rand:      rand.New(rand.NewSource(1)), // the same cuts every run
+	}
+}
+
+// writes the certificate of a NewTLS origin to dir as pem and returns the file
+func (o *Origin) WriteCA(dir string) string {
+	file := filepath.Join(dir, "origin-ca.pem")
+	bts := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: o.Certificate().Raw})
+	if err := os.WriteFile(file, bts, 0600); err != nil {
+		panic(err)
+	}
+	return file
+}
+
+// puts f at path (which starts with a /) and returns its url
+func (o *Origin) Add(path string, f *File) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f.etag = etagOf(f.Data, f.version)
+	o.files[path] = f
+	return o.URL + path
+}
+
+// replaces what is at path with a new version. it gets a new etag
+func (o *Origin) Change(path string, data []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f := o.files[path]
+	f.Data = data
+	f.version++
+	f.etag = etagOf(data, f.version)
+}
+
+// changes how the file at path behaves from the next request on.
+// the data should be changed with Change so it gets a new etag
+func (o *Origin) Set(path string, change func(f *File)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	change(o.files[path])
+}
+
+// requests for from are sent to to with a 302. to can be another
+// redirect. returns the url of from
+func (o *Origin) Redirect(from, to string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.redirects[from] = to
+	return o.URL + from
+}
+
+// how many requests (of any method) came for path
+func (o *Origin) Requests(path string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.requests[path])
+}
+
+// the requests that came for path so far
+func (o *Origin) RequestsFor(path string) []*http.Request {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]*http.Request(nil), o.requests[path]...)
+}
+
+func etagOf(data []byte, version int) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf(`"%x-%d"`, sum[:8], version)
+}
+
+func (o *Origin) serve(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	o.requests[r.URL.Path] = append(o.requests[r.URL.Path], r.Clone(r.Context()))
+	if to, ok := o.redirects[r.URL.Path]; ok {
+		o.mu.Unlock()
+		http.Redirect(w, r, to, http.StatusFound)
+		return
+	}
+	f, ok := o.files[r.URL.Path]
+	if !ok {
+		o.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	// everything this response needs, so the file can change while we send it
+	data, etag := f.Data, f.etag
+	busy := r.Method == http.MethodGet && f.Busy > 0
+	if busy {
+		f.Busy--
+	}
+	cut := -1
+	if r.Method == http.MethodGet && !busy && f.Drops > 0 {
+		f.Drops--
+		cut = o.rand.Intn(len(data)/2 + 1)
+	}
+	noRanges, lengthDelta, delay, headDelay := f.NoRanges, f.LengthDelta, f.Delay, f.HeadDelay
+	noLength, noHead, hideRanges := f.NoLength, f.NoHead, f.HideRanges
+	disposition, auth, bodyMD5 := f.Disposition, f.Auth, f.BodyMD5
+	status, retryAfter := f.BusyStatus, f.RetryAfter
+	o.mu.Unlock()
+
+	if auth != "" && r.Header.Get("Authorization") != auth {
+		w.Header().Set("WWW-Authenticate", `Basic realm="origin"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if noHead && r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method == http.MethodHead {
+		time.Sleep(headDelay)
+	}
+	if busy {
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	if disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
+	body := &bodyWriter{w: w, delay: delay, cut: cut, hideRanges: hideRanges}
+	if bodyMD5 {
+		body.md5Of = data
+	}
+	if noLength {
+		body.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			// flushing before anything is written stops the server from
+			// working out the length by itself
+			w.(http.Flusher).Flush()
+			body.Write(data)
+		}
+		return
+	}
+	if noRanges || lengthDelta != 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(int64(len(data))+lengthDelta, 10))
+		body.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			body.Write(data)
+		}
+		return
+	}
+	// takes care of Range, If-Range and HEAD for us
+	http.ServeContent(body, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// sends the body slowly or cuts it off
+type bodyWriter struct {
+	w          http.ResponseWriter
+	delay      time.Duration
+	cut        int // bytes until the connection dies. -1 is never
+	written    int
+	hideRanges bool
+	md5Of      []byte // the file, if the body gets a Content-MD5
+}
+
+func (b *bodyWriter) Header() http.Header {
+	return b.w.Header()
+}
+
+func (b *bodyWriter) WriteHeader(code int) {
+	if b.hideRanges {
+		b.w.Header().Del("Accept-Ranges")
+	}
+	if b.md5Of != nil && code < 300 {
+		part := b.md5Of
+		var start, end int
+		if _, err := fmt.Sscanf(b.w.Header().Get("Content-Range"), "bytes %d-%d/", &start, &end); err == nil {
+			part = part[start : end+1]
+		}
+		sum := md5.Sum(part)
+		b.w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	b.w.WriteHeader(code)
+}
+
+func (b *bodyWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		piece := p[:min(len(p), SLOW_PIECE)]
+		if b.cut >= 0 && b.written+len(piece) > b.cut {
+			piece = piece[:b.cut-b.written]
+			b.w.Write(piece)
+			b.w.(http.Flusher).Flush()
+			// makes the server drop the connection without finishing the body
+			panic(http.ErrAbortHandler)
+		}
+		m, err := b.w.Write(piece)
+		n += m
+		b.written += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+		if b.delay > 0 {
+			b.w.(http.Flusher).Flush()
+			time.Sleep(b.delay)
+		}
+	}
+	return n, nil
+}
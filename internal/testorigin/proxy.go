@@ -0,0 +1,247 @@
+package testorigin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+)
+
+// a proxy for the tests to send downloads through. it remembers where
+// everything it let through went so a test can tell it was used. with a
+// user it wants the password too
+type Proxy struct {
+	URL string // with the user and password in it
+
+	user, password string
+	server         *httptest.Server // the http one
+	listener       net.Listener     // the socks5 one
+
+	mu    sync.Mutex
+	hosts []string
+	conns []net.Conn // tunnels, closed with the proxy
+}
+
+// an http proxy. plain http requests are forwarded, everything else goes
+// through a CONNECT tunnel
+func NewHTTPProxy(user, password string) *Proxy {
+	p := &Proxy{user: user, password: password}
+	p.server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	p.URL = p.url("http", p.server.Listener.Addr())
+	return p
+}
+
+// a socks5 proxy that only knows CONNECT
+func NewSOCKS5Proxy(user, password string) *Proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	p := &Proxy{user: user, password: password, listener: l}
+	p.URL = p.url("socks5", l.Addr())
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serveSOCKS5(c)
+		}
+	}()
+	return p
+}
+
+func (p *Proxy) url(scheme string, addr net.Addr) string {
+	if p.user == "" {
+		return scheme + "://" + addr.String()
+	}
+	return scheme + "://" + p.user + ":" + p.password + "@" + addr.String()
+}
+
+// host:port of everything that went through, in order
+func (p *Proxy) Hosts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.hosts...)
+}
+
+func (p *Proxy) Close() {
+	if p.server != nil {
+		p.server.Close()
+	}
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+}
+
+func (p *Proxy) passed(host string, conns ...net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts = append(p.hosts, host)
+	p.conns = append(p.conns, conns...)
+}
+
+func (p *Proxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.user+":"+p.password))
+	if p.user != "" && r.Header.Get("Proxy-Authorization") != want {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		c, buffered, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		p.passed(r.Host, c, target)
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipe(c, buffered, target)
+		return
+	}
+	p.passed(r.Host)
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Authorization")
+	resp, err := (&http.Transport{}).RoundTrip(out)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// rfc 1928 and 1929, only as much as go's client uses
+func (p *Proxy) serveSOCKS5(c net.Conn) {
+	r := bufio.NewReader(c)
+	fail := func() { c.Close() }
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil || head[0] != 5 {
+		fail()
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		fail()
+		return
+	}
+	method := byte(0) // no login
+	if p.user != "" {
+		method = 2 // user and password
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		c.Write([]byte{5, 0xff})
+		fail()
+		return
+	}
+	c.Write([]byte{5, method})
+	if method == 2 {
+		user, pass, ok := readLogin(r)
+		if !ok || user != p.user || pass != p.password {
+			c.Write([]byte{1, 1})
+			fail()
+			return
+		}
+		c.Write([]byte{1, 0})
+	}
+
+	request := make([]byte, 4) // version, command, reserved, address type
+	if _, err := io.ReadFull(r, request); err != nil || request[1] != 1 {
+		c.Write([]byte{5, 7, 0, 1, 0, 0, 0, 0, 0, 0})
+		fail()
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1, 4:
+		ip := make([]byte, 4)
+		if request[3] == 4 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			fail()
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n, err := r.ReadByte()
+		name := make([]byte, n)
+		if _, err2 := io.ReadFull(r, name); err != nil || err2 != nil {
+			fail()
+			return
+		}
+		host = string(name)
+	default:
+		c.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		fail()
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		fail()
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		fail()
+		return
+	}
+	p.passed(addr, c, target)
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(c, r, target)
+}
+
+func readLogin(r *bufio.Reader) (string, string, bool) {
+	if version, err := r.ReadByte(); err != nil || version != 1 {
+		return "", "", false
+	}
+	field := func() (string, bool) {
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", false
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return string(b), err == nil
+	}
+	user, ok := field()
+	if !ok {
+		return "", "", false
+	}
+	pass, ok := field()
+	return user, pass, ok
+}
+
+// copies both ways until one side is done. in is what was already read
+// from c
+func pipe(c net.Conn, in io.Reader, target net.Conn) {
+	go func() {
+		io.Copy(target, in)
+		target.Close()
+	}()
+	io.Copy(c, target)
+	c.Close()
+}
@@ -1,11 +1,18 @@
 package util
 
-import "strconv"
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/placeholder14032/download-manager/internal/download"
+	"github.com/placeholder14032/download-manager/internal/queue"
+)
 
 type RequestType int
 
 const (
 	// download ones: these all have empty body and only OK/FAIL status
+	// except AddDownload which answers with the DownloadBody of the new download
 	AddDownload RequestType = iota
 	StartDownload
 	PauseDownload
@@ -14,6 +21,7 @@ const (
 	RetryDownload
 	DeleteDownload
 	// queue ones: these also have empty body
+	// except AddQueue which answers with the QueueBody of the new queue
 	AddQueue
 	DeleteQueue
 	EditQueue // will take parameters inside the body
@@ -25,7 +33,13 @@ const (
 	// MaxBandWidth
 	//
 	GetQueues // pass all of the queues with their downloads
-	GetDownloads // pass all of the downloads
+	GetDownloads // pass all of the downloads (in the order they'll run)
+	// ordering inside a queue. empty answers
+	ReorderDownload
+	SetPriority
+	MoveDownload // to another queue. empty answer
+	SetBandwidth // changes a limit while things are downloading. empty answer
+	SetBandwidthSchedule // the limits for certain times of the week. empty answer
 )
 
 var typeNames = []string{
@@ -41,10 +55,15 @@ var typeNames = []string{
 	"Edit Queue",
 	"Get Queues",
 	"Get Downlaods",
+	"Reorder Download",
+	"Set Priority",
+	"Move Download",
+	"Set Bandwidth",
+	"Set Bandwidth Schedule",
 }
 
 func (r RequestType) String() string{
-	if 0 <= r && r <= GetDownloads {
+	if 0 <= r && int(r) < len(typeNames) {
 		return typeNames[r]
 	}
 	return strconv.Itoa(int(r))
@@ -61,7 +80,11 @@ type Request struct {
 type BodyAddDownload struct {
 	URL string
 	QueueID int64
-	FileName string // can be empty and I dunno maybe get it from the url
+	FileName string // can be empty. then the server or the url decides
+	Checksum string // optional. <algorithm>:<hex digest> e.g. sha256:9f86d0...
+	Priority int64 // optional. higher ones run first
+	Request download.RequestOptions // optional. headers, cookies and user agent on top of the ones of the queue
+	Auth download.Credentials // optional. kept out of the state file
 }
 
 type BodyModDownload struct {
@@ -69,3 +92,114 @@ type BodyModDownload struct {
 	ID int64 // download id
 }
 
+
+// where to move a download in the order of its queue
+type Move int
+
+const (
+	MoveUp Move = iota // one step earlier
+	MoveDown
+	MoveTop // runs next
+	MoveBottom
+)
+
+var moveNames = []string{"up", "down", "top", "bottom"}
+
+func (mv Move) String() string {
+	if 0 <= mv && int(mv) < len(moveNames) {
+		return moveNames[mv]
+	}
+	return strconv.Itoa(int(mv))
+}
+
+func ParseMove(s string) (Move, bool) {
+	for i, name := range moveNames {
+		if name == s {
+			return Move(i), true
+		}
+	}
+	return 0, false
+}
+
+// moves are written as their names in json
+func (mv Move) MarshalText() ([]byte, error) {
+	return []byte(mv.String()), nil
+}
+
+func (mv *Move) UnmarshalText(text []byte) error {
+	parsed, ok := ParseMove(string(text))
+	if !ok {
+		return fmt.Errorf("unknown move %q (use up, down, top or bottom)", text)
+	}
+	*mv = parsed
+	return nil
+}
+
+type BodyReorderDownload struct {
+	ID int64
+	Move Move
+}
+
+type BodySetPriority struct {
+	ID int64
+	Priority int64
+}
+
+type BodyMoveDownload struct {
+	ID int64
+	QueueID int64 // where it goes
+	MoveFiles bool // also move what is already on disk to the directory of that queue
+}
+
+// which limit a SetBandwidth request changes
+type Scope int
+
+const (
+	ScopeGlobal Scope = iota // everything together
+	ScopeQueue
+	ScopeDownload
+)
+
+var scopeNames = []string{"global", "queue", "download"}
+
+func (sc Scope) String() string {
+	if 0 <= sc && int(sc) < len(scopeNames) {
+		return scopeNames[sc]
+	}
+	return strconv.Itoa(int(sc))
+}
+
+func ParseScope(s string) (Scope, bool) {
+	for i, name := range scopeNames {
+		if name == s {
+			return Scope(i), true
+		}
+	}
+	return 0, false
+}
+
+func (sc Scope) MarshalText() ([]byte, error) {
+	return []byte(sc.String()), nil
+}
+
+func (sc *Scope) UnmarshalText(text []byte) error {
+	parsed, ok := ParseScope(string(text))
+	if !ok {
+		return fmt.Errorf("unknown scope %q (use global, queue or download)", text)
+	}
+	*sc = parsed
+	return nil
+}
+
+type BodySetBandwidth struct {
+	Scope Scope
+	ID int64 // of the queue or download. ignored for the global limit
+	Limit int64 // bytes per second. 0 means no limit
+}
+
+// replaces the whole schedule of the global limit or of a queue
+type BodySetBandwidthSchedule struct {
+	Scope Scope // global or queue
+	ID int64
+	Rules []queue.BandwidthRule // empty removes the schedule
+}
@@ -30,7 +30,18 @@ type StaticDownloadList struct {
 	Downloads []DownloadBody
 }
 
+// what kind of failure it was. mostly so clients like the http api
+// can pick a status code without parsing the message
+type FailureKind int
+
+const (
+	BadRequest FailureKind = iota // the request itself doesn't make sense
+	NotFound // no download or queue with that id
+	Conflict // it can't be done in the current state (running, full queue, ...)
+)
+
 type FailureMessage struct {
 	Message string
+	Kind FailureKind
 }
 
@@ -1,5 +1,7 @@
 package util
 
+import "github.com/placeholder14032/download-manager/internal/download"
+
 type EventType int
 
 const (
@@ -7,10 +9,36 @@ const (
 	Resuming
 	Finished
 	Failed
+	// these two are only published by the manager to its subscribers
+	Started
+	Progress // sent every second for each running download
 )
 
+var eventNames = []string{
+	"pausing",
+	"resuming",
+	"finished",
+	"failed",
+	"started",
+	"progress",
+}
+
+func (t EventType) String() string {
+	if 0 <= t && int(t) < len(eventNames) {
+		return eventNames[t]
+	}
+	return "unknown"
+}
+
 type Event struct {
 	Type EventType
 	DownloadID int64
+	Reason string // only for Failed. the error that made the download fail
+	Err error `json:"-"` // same thing but for the manager to check with errors.Is
+	// the following are filled in when the manager publishes the event
+	Status download.State // the state of the download after the event
+	Progress float64 // percentage
+	Speed string // formatted like in DownloadBody
+	Downloaded int64 // bytes so far
+	Size int64 // -1 if unknown, like in DownloadBody
 }
-
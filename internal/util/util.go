@@ -1,6 +1,8 @@
 package util
 
 import (
+	"time"
+
 	"github.com/placeholder14032/download-manager/internal/download"
 	"github.com/placeholder14032/download-manager/internal/queue"
 )
@@ -23,9 +25,21 @@ type QueueBody struct {
 	Name string // queues name? might be optional
 	MaxSimul int64
 	MaxBandWidth int64
+	BandwidthSchedule []queue.BandwidthRule // MaxBandWidth is used when none of these is on
 	MaxRetries int64
+	ChunkRetries int64 // retries for a single chunk with backoff. 0 means default
 	HasTimeConstraint bool
 	TimeRange queue.TimeRange
+	Windows []queue.Window // more windows, with days. it runs while any of them is on
+	TimeZone string // of the windows, e.g. Europe/Berlin. empty is local
+	StartAt time.Time // optional. doesn't run before
+	StopAt time.Time // optional. doesn't run after
+	Storage download.StorageMode // part files or a preallocated file
+	OnRemoteChange download.ChangePolicy // restart or fail when a file changes on the server
+	OnCollision download.CollisionPolicy // rename, overwrite, skip or fail when a file is already there
+	Request download.RequestOptions // headers, cookies and user agent of every download in it
+	Proxy download.ProxySettings // system (the default), none or a proxy url, and hosts that skip it
+	TLS download.TLSSettings // extra cas, client certificate, pins and the insecure switch. by host in Hosts
 }
 
 // similar thing for a download
@@ -36,7 +50,15 @@ type DownloadBody struct {
 	Status download.State
 	Progress float64 // percentage
 	Speed string // formatted string for speed
+	QueueID int64
 	QueueName string
+	Checksum string // the checksum the finished file was verified against. empty if it wasn't
+	FailReason string // why it failed, if it did
+	Priority int64 // higher ones run first
+	MaxBandwidth int64 // its own limit in bytes per second. 0 if it has none
+	Downloaded int64 // bytes so far
+	Size int64 // of the whole file. -1 if the server didn't say, then Progress stays 0
+	Auth string // how it logs in, like "basic alice". never the password or token
 }
 
 // this is a function used to remove an element from a slice
@@ -59,7 +59,7 @@ func DrawNewDownloadPage(app *tview.Application) {
 	queueDropDown.SetFieldBackgroundColor(tcell.ColorBlack)
 	isQueueDropDownOpen := false
 	queueDropDown.SetSelectedFunc(func(text string, index int) {
-		controller.AddDownload(urlDownload, allQueues[index].ID, nameDownload)
+		controller.AddDownload(urlDownload, allQueues[index].ID, nameDownload, "")
 		drawNewQueue(app)
 	})
 	nameDownloadInput.SetDoneFunc(func(key tcell.Key) {
@@ -12,6 +12,8 @@ import (
 )
 
 var allDownloadFlex *tview.Flex
+var allDownloadTable *tview.Table
+var allDownloads []util.DownloadBody // row i+1 of the table is allDownloads[i]
 
 func DrawAllDownloads(app *tview.Application) {
 	// while true (call the function for the array of all Download bodies -> wait for the answer -> get the answer
@@ -48,7 +50,7 @@ func DrawAllDownloads(app *tview.Application) {
 	headers := []string{"Name", "URL", "Queue", "Status", "Progress", "Speed"}
 	allDownloadFlex = tview.NewFlex()
 
-	allDownloadTable := tview.NewTable()
+	allDownloadTable = tview.NewTable()
 
 	for i, header := range headers {
 		tempTableCell := tview.NewTableCell(header).
@@ -56,7 +58,7 @@ func DrawAllDownloads(app *tview.Application) {
 		allDownloadTable.SetCell(0, i, tempTableCell)
 	}
 
-	allDownloads := controller.GetAllDownloads()
+	allDownloads = controller.GetAllDownloads()
 
 	for i, download := range allDownloads {
 		var downloadNameCell, URLCell, queueNameCell *tview.TableCell
@@ -80,7 +82,7 @@ func DrawAllDownloads(app *tview.Application) {
 		allDownloadTable.SetCell(i+1, 2, queueNameCell)
 		statusCell := tview.NewTableCell(convertStateToString(download.Status)).SetSelectable(false).SetExpansion(1)
 		allDownloadTable.SetCell(i+1, 3, statusCell)
-		progressCell := tview.NewTableCell(strconv.FormatFloat(download.Progress, 'f', 2, 64)).SetSelectable(false).SetExpansion(1)
+		progressCell := tview.NewTableCell(progressText(download.Progress, download.Downloaded, download.Size)).SetSelectable(false).SetExpansion(1)
 		allDownloadTable.SetCell(i+1, 4, progressCell)
 		speedCell := tview.NewTableCell(download.Speed).SetSelectable(false).SetExpansion(1)
 		allDownloadTable.SetCell(i+1, 5, speedCell)
@@ -109,14 +111,14 @@ func DrawAllDownloads(app *tview.Application) {
 		case tcell.KeyCtrlE:
 			editMode = !editMode
 			if editMode {
-				footer.SetText("Ctrl+S to Start/Stop | Ctrl+R to retry | Ctrl+C to cancel | Ctrl+D to delete")
+				footer.SetText("Ctrl+S to Start/Stop | Ctrl+R to retry | Ctrl+C to cancel | Ctrl+D to delete | [ ] { } to move | + - priority | m to next queue")
 			} else {
 				footer.SetText("Press arrow keys to navigate | Ctrl+E to Edit | f[1,2,3] to chnage tabs | Ctrl+q to quit")
 			}
 			return nil
 		case tcell.KeyCtrlR:
 			if editMode {
-				if tempDownload.Status == download.Cancelled {
+				if tempDownload.Status == download.Cancelled || tempDownload.Status == download.Failed {
 					controller.ModDownload(util.RetryDownload, tempDownload.ID)
 				}
 				return nil
@@ -124,7 +126,7 @@ func DrawAllDownloads(app *tview.Application) {
 		case tcell.KeyCtrlS:
 			if editMode {
 				if tempDownload.Status == download.Paused {
-					controller.ModDownload(util.ResumeDownload, selectedQueue.ID)
+					controller.ModDownload(util.ResumeDownload, tempDownload.ID)
 				} else if tempDownload.Status == download.Downloading {
 					controller.ModDownload(util.PauseDownload, tempDownload.ID)
 				}
@@ -141,6 +143,27 @@ func DrawAllDownloads(app *tview.Application) {
 				controller.ModDownload(util.CancelDownload, tempDownload.ID)
 				return nil
 			}
+		case tcell.KeyRune:
+			if !editMode {
+				break
+			}
+			moves := map[rune]util.Move{'[': util.MoveUp, ']': util.MoveDown, '{': util.MoveTop, '}': util.MoveBottom}
+			if move, ok := moves[event.Rune()]; ok {
+				controller.ReorderDownload(tempDownload.ID, move)
+			} else if event.Rune() == '+' {
+				controller.SetPriority(tempDownload.ID, tempDownload.Priority+1)
+			} else if event.Rune() == '-' {
+				controller.SetPriority(tempDownload.ID, tempDownload.Priority-1)
+			} else if event.Rune() == 'm' {
+				// what it already has on disk goes along
+				controller.MoveDownload(tempDownload.ID, nextQueueID(tempDownload.QueueID), true)
+			} else {
+				break
+			}
+			// the order changed so everything is drawn again, still on the same download
+			DrawAllDownloads(app)
+			selectDownload(tempDownload.ID)
+			return nil
 		}
 		return event
 	})
@@ -149,6 +172,61 @@ func DrawAllDownloads(app *tview.Application) {
 	StatePanel = "second"
 }
 
+// the queue after qid, going around to the first one at the end
+func nextQueueID(qid int64) int64 {
+	queues := controller.GetQueues()
+	for i := range queues {
+		if queues[i].ID == qid {
+			return queues[(i+1)%len(queues)].ID
+		}
+	}
+	return qid
+}
+
+// puts the selection on the row of the download
+func selectDownload(id int64) {
+	for i := range allDownloads {
+		if allDownloads[i].ID == id {
+			allDownloadTable.Select(i+1, 0)
+			return
+		}
+	}
+}
+
+// updates the row of the download in the event without asking the manager
+// for everything again. downloads that aren't in the table yet show up the
+// next time the page is drawn
+func updateDownloadRow(e util.Event) {
+	if StatePanel != "second" || allDownloadTable == nil {
+		return
+	}
+	for i := range allDownloads {
+		if allDownloads[i].ID != e.DownloadID {
+			continue
+		}
+		allDownloads[i].Status = e.Status
+		allDownloads[i].Progress = e.Progress
+		allDownloads[i].Speed = e.Speed
+		allDownloads[i].Downloaded = e.Downloaded
+		allDownloads[i].Size = e.Size
+		if e.Type == util.Failed {
+			allDownloads[i].FailReason = e.Reason
+		}
+		allDownloadTable.GetCell(i+1, 3).SetText(convertStateToString(e.Status))
+		allDownloadTable.GetCell(i+1, 4).SetText(progressText(e.Progress, e.Downloaded, e.Size))
+		allDownloadTable.GetCell(i+1, 5).SetText(e.Speed)
+		return
+	}
+}
+
+// the bytes instead of a percentage when we don't know how big the file is
+func progressText(progress float64, downloaded, size int64) string {
+	if size < 0 {
+		return download.FormatBytes(downloaded)
+	}
+	return strconv.FormatFloat(progress, 'f', 2, 64)
+}
+
 func convertStateToString(state download.State) string {
 	states := []string{
 		"Pending",
@@ -3,11 +3,24 @@ package ui
 import (
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+
+	"github.com/placeholder14032/download-manager/internal/util"
 )
 
-func Main() {
+// events is where the live updates come from. it can be nil
+func Main(events <-chan util.Event) {
 	app := tview.NewApplication()
 
+	if events != nil {
+		go func() {
+			for e := range events {
+				app.QueueUpdateDraw(func() {
+					updateDownloadRow(e)
+				})
+			}
+		}()
+	}
+
 	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		// Handle global keys
 		switch event.Key() {